@@ -106,7 +106,7 @@ func main() {
 					newCount := currentCount + 1
 					postCommenterCount[post.ID] = newCount
 
-					newName := utils.GetCommenterName(newCount, consts.Names0, consts.Names1)
+					newName := utils.GetCommenterName(newCount, consts.Names0, consts.Names1, " ")
 					name = newName
 
 					if _, ok := nameCache[post.ID]; !ok {
@@ -0,0 +1,28 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"treehollow-v3-backend/pkg/base"
+	"treehollow-v3-backend/pkg/config"
+	"treehollow-v3-backend/pkg/logger"
+	"treehollow-v3-backend/pkg/media"
+	"treehollow-v3-backend/pkg/utils"
+)
+
+// 清理已删除内容的图片以及images_path和存储桶中没有被引用的文件。
+// 默认只输出报告，加上-delete参数后才会实际删除。
+func main() {
+	doDelete := flag.Bool("delete", false, "actually delete files instead of a dry-run report")
+	flag.Parse()
+
+	logger.InitLog("media-gc.log")
+	config.InitConfigFile()
+	base.InitDb()
+
+	log.Println("starting media gc...")
+	report, err := media.RunGC(!*doDelete)
+	utils.FatalErrorHandle(&err, "media gc failed")
+	fmt.Println(report.ToString())
+}
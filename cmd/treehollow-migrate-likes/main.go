@@ -0,0 +1,55 @@
+package main
+
+import (
+	"gorm.io/gorm"
+	"log"
+	"treehollow-v3-backend/pkg/base"
+	"treehollow-v3-backend/pkg/config"
+	"treehollow-v3-backend/pkg/logger"
+	"treehollow-v3-backend/pkg/utils"
+)
+
+// 点赞与关注分开后，回填likes表以及posts表的like_num和follow_num。
+// 旧版本中回复树洞会自动关注，因此只把既不是洞主、也没有回复过的用户的关注视为点赞；
+// 关注保持不变。计数按表中的记录重新计算，因此可以重复执行，但应在新版本上线前执行，
+// 否则上线后用户主动关注的树洞也会被视为点赞。执行后重启服务以重建热榜。
+
+const batchSize = 3000
+
+func migrateBatch(start int32) {
+	end := start + batchSize - 1
+	err := base.GetDb(false).Transaction(func(tx *gorm.DB) error {
+		err := tx.Exec("INSERT IGNORE INTO likes (user_id, post_id, created_at) "+
+			"SELECT attentions.user_id, attentions.post_id, posts.created_at FROM attentions "+
+			"JOIN posts ON posts.id = attentions.post_id "+
+			"WHERE attentions.post_id BETWEEN ? AND ? AND attentions.user_id != posts.user_id "+
+			"AND NOT EXISTS (SELECT 1 FROM comments WHERE comments.post_id = attentions.post_id "+
+			"AND comments.user_id = attentions.user_id)", start, end).Error
+		if err != nil {
+			return err
+		}
+		return tx.Exec("UPDATE posts SET "+
+			"like_num = (SELECT COUNT(*) FROM likes WHERE likes.post_id = posts.id), "+
+			"follow_num = (SELECT COUNT(*) FROM attentions WHERE attentions.post_id = posts.id) "+
+			"WHERE posts.id BETWEEN ? AND ?", start, end).Error
+	})
+	utils.FatalErrorHandle(&err, "error migrating likes!")
+}
+
+func main() {
+	logger.InitLog("migration.log")
+	config.InitConfigFile()
+	log.Println("starting like/follow migration...")
+
+	base.InitDb()
+	base.AutoMigrateDb()
+
+	var maxID int32
+	err := base.GetDb(true).Model(&base.Post{}).Select("COALESCE(MAX(id), 0)").Scan(&maxID).Error
+	utils.FatalErrorHandle(&err, "error reading posts!")
+	for start := int32(1); start <= maxID; start += batchSize {
+		migrateBatch(start)
+		log.Printf("migrated posts up to #%d\n", start+batchSize-1)
+	}
+	log.Println("likes migrated")
+}
@@ -0,0 +1,106 @@
+package main
+
+import (
+	"gorm.io/gorm"
+	"log"
+	"treehollow-v3-backend/pkg/base"
+	"treehollow-v3-backend/pkg/config"
+	"treehollow-v3-backend/pkg/logger"
+	"treehollow-v3-backend/pkg/utils"
+)
+
+// 将posts和comments表中旧的单张图片迁移到post_media表。
+// 迁移完成的记录会清空file_path，因此可以重复执行。
+
+const batchSize = 3000
+
+func migratePost() (count int) {
+	var posts []base.Post
+	err := base.GetDb(true).Where("file_path != ''").Order("id asc").
+		Limit(batchSize).Find(&posts).Error
+	utils.FatalErrorHandle(&err, "error reading posts!")
+	count = len(posts)
+	if count == 0 {
+		return
+	}
+	media := make([]base.PostMedia, 0, count)
+	pids := make([]int32, 0, count)
+	for _, post := range posts {
+		media = append(media, base.PostMedia{
+			UserID:       post.UserID,
+			PostID:       post.ID,
+			CommentID:    0,
+			Position:     0,
+			FilePath:     post.FilePath,
+			FileMetadata: post.FileMetadata,
+			AltText:      "",
+			CreatedAt:    post.CreatedAt,
+		})
+		pids = append(pids, post.ID)
+	}
+	err = base.GetDb(false).Transaction(func(tx *gorm.DB) error {
+		if err2 := tx.Create(&media).Error; err2 != nil {
+			return err2
+		}
+		return tx.Unscoped().Model(&base.Post{}).Where("id in ?", pids).
+			UpdateColumns(map[string]interface{}{"file_path": "", "file_metadata": "{}"}).Error
+	})
+	utils.FatalErrorHandle(&err, "error writing post media!")
+	return
+}
+
+func migrateComment() (count int) {
+	var comments []base.Comment
+	err := base.GetDb(true).Where("file_path != ''").Order("id asc").
+		Limit(batchSize).Find(&comments).Error
+	utils.FatalErrorHandle(&err, "error reading comments!")
+	count = len(comments)
+	if count == 0 {
+		return
+	}
+	media := make([]base.PostMedia, 0, count)
+	cids := make([]int32, 0, count)
+	for _, comment := range comments {
+		media = append(media, base.PostMedia{
+			UserID:       comment.UserID,
+			PostID:       comment.PostID,
+			CommentID:    comment.ID,
+			Position:     0,
+			FilePath:     comment.FilePath,
+			FileMetadata: comment.FileMetadata,
+			AltText:      "",
+			CreatedAt:    comment.CreatedAt,
+		})
+		cids = append(cids, comment.ID)
+	}
+	err = base.GetDb(false).Transaction(func(tx *gorm.DB) error {
+		if err2 := tx.Create(&media).Error; err2 != nil {
+			return err2
+		}
+		return tx.Unscoped().Model(&base.Comment{}).Where("id in ?", cids).
+			UpdateColumns(map[string]interface{}{"file_path": "", "file_metadata": "{}"}).Error
+	})
+	utils.FatalErrorHandle(&err, "error writing comment media!")
+	return
+}
+
+func migrate(foo func() int) {
+	total := 0
+	for count := foo(); count != 0; count = foo() {
+		total += count
+		log.Printf("migrated %d rows\n", total)
+	}
+}
+
+func main() {
+	logger.InitLog("migration.log")
+	config.InitConfigFile()
+	log.Println("starting post media migration...")
+
+	base.InitDb()
+
+	migrate(migratePost)
+	log.Println("posts migrated")
+	migrate(migrateComment)
+	log.Println("comments migrated")
+}
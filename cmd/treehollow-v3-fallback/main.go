@@ -120,6 +120,10 @@ func main() {
 	//v3 fallback
 	r.POST("/v3/config/set_push", serviceFallBack)
 	r.GET("/v3/config/get_push", serviceFallBack)
+	r.POST("/v3/config/set_settings", serviceFallBack)
+	r.GET("/v3/config/get_settings", serviceFallBack)
+	r.POST("/v3/config/set_digest", serviceFallBack)
+	r.GET("/v3/config/get_digest", serviceFallBack)
 	r.GET("/v3/contents/system_msg", serviceFallBack)
 	r.GET("/v3/contents/post/list", serviceFallBack)
 	r.GET("/v3/contents/post/randomlist", serviceFallBack)
@@ -127,10 +131,39 @@ func main() {
 	r.GET("/v3/contents/search", serviceFallBack)
 	r.GET("/v3/contents/post/attentions", serviceFallBack)
 	r.GET("/v3/contents/my_msgs", serviceFallBack)
+	r.GET("/v3/contents/my_msgs/grouped", serviceFallBack)
+	r.GET("/v3/contents/my_msgs/unread_count", serviceFallBack)
 	r.GET("/v3/contents/search/attentions", serviceFallBack)
+	r.POST("/v3/upload/image", serviceFallBack)
+	r.POST("/v3/upload/create", serviceFallBack)
+	r.POST("/v3/upload/chunk", serviceFallBack)
+	r.GET("/v3/upload/status", serviceFallBack)
 	r.POST("/v3/send/post", serviceFallBack)
 	r.POST("/v3/send/vote", serviceFallBack)
 	r.POST("/v3/send/comment", serviceFallBack)
+	r.GET("/v3/contents/boards", serviceFallBack)
+	r.POST("/v3/series/add", serviceFallBack)
+	r.POST("/v3/series/remove", serviceFallBack)
+	r.GET("/v3/series/list", serviceFallBack)
+	r.POST("/v3/authorship/issue", serviceFallBack)
+	r.GET("/v3/authorship/verify", serviceFallBack)
+	r.POST("/v3/edit/comment_controls", serviceFallBack)
+	r.POST("/v3/edit/block_commenter", serviceFallBack)
+	r.POST("/v3/pm/send", serviceFallBack)
+	r.GET("/v3/pm/conversations", serviceFallBack)
+	r.GET("/v3/pm/messages", serviceFallBack)
+	r.POST("/v3/pm/block", serviceFallBack)
+	r.POST("/v3/pm/report", serviceFallBack)
+	r.POST("/v3/edit/reaction", serviceFallBack)
+	r.POST("/v3/edit/like", serviceFallBack)
+	r.GET("/v3/attention/collections", serviceFallBack)
+	r.POST("/v3/attention/collection/create", serviceFallBack)
+	r.POST("/v3/attention/collection/rename", serviceFallBack)
+	r.POST("/v3/attention/collection/delete", serviceFallBack)
+	r.GET("/v3/attention/collection/export", serviceFallBack)
+	r.POST("/v3/attention/edit", serviceFallBack)
+	r.POST("/v3/edit/msgs/read", serviceFallBack)
+	r.POST("/v3/edit/msgs/read_all", serviceFallBack)
 	r.POST("/v3/edit/attention", serviceFallBack)
 	r.POST("/v3/edit/report/post", serviceFallBack)
 	r.POST("/v3/edit/report/comment", serviceFallBack)
@@ -138,6 +171,8 @@ func main() {
 	r.POST("/v3/security/login/create_account", serviceFallBack)
 	r.POST("/v3/security/login/login", serviceFallBack)
 	r.POST("/v3/security/login/change_password", serviceFallBack)
+	r.GET("/v3/security/digest/unsubscribe", serviceFallBack)
+	r.POST("/v3/security/digest/unsubscribe", serviceFallBack)
 	r.GET("/v3/security/devices/list", serviceFallBack)
 	r.POST("/v3/security/devices/terminate", serviceFallBack)
 	r.POST("/v3/security/logout", serviceFallBack)
@@ -0,0 +1,336 @@
+package audio
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"math"
+)
+
+// Info 从音频容器中解析出的格式和时长
+type Info struct {
+	MimeType string
+	Suffix   string
+	Duration float64
+}
+
+var ErrUnknownFormat = errors.New("unknown audio format")
+
+// adtsSampleRates ADTS头部中采样率索引对应的采样率
+var adtsSampleRates = []int{96000, 88200, 64000, 48000, 44100, 32000, 24000, 22050, 16000, 12000, 11025, 8000, 7350}
+
+// Probe 识别Ogg Opus、ADTS AAC和M4A格式的音频并计算时长，不解码音频内容
+func Probe(data []byte) (*Info, error) {
+	switch {
+	case bytes.HasPrefix(data, []byte("OggS")):
+		return probeOpus(data)
+	case len(data) >= 8 && string(data[4:8]) == "ftyp":
+		return probeM4A(data)
+	case len(data) >= 2 && data[0] == 0xFF && data[1]&0xF6 == 0xF0:
+		return probeADTS(data)
+	}
+	return nil, ErrUnknownFormat
+}
+
+// probeOpus 按实际的Opus包计算时长，每个包的采样数由TOC字节决定，Opus固定按48kHz计数。
+// 最后一页的granule position由客户端填写，只用于截掉末尾多余的采样，不能让时长超过实际的包数
+func probeOpus(data []byte) (*Info, error) {
+	var preSkip, samples, granule int64
+	var serial uint32
+	var packet []byte
+	packets := 0
+	for pos := 0; pos < len(data); {
+		if len(data) < pos+27 || string(data[pos:pos+4]) != "OggS" {
+			return nil, errors.New("truncated ogg page")
+		}
+		if pos == 0 {
+			serial = binary.LittleEndian.Uint32(data[pos+14:])
+		} else if binary.LittleEndian.Uint32(data[pos+14:]) != serial {
+			return nil, errors.New("multiple ogg streams")
+		}
+		segments := int(data[pos+26])
+		body := pos + 27 + segments
+		if len(data) < body {
+			return nil, errors.New("truncated ogg page")
+		}
+		for _, lacing := range data[pos+27 : body] {
+			if len(data) < body+int(lacing) {
+				return nil, errors.New("truncated ogg page")
+			}
+			packet = append(packet, data[body:body+int(lacing)]...)
+			body += int(lacing)
+			if lacing == 255 {
+				continue
+			}
+			switch packets {
+			case 0:
+				if len(packet) < 19 || string(packet[:8]) != "OpusHead" {
+					return nil, ErrUnknownFormat
+				}
+				preSkip = int64(binary.LittleEndian.Uint16(packet[10:]))
+			case 1:
+			default:
+				n, err := opusPacketSamples(packet)
+				if err != nil {
+					return nil, err
+				}
+				samples += n
+			}
+			packets++
+			packet = packet[:0]
+		}
+		granule = int64(binary.LittleEndian.Uint64(data[pos+6:]))
+		pos = body
+	}
+	if packets == 0 {
+		return nil, ErrUnknownFormat
+	}
+	if granule >= 0 && granule < samples {
+		samples = granule
+	}
+	if samples < preSkip {
+		return nil, errors.New("invalid ogg granule position")
+	}
+	return &Info{
+		MimeType: "audio/ogg",
+		Suffix:   ".opus",
+		Duration: float64(samples-preSkip) / 48000,
+	}, nil
+}
+
+// opusFrameSamples TOC字节中config对应的每帧采样数，依次为SILK、Hybrid和CELT模式，见RFC 6716 3.1节
+var opusFrameSamples = [32]int64{
+	480, 960, 1920, 2880, 480, 960, 1920, 2880, 480, 960, 1920, 2880,
+	480, 960, 480, 960,
+	120, 240, 480, 960, 120, 240, 480, 960, 120, 240, 480, 960, 120, 240, 480, 960,
+}
+
+// opusPacketSamples 根据TOC字节计算一个Opus包的采样数
+func opusPacketSamples(packet []byte) (int64, error) {
+	if len(packet) == 0 {
+		return 0, errors.New("empty opus packet")
+	}
+	frames := int64(1)
+	switch packet[0] & 0x3 {
+	case 1, 2:
+		frames = 2
+	case 3:
+		if len(packet) < 2 {
+			return 0, errors.New("invalid opus packet")
+		}
+		frames = int64(packet[1] & 0x3F)
+	}
+	return frames * opusFrameSamples[packet[0]>>3], nil
+}
+
+// probeADTS 逐帧遍历ADTS流，每个raw data block包含1024个采样
+func probeADTS(data []byte) (*Info, error) {
+	var samples int64
+	sampleRate := 0
+	for pos := 0; pos < len(data); {
+		if len(data) < pos+7 || data[pos] != 0xFF || data[pos+1]&0xF6 != 0xF0 {
+			return nil, errors.New("invalid adts frame")
+		}
+		rateIndex := int(data[pos+2]>>2) & 0xF
+		if rateIndex >= len(adtsSampleRates) {
+			return nil, errors.New("invalid adts sample rate")
+		}
+		if sampleRate == 0 {
+			sampleRate = adtsSampleRates[rateIndex]
+		}
+		frameLength := int(data[pos+3]&0x3)<<11 | int(data[pos+4])<<3 | int(data[pos+5]>>5)
+		if frameLength < 7 || pos+frameLength > len(data) {
+			return nil, errors.New("invalid adts frame length")
+		}
+		samples += int64(data[pos+6]&0x3+1) * 1024
+		pos += frameLength
+	}
+	if sampleRate == 0 {
+		return nil, ErrUnknownFormat
+	}
+	return &Info{
+		MimeType: "audio/aac",
+		Suffix:   ".aac",
+		Duration: float64(samples) / float64(sampleRate),
+	}, nil
+}
+
+var m4aBrands = map[string]bool{"M4A ": true, "M4B ": true, "mp42": true, "mp41": true, "isom": true, "iso2": true}
+
+// probeM4A 按音频轨道stts中的采样数和mdhd中的timescale计算时长，并要求所有轨道都是音频轨道。
+// mvhd中的时长由客户端填写，只用于校验，与实际采样数不一致时拒绝
+func probeM4A(data []byte) (*Info, error) {
+	boxes, err := readBoxes(data)
+	if err != nil {
+		return nil, err
+	}
+	ftyp := boxes["ftyp"]
+	if len(ftyp) < 4 || !m4aBrands[string(ftyp[:4])] {
+		return nil, ErrUnknownFormat
+	}
+	moov, ok := boxes["moov"]
+	if !ok {
+		return nil, errors.New("moov box not found")
+	}
+
+	var headerDuration, duration float64
+	var timescale uint32
+	handler := ""
+	hasAudio := false
+	err = walkBoxes(moov, func(typ string, body []byte) error {
+		switch typ {
+		case "mvhd":
+			d, err2 := readBoxDuration(body)
+			if err2 != nil {
+				return errors.New("invalid mvhd box")
+			}
+			headerDuration = d
+		case "mdhd":
+			// 每个轨道的mdia以mdhd开头，重置该轨道的状态
+			if len(body) < 24 || (body[0] == 1 && len(body) < 36) {
+				return errors.New("invalid mdhd box")
+			}
+			timescale = binary.BigEndian.Uint32(body[12:])
+			if body[0] == 1 {
+				timescale = binary.BigEndian.Uint32(body[20:])
+			}
+			if timescale == 0 {
+				return errors.New("invalid mdhd timescale")
+			}
+			handler = ""
+		case "hdlr":
+			if len(body) < 12 {
+				return errors.New("invalid hdlr box")
+			}
+			switch string(body[8:12]) {
+			case "soun":
+				hasAudio = true
+				handler = "soun"
+			case "vide":
+				return errors.New("m4a contains video track")
+			}
+		case "stts":
+			if handler != "soun" || timescale == 0 {
+				return nil
+			}
+			samples, err2 := sttsDuration(body)
+			if err2 != nil {
+				return err2
+			}
+			if d := float64(samples) / float64(timescale); d > duration {
+				duration = d
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	if !hasAudio {
+		return nil, errors.New("m4a contains no audio track")
+	}
+	if math.Abs(duration-headerDuration) > math.Max(1, duration*0.05) {
+		return nil, errors.New("m4a duration mismatch")
+	}
+	return &Info{
+		MimeType: "audio/mp4",
+		Suffix:   ".m4a",
+		Duration: duration,
+	}, nil
+}
+
+// readBoxDuration 读取mvhd中的时长，version为1时timescale和时长的位置不同
+func readBoxDuration(body []byte) (float64, error) {
+	if len(body) < 20 || (body[0] == 1 && len(body) < 32) {
+		return 0, errors.New("truncated box")
+	}
+	if body[0] == 1 {
+		timescale := binary.BigEndian.Uint32(body[20:])
+		if timescale == 0 {
+			return 0, errors.New("invalid timescale")
+		}
+		return float64(binary.BigEndian.Uint64(body[24:])) / float64(timescale), nil
+	}
+	timescale := binary.BigEndian.Uint32(body[12:])
+	if timescale == 0 {
+		return 0, errors.New("invalid timescale")
+	}
+	return float64(binary.BigEndian.Uint32(body[16:])) / float64(timescale), nil
+}
+
+// sttsDuration 累加stts中每组采样的数量乘以时长，单位为轨道的timescale
+func sttsDuration(body []byte) (uint64, error) {
+	if len(body) < 8 {
+		return 0, errors.New("invalid stts box")
+	}
+	count := int(binary.BigEndian.Uint32(body[4:]))
+	if count < 0 || len(body) < 8+count*8 {
+		return 0, errors.New("invalid stts box")
+	}
+	var total uint64
+	for i := 0; i < count; i++ {
+		entry := body[8+i*8:]
+		total += uint64(binary.BigEndian.Uint32(entry)) * uint64(binary.BigEndian.Uint32(entry[4:]))
+	}
+	return total, nil
+}
+
+// readBoxes 读取同一层级的所有box，返回类型到内容的映射
+func readBoxes(data []byte) (map[string][]byte, error) {
+	boxes := make(map[string][]byte)
+	for pos := 0; pos < len(data); {
+		if len(data) < pos+8 {
+			return nil, errors.New("truncated box")
+		}
+		size := int64(binary.BigEndian.Uint32(data[pos:]))
+		typ := string(data[pos+4 : pos+8])
+		header := int64(8)
+		switch size {
+		case 0:
+			size = int64(len(data) - pos)
+		case 1:
+			if len(data) < pos+16 {
+				return nil, errors.New("truncated box")
+			}
+			size = int64(binary.BigEndian.Uint64(data[pos+8:]))
+			header = 16
+		}
+		if size < header || int64(pos)+size > int64(len(data)) {
+			return nil, errors.New("invalid box size")
+		}
+		if _, ok := boxes[typ]; !ok {
+			boxes[typ] = data[int64(pos)+header : int64(pos)+size]
+		}
+		pos += int(size)
+	}
+	return boxes, nil
+}
+
+// containerBoxes 包含子box、需要递归遍历的box类型
+var containerBoxes = map[string]bool{"moov": true, "trak": true, "mdia": true, "minf": true, "stbl": true}
+
+func walkBoxes(data []byte, fn func(typ string, body []byte) error) error {
+	for pos := 0; pos < len(data); {
+		if len(data) < pos+8 {
+			return errors.New("truncated box")
+		}
+		size := int(binary.BigEndian.Uint32(data[pos:]))
+		typ := string(data[pos+4 : pos+8])
+		if size == 0 {
+			size = len(data) - pos
+		}
+		if size < 8 || pos+size > len(data) {
+			return errors.New("invalid box size")
+		}
+		body := data[pos+8 : pos+size]
+		if containerBoxes[typ] {
+			if err := walkBoxes(body, fn); err != nil {
+				return err
+			}
+		} else if err := fn(typ, body); err != nil {
+			return err
+		}
+		pos += size
+	}
+	return nil
+}
@@ -0,0 +1,116 @@
+package audio
+
+import (
+	"encoding/binary"
+	"math"
+	"testing"
+)
+
+func oggPage(granule uint64, payload []byte) []byte {
+	page := []byte("OggS\x00\x00")
+	page = append(page, make([]byte, 8)...)
+	binary.LittleEndian.PutUint64(page[6:], granule)
+	page = append(page, make([]byte, 12)...)
+	page = append(page, 1, byte(len(payload)))
+	return append(page, payload...)
+}
+
+// testOpus 生成每包20ms的Opus流，最后一页的granule position对应seconds秒
+func testOpus(seconds float64, packets int) []byte {
+	head := []byte("OpusHead\x01\x01")
+	head = append(head, 0x38, 0x01) // pre-skip 312
+	head = append(head, 0x80, 0xBB, 0, 0, 0, 0, 0)
+	data := oggPage(0, head)
+	data = append(data, oggPage(0, []byte("OpusTags"))...)
+	for i := 1; i < packets; i++ {
+		data = append(data, oggPage(uint64(i*960), []byte{0xFC})...)
+	}
+	return append(data, oggPage(uint64(seconds*48000)+312, []byte{0xFC})...)
+}
+
+func adtsFrame(rateIndex byte, length int) []byte {
+	frame := make([]byte, length)
+	frame[0] = 0xFF
+	frame[1] = 0xF1
+	frame[2] = 0x40 | rateIndex<<2
+	frame[3] = byte(length >> 11 & 0x3)
+	frame[4] = byte(length >> 3)
+	frame[5] = byte(length&0x7)<<5 | 0x1F
+	frame[6] = 0xFC
+	return frame
+}
+
+func box(typ string, body ...[]byte) []byte {
+	size := 8
+	for _, b := range body {
+		size += len(b)
+	}
+	rtn := make([]byte, 8, size)
+	binary.BigEndian.PutUint32(rtn, uint32(size))
+	copy(rtn[4:], typ)
+	for _, b := range body {
+		rtn = append(rtn, b...)
+	}
+	return rtn
+}
+
+// testM4A 生成只有一个轨道的m4a，mvhd中的时长为duration，stts中的实际采样数为samples
+func testM4A(handler string, timescale uint32, duration uint32, samples uint32) []byte {
+	mvhd := make([]byte, 100)
+	binary.BigEndian.PutUint32(mvhd[12:], timescale)
+	binary.BigEndian.PutUint32(mvhd[16:], duration)
+	mdhd := make([]byte, 24)
+	binary.BigEndian.PutUint32(mdhd[12:], timescale)
+	binary.BigEndian.PutUint32(mdhd[16:], samples)
+	hdlr := make([]byte, 24)
+	copy(hdlr[8:], handler)
+	stts := make([]byte, 16)
+	binary.BigEndian.PutUint32(stts[4:], 1)
+	binary.BigEndian.PutUint32(stts[8:], samples/1024)
+	binary.BigEndian.PutUint32(stts[12:], 1024)
+	stbl := box("stbl", box("stts", stts))
+	data := box("ftyp", []byte("M4A \x00\x00\x00\x00isom"))
+	data = append(data, box("moov", box("mvhd", mvhd),
+		box("trak", box("mdia", box("mdhd", mdhd), box("hdlr", hdlr), box("minf", stbl))))...)
+	return append(data, box("mdat", make([]byte, 16))...)
+}
+
+func TestProbe(t *testing.T) {
+	var aac []byte
+	for i := 0; i < 441; i++ {
+		aac = append(aac, adtsFrame(4, 20)...)
+	}
+	cases := []struct {
+		name     string
+		data     []byte
+		suffix   string
+		duration float64
+	}{
+		{"opus", testOpus(12.5, 626), ".opus", 12.5},
+		{"opus forged granule", testOpus(12.5, 2), ".opus", (2*960 - 312) / 48000.0},
+		{"aac", aac, ".aac", 441 * 1024 / 44100.0},
+		{"m4a", testM4A("soun", 44100, 44100*30, 1024*1292), ".m4a", 1024 * 1292 / 44100.0},
+	}
+	for _, c := range cases {
+		info, err := Probe(c.data)
+		if err != nil {
+			t.Errorf("%s: %s", c.name, err)
+			continue
+		}
+		if info.Suffix != c.suffix || math.Abs(info.Duration-c.duration) > 0.01 {
+			t.Errorf("%s: got %s %.3f", c.name, info.Suffix, info.Duration)
+		}
+	}
+
+	for name, data := range map[string][]byte{
+		"text":      []byte("hello world"),
+		"video":     testM4A("vide", 1000, 1000, 1024),
+		"forged":    testM4A("soun", 44100, 44100, 1024*1292*120),
+		"truncated": aac[:len(aac)-5],
+		"ogg":       oggPage(0, []byte("\x01vorbis")),
+	} {
+		if _, err := Probe(data); err == nil {
+			t.Errorf("%s should be rejected", name)
+		}
+	}
+}
@@ -0,0 +1,193 @@
+package base
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+	"treehollow-v3-backend/pkg/consts"
+	"treehollow-v3-backend/pkg/utils"
+
+	"gorm.io/gorm"
+)
+
+type boardsSnapshot struct {
+	boards     []Board
+	byID       map[int32]Board
+	moderators map[int32][]int32
+	loadedAt   time.Time
+}
+
+type boardsCacheRW struct {
+	mu   sync.RWMutex
+	snap *boardsSnapshot
+}
+
+// 版块和版主数量很少且很少变动，缓存在内存中，避免列表接口每条树洞都查询数据库
+var boardsCache boardsCacheRW
+
+func loadBoards() (*boardsSnapshot, error) {
+	snap := &boardsSnapshot{
+		byID:       make(map[int32]Board),
+		moderators: make(map[int32][]int32),
+		loadedAt:   time.Now(),
+	}
+	if err := db.Order("id asc").Find(&snap.boards).Error; err != nil {
+		return nil, err
+	}
+	for _, board := range snap.boards {
+		snap.byID[board.ID] = board
+	}
+	var moderators []BoardModerator
+	if err := db.Find(&moderators).Error; err != nil {
+		return nil, err
+	}
+	for _, m := range moderators {
+		snap.moderators[m.UserID] = append(snap.moderators[m.UserID], m.BoardID)
+	}
+	return snap, nil
+}
+
+func getBoardsSnapshot() (*boardsSnapshot, error) {
+	boardsCache.mu.RLock()
+	snap := boardsCache.snap
+	boardsCache.mu.RUnlock()
+	if snap != nil && time.Since(snap.loadedAt) < consts.BoardCacheSeconds*time.Second {
+		return snap, nil
+	}
+	newSnap, err := loadBoards()
+	if err != nil {
+		// 数据库暂时不可用时继续使用旧的缓存
+		if snap != nil {
+			return snap, nil
+		}
+		return nil, err
+	}
+	snap = newSnap
+	boardsCache.mu.Lock()
+	boardsCache.snap = snap
+	boardsCache.mu.Unlock()
+	return snap, nil
+}
+
+// RefreshBoards 修改版块或版主后调用，下次读取时重新从数据库加载
+func RefreshBoards() {
+	boardsCache.mu.Lock()
+	boardsCache.snap = nil
+	boardsCache.mu.Unlock()
+}
+
+// GetBoards 获取所有版块
+func GetBoards() ([]Board, error) {
+	snap, err := getBoardsSnapshot()
+	if err != nil {
+		return nil, err
+	}
+	return snap.boards, nil
+}
+
+// GetBoard 按id获取版块
+func GetBoard(id int32) (Board, bool, error) {
+	snap, err := getBoardsSnapshot()
+	if err != nil {
+		return Board{}, false, err
+	}
+	board, ok := snap.byID[id]
+	return board, ok, nil
+}
+
+// GetModeratedBoards 用户担任版主的版块
+func GetModeratedBoards(uid int32) []int32 {
+	snap, err := getBoardsSnapshot()
+	if err != nil {
+		return nil
+	}
+	return snap.moderators[uid]
+}
+
+// IsBoardModerator 用户是否是版块的版主，读取失败时视为不是
+func IsBoardModerator(uid int32, boardID int32) bool {
+	if boardID == 0 {
+		return false
+	}
+	for _, id := range GetModeratedBoards(uid) {
+		if id == boardID {
+			return true
+		}
+	}
+	return false
+}
+
+// GetHiddenBoardIDs 不在主时间线中显示的版块
+func GetHiddenBoardIDs() ([]int32, error) {
+	snap, err := getBoardsSnapshot()
+	if err != nil {
+		return nil, err
+	}
+	var ids []int32
+	for _, board := range snap.boards {
+		if !board.ShowInTimeline {
+			ids = append(ids, board.ID)
+		}
+	}
+	return ids, nil
+}
+
+// ShowInTimeline 版块内的树洞是否在主时间线显示，boardID为0或版块不存在时显示
+func ShowInTimeline(boardID int32) bool {
+	if boardID == 0 {
+		return true
+	}
+	board, ok, err := GetBoard(boardID)
+	return err != nil || !ok || board.ShowInTimeline
+}
+
+// GetBoardIDOfPost 树洞所在的版块，读取失败时返回0
+func GetBoardIDOfPost(tx *gorm.DB, pid int32) int32 {
+	var boardIDs []int32
+	_ = tx.Unscoped().Model(&Post{}).Where("id = ?", pid).Pluck("board_id", &boardIDs).Error
+	if len(boardIDs) == 0 {
+		return 0
+	}
+	return boardIDs[0]
+}
+
+// HotListKeyOfBoard 版块热榜的Redis key，boardID为0时是总热榜
+func HotListKeyOfBoard(boardID int32) string {
+	if boardID == 0 {
+		return HotListKey
+	}
+	return fmt.Sprintf("webhole:hot_list:board:%d:zset", boardID)
+}
+
+// SplitBoardList 把逗号分隔的列表拆开，为空时返回空数组而不是nil
+func SplitBoardList(s string) []string {
+	rtn := []string{}
+	for _, item := range strings.Split(s, ",") {
+		if item = strings.TrimSpace(item); len(item) > 0 {
+			rtn = append(rtn, item)
+		}
+	}
+	return rtn
+}
+
+// AllowsType 版块是否允许发布该类型的树洞
+func (board *Board) AllowsType(typ string) bool {
+	types := SplitBoardList(board.AllowedTypes)
+	if len(types) == 0 {
+		return true
+	}
+	_, ok := utils.ContainsString(types, typ)
+	return ok
+}
+
+// AllowsRole 该角色的用户是否可以在版块中发帖，版主总是可以发帖
+func (board *Board) AllowsRole(user *User) bool {
+	roles := SplitBoardList(board.PostRoles)
+	if len(roles) == 0 || IsBoardModerator(user.ID, board.ID) {
+		return true
+	}
+	_, ok := utils.ContainsString(roles, strconv.Itoa(int(user.Role)))
+	return ok
+}
@@ -20,7 +20,7 @@ func (u *User) AfterCreate(tx *gorm.DB) (err error) {
 }
 
 func (post *Post) AfterCreate(tx *gorm.DB) (err error) {
-	err = tx.Create(&Attention{UserID: post.UserID, PostID: post.ID}).Error
+	err = tx.Create(&Attention{UserID: post.UserID, PostID: post.ID, LastReadCommentID: new(int32)}).Error
 	if err == nil {
 		// 新帖子创建，加入热榜
 		if e := UpdateHotListScore(tx, post.ID); e != nil {
@@ -31,10 +31,14 @@ func (post *Post) AfterCreate(tx *gorm.DB) (err error) {
 }
 
 func (comment *Comment) AfterCreate(tx *gorm.DB) (err error) {
-	var attention int64
-	err = tx.Model(&Attention{}).Where(&Attention{UserID: comment.UserID, PostID: comment.PostID}).Count(&attention).Error
-	if err == nil && attention == 0 {
-		err = tx.Create(&Attention{UserID: comment.UserID, PostID: comment.PostID}).Error
+	var autoFollow bool
+	autoFollow, err = IsAutoFollowEnabled(tx, comment.UserID)
+	if err == nil && autoFollow {
+		var attention int64
+		err = tx.Model(&Attention{}).Where(&Attention{UserID: comment.UserID, PostID: comment.PostID}).Count(&attention).Error
+		if err == nil && attention == 0 {
+			err = tx.Create(&Attention{UserID: comment.UserID, PostID: comment.PostID, LastReadCommentID: &comment.ID}).Error
+		}
 	}
 	if err == nil {
 		err = tx.Model(&Post{}).Where("id = ?", comment.PostID).
@@ -50,23 +54,58 @@ func (comment *Comment) AfterCreate(tx *gorm.DB) (err error) {
 
 func (attention *Attention) AfterCreate(tx *gorm.DB) (err error) {
 	err = tx.Table("posts").Where("id = ?", attention.PostID).
+		UpdateColumn("follow_num", gorm.Expr("follow_num + 1")).Error
+	return
+}
+
+func (attention *Attention) AfterDelete(tx *gorm.DB) (err error) {
+	err = tx.Table("posts").Where("id = ?", attention.PostID).
+		UpdateColumn("follow_num", gorm.Expr("follow_num - 1")).Error
+	return
+}
+
+func (like *Like) AfterCreate(tx *gorm.DB) (err error) {
+	err = tx.Table("posts").Where("id = ?", like.PostID).
 		UpdateColumn("like_num", gorm.Expr("like_num + 1")).Error
 	if err == nil {
 		// 点赞数增加，更新热榜分数
-		if e := UpdateHotListScore(tx, attention.PostID); e != nil {
-			log.Printf("Error updating hot list score on attention create: %v", e)
+		if e := UpdateHotListScore(tx, like.PostID); e != nil {
+			log.Printf("Error updating hot list score on like create: %v", e)
 		}
 	}
 	return
 }
 
-func (attention *Attention) AfterDelete(tx *gorm.DB) (err error) {
-	err = tx.Table("posts").Where("id = ?", attention.PostID).
+func (like *Like) AfterDelete(tx *gorm.DB) (err error) {
+	err = tx.Table("posts").Where("id = ?", like.PostID).
 		UpdateColumn("like_num", gorm.Expr("like_num - 1")).Error
 	if err == nil {
 		// 点赞数减少，更新热榜分数
-		if e := UpdateHotListScore(tx, attention.PostID); e != nil {
-			log.Printf("Error updating hot list score on attention delete: %v", e)
+		if e := UpdateHotListScore(tx, like.PostID); e != nil {
+			log.Printf("Error updating hot list score on like delete: %v", e)
+		}
+	}
+	return
+}
+
+func (reaction *Reaction) AfterCreate(tx *gorm.DB) (err error) {
+	return updateReactionNum(tx, reaction, 1)
+}
+
+func (reaction *Reaction) AfterDelete(tx *gorm.DB) (err error) {
+	return updateReactionNum(tx, reaction, -1)
+}
+
+// updateReactionNum 对树洞本身的表态计入reaction_num并更新热榜分数，对回复的表态不计入
+func updateReactionNum(tx *gorm.DB, reaction *Reaction, delta int) (err error) {
+	if reaction.CommentID != 0 {
+		return nil
+	}
+	err = tx.Table("posts").Where("id = ?", reaction.PostID).
+		UpdateColumn("reaction_num", gorm.Expr("reaction_num + ?", delta)).Error
+	if err == nil {
+		if e := UpdateHotListScore(tx, reaction.PostID); e != nil {
+			log.Printf("Error updating hot list score on reaction change: %v", e)
 		}
 	}
 	return
@@ -0,0 +1,46 @@
+package base
+
+import (
+	"sync"
+	"time"
+	"treehollow-v3-backend/pkg/consts"
+)
+
+type imageBlocksCacheRW struct {
+	mu       sync.RWMutex
+	blocks   []ImageBlock
+	loadedAt time.Time
+}
+
+// 屏蔽列表按感知哈希的汉明距离匹配，无法按哈希查询，缓存在内存中，避免每次发图都读取整张表。
+// 其他实例修改屏蔽列表时，最迟ImageBlockCacheSeconds后生效
+var imageBlocksCache imageBlocksCacheRW
+
+// GetCachedImageBlocks 获取缓存的屏蔽列表，缓存过期时重新从数据库加载
+func GetCachedImageBlocks() ([]ImageBlock, error) {
+	imageBlocksCache.mu.RLock()
+	blocks, loadedAt := imageBlocksCache.blocks, imageBlocksCache.loadedAt
+	imageBlocksCache.mu.RUnlock()
+	if !loadedAt.IsZero() && time.Since(loadedAt) < consts.ImageBlockCacheSeconds*time.Second {
+		return blocks, nil
+	}
+	newBlocks, err := GetImageBlocks(db)
+	if err != nil {
+		// 数据库暂时不可用时继续使用旧的缓存
+		if !loadedAt.IsZero() {
+			return blocks, nil
+		}
+		return nil, err
+	}
+	imageBlocksCache.mu.Lock()
+	imageBlocksCache.blocks, imageBlocksCache.loadedAt = newBlocks, time.Now()
+	imageBlocksCache.mu.Unlock()
+	return newBlocks, nil
+}
+
+// RefreshImageBlocks 添加或取消屏蔽后调用，下次读取时重新从数据库加载
+func RefreshImageBlocks() {
+	imageBlocksCache.mu.Lock()
+	imageBlocksCache.blocks, imageBlocksCache.loadedAt = nil, time.Time{}
+	imageBlocksCache.mu.Unlock()
+}
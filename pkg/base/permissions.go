@@ -1,6 +1,9 @@
 package base
 
 import (
+	"github.com/spf13/viper"
+	"strconv"
+	"treehollow-v3-backend/pkg/consts"
 	"treehollow-v3-backend/pkg/utils"
 )
 
@@ -37,15 +40,24 @@ func getPermissions(user *User, post *Post, isComment bool) []string {
 		rtn = append(rtn, "undelete_unban")
 	}
 
+	// 版主只能在自己的版块中删除，不能禁言，禁言仍由管理员处理
+	if user.Role != AdminRole && user.Role != SuperUserRole && !post.DeletedAt.Valid &&
+		IsBoardModerator(user.ID, post.BoardID) {
+		rtn = append(rtn, "board_delete")
+	}
+
 	return rtn
 }
 
-func GetPermissionsByComment(user *User, comment *Comment) []string {
-	return getPermissions(user, &Post{
+// GetPermissionsByComment boardID为回复所在树洞的版块，由调用方按树洞读取一次，避免每条回复查询一次
+func GetPermissionsByComment(user *User, comment *Comment, boardID int32) []string {
+	post := Post{
 		DeletedAt: comment.DeletedAt,
 		CreatedAt: comment.CreatedAt,
 		UserID:    comment.UserID,
-	}, true)
+		BoardID:   boardID,
+	}
+	return getPermissions(user, &post, true)
 }
 
 func GetReportWeight(user *User) int32 {
@@ -78,6 +90,24 @@ func GetDeletePostRateLimitIn24h(userRole UserRole) int64 {
 	}
 }
 
+// GetDeleteLimitIn24h 用户在版块中24小时内的删帖上限，版主在自己的版块中至少有BoardModeratorDeleteLimit
+func GetDeleteLimitIn24h(user *User, boardID int32) int64 {
+	limit := GetDeletePostRateLimitIn24h(user.Role)
+	if limit < consts.BoardModeratorDeleteLimit && IsBoardModerator(user.ID, boardID) {
+		limit = consts.BoardModeratorDeleteLimit
+	}
+	return limit
+}
+
+// CanOverrideCommentControls 管理员和版主可以无视洞主的回复限制，也可以修改回复限制
+func CanOverrideCommentControls(user *User, post *Post) bool {
+	return user.Role == SuperUserRole || user.Role == AdminRole || IsBoardModerator(user.ID, post.BoardID)
+}
+
+func CanManageBoards(user *User) bool {
+	return user.Role == SuperUserRole || user.Role == AdminRole
+}
+
 func CanOverrideBan(user *User) bool {
 	return user.Role == AdminRole || isDeleter(user.Role) || user.Role == UnDeleterRole ||
 		user.Role == SuperUserRole
@@ -109,6 +139,30 @@ func CanShutdown(user *User) bool {
 	return user.Role == SuperUserRole
 }
 
+func CanRunMediaGC(user *User) bool {
+	return user.Role == SuperUserRole
+}
+
+func CanManageImageBlocks(user *User) bool {
+	return user.Role == SuperUserRole || user.Role == AdminRole
+}
+
+func CanBreakSeriesLink(user *User) bool {
+	return user.Role == SuperUserRole || user.Role == AdminRole
+}
+
 func CanViewDecryptionMessages(user *User) bool {
 	return user.Role == SuperUserRole
 }
+
+// GetOfficialName 用户可以使用的官方身份名称。管理员使用official_admin_name，
+// 其他用户需要在official_accounts白名单中
+func GetOfficialName(user *User) (string, bool) {
+	if name, ok := viper.GetStringMapString("official_accounts")[strconv.Itoa(int(user.ID))]; ok && len(name) > 0 {
+		return name, true
+	}
+	if user.Role == AdminRole || user.Role == SuperUserRole {
+		return viper.GetString("official_admin_name"), true
+	}
+	return "", false
+}
@@ -0,0 +1,115 @@
+package base
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"strconv"
+	"time"
+	"treehollow-v3-backend/pkg/consts"
+
+	libredis "github.com/go-redis/redis/v8"
+	"github.com/robfig/cron/v3"
+	"gorm.io/gorm"
+)
+
+// 用户看过的最后一条回复先记在redis中（每个用户一个hash，pid -> cid），定时写入attentions表，
+// 避免每次查看树洞都写数据库
+const readStateDirtyKey = "webhole:read_state:dirty"
+
+func readStateKey(uid int32) string {
+	return fmt.Sprintf("webhole:read_state:%d", uid)
+}
+
+// 只在新的回复编号更大时更新，并记录有待写入数据库的用户
+var markReadScript = libredis.NewScript(`
+local cur = tonumber(redis.call('HGET', KEYS[1], ARGV[1]) or '0')
+if tonumber(ARGV[2]) > cur then
+	redis.call('HSET', KEYS[1], ARGV[1], ARGV[2])
+end
+redis.call('EXPIRE', KEYS[1], ARGV[3])
+redis.call('SADD', KEYS[2], ARGV[4])
+return 0
+`)
+
+// 写入数据库后删除对应的记录，写入期间又被更新的不删除
+var clearReadScript = libredis.NewScript(`
+for i = 1, #ARGV, 2 do
+	if redis.call('HGET', KEYS[1], ARGV[i]) == ARGV[i + 1] then
+		redis.call('HDEL', KEYS[1], ARGV[i])
+	end
+end
+return 0
+`)
+
+// MarkPostRead 记录用户看过树洞中编号不超过cid的回复
+func MarkPostRead(uid int32, pid int32, cid int32) error {
+	return markReadScript.Run(context.Background(), GetRedisClient(),
+		[]string{readStateKey(uid), readStateDirtyKey},
+		pid, cid, consts.ReadStateExpireDays*24*3600, uid).Err()
+}
+
+// FlushReadState 把用户在redis中的已读记录写入数据库，只更新已关注的树洞
+func FlushReadState(uid int32) error {
+	ctx := context.Background()
+	key := readStateKey(uid)
+	states, err := GetRedisClient().HGetAll(ctx, key).Result()
+	if err != nil || len(states) == 0 {
+		return err
+	}
+	args := make([]interface{}, 0, 2*len(states))
+	err = db.Transaction(func(tx *gorm.DB) error {
+		for pidStr, cidStr := range states {
+			pid, err2 := strconv.Atoi(pidStr)
+			cid, err3 := strconv.Atoi(cidStr)
+			if err2 == nil && err3 == nil {
+				err2 = tx.Model(&Attention{}).Where("user_id = ? and post_id = ?", uid, pid).
+					Update("last_read_comment_id", gorm.Expr("GREATEST(COALESCE(last_read_comment_id, 0), ?)", cid)).Error
+				if err2 != nil {
+					return err2
+				}
+			}
+			args = append(args, pidStr, cidStr)
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	return clearReadScript.Run(ctx, GetRedisClient(), []string{key}, args...).Err()
+}
+
+// FlushAllReadStates 写入所有用户待写入的已读记录，失败的用户下次再写入
+func FlushAllReadStates() {
+	ctx := context.Background()
+	client := GetRedisClient()
+	var failed []interface{}
+	for {
+		uidStr, err := client.SPop(ctx, readStateDirtyKey).Result()
+		if err == libredis.Nil {
+			break
+		}
+		if err != nil {
+			log.Printf("pop read state failed: %s\n", err)
+			break
+		}
+		uid, err := strconv.Atoi(uidStr)
+		if err != nil {
+			continue
+		}
+		if err = FlushReadState(int32(uid)); err != nil {
+			log.Printf("flush read state of user %d failed: %s\n", uid, err)
+			failed = append(failed, uidStr)
+		}
+	}
+	if len(failed) > 0 {
+		client.SAdd(ctx, readStateDirtyKey, failed...)
+	}
+}
+
+// InitReadStateFlushCron 定时把已读记录写入数据库
+func InitReadStateFlushCron() {
+	c := cron.New()
+	_, _ = c.AddFunc(fmt.Sprintf("@every %s", consts.ReadStateFlushSeconds*time.Second), FlushAllReadStates)
+	c.Start()
+}
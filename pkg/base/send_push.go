@@ -11,6 +11,78 @@ import (
 	"gorm.io/gorm"
 )
 
+// DefaultPushTypes 没有推送设置的用户默认推送的通知类型
+const DefaultPushTypes = model.SystemMessage | model.ReplyMeComment | model.PostQuoted | model.PrivateMessage
+
+// pushTypesAddedLater 推送设置中加入KnownTypes之后新增的、默认开启的通知类型。
+// 已有推送设置的用户没有选择过这些类型，MigratePushSettings为他们开启一次，之后以用户的选择为准
+var pushTypesAddedLater = []model.PushType{model.PostQuoted, model.PrivateMessage}
+
+// pushTypesBeforeKnownTypes 加入KnownTypes之前已有的通知类型，与PushSettings.KnownTypes的默认值一致
+const pushTypesBeforeKnownTypes = model.SystemMessage | model.ReplyMeComment | model.CommentInFavorited | model.PostReferenced
+
+// KnownPushTypes 保存推送设置时，用户已经选择过的通知类型
+func KnownPushTypes() model.PushType {
+	known := pushTypesBeforeKnownTypes
+	for _, typ := range pushTypesAddedLater {
+		known |= typ
+	}
+	return known
+}
+
+// newPushTypes 用户还没有选择过的新增通知类型
+func newPushTypes(known model.PushType) (added model.PushType) {
+	for _, typ := range pushTypesAddedLater {
+		if known&typ == 0 {
+			added |= typ
+		}
+	}
+	return
+}
+
+// MigratePushSettings 为已有推送设置的用户开启新增的默认通知类型，已经开启过的不会重复修改，可以重复执行。
+// KnownTypes只有少数几种取值，按取值分组更新
+func MigratePushSettings(tx *gorm.DB) error {
+	var knownList []model.PushType
+	if err := tx.Model(&PushSettings{}).Distinct("known_types").Pluck("known_types", &knownList).Error; err != nil {
+		return err
+	}
+	for _, known := range knownList {
+		added := newPushTypes(known)
+		if added == 0 {
+			continue
+		}
+		err := tx.Model(&PushSettings{}).Where("known_types = ?", known).UpdateColumns(map[string]interface{}{
+			"settings":    gorm.Expr("settings | ?", added),
+			"known_types": known | added,
+		}).Error
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// pushEnabled 用户是否推送该类型的通知，settings为nil表示用户没有推送设置
+func pushEnabled(settings *PushSettings, typ model.PushType) bool {
+	if settings == nil {
+		return typ&DefaultPushTypes > 0
+	}
+	return settings.Settings&typ > 0
+}
+
+// MergePushSetting 按表单中的一项修改推送设置，表单中没有该项时保留原来的设置，
+// 避免不认识新通知类型的旧版客户端保存设置时把它关闭
+func MergePushSetting(settings model.PushType, typ model.PushType, value string, present bool) model.PushType {
+	if !present {
+		return settings
+	}
+	if value == "1" {
+		return settings | typ
+	}
+	return settings &^ typ
+}
+
 func PreProcessPushMessages(tx *gorm.DB, msgs []PushMessage) error {
 	var userIDs []int32
 	for _, msg := range msgs {
@@ -31,17 +103,10 @@ func PreProcessPushMessages(tx *gorm.DB, msgs []PushMessage) error {
 	}
 
 	for i, msg := range msgs {
-		s, ok := pushSettingsMap[msg.UserID]
-		if ok {
-			if (s.Settings & msg.Type) > 0 {
-				msgs[i].DoPush = true
-			} else {
-				msgs[i].DoPush = false
-			}
-		} else if (msg.Type & (model.SystemMessage | model.ReplyMeComment)) > 0 {
-			msgs[i].DoPush = true
+		if s, ok := pushSettingsMap[msg.UserID]; ok {
+			msgs[i].DoPush = pushEnabled(&s, msg.Type)
 		} else {
-			msgs[i].DoPush = false
+			msgs[i].DoPush = pushEnabled(nil, msg.Type)
 		}
 	}
 	return nil
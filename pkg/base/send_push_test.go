@@ -0,0 +1,40 @@
+package base
+
+import (
+	"testing"
+	"treehollow-v3-backend/pkg/model"
+)
+
+func TestPushSettingsOfExistingUser(t *testing.T) {
+	// 新增私信和引用通知之前保存的推送设置，关闭了回复通知
+	settings := PushSettings{Settings: model.SystemMessage, KnownTypes: pushTypesBeforeKnownTypes}
+	if pushEnabled(&settings, model.PrivateMessage) {
+		t.Fatal("settings before migration should not push private messages")
+	}
+	added := newPushTypes(settings.KnownTypes)
+	settings.Settings |= added
+	settings.KnownTypes |= added
+	if !pushEnabled(&settings, model.PrivateMessage) || !pushEnabled(&settings, model.PostQuoted) {
+		t.Error("migration should enable private message and quote pushes")
+	}
+	if pushEnabled(&settings, model.ReplyMeComment) {
+		t.Error("migration should keep disabled pushes")
+	}
+
+	// 旧版客户端保存设置时不发送push_private_msg，应保留原来的设置
+	settings.Settings = MergePushSetting(settings.Settings, model.PrivateMessage, "", false)
+	if !pushEnabled(&settings, model.PrivateMessage) {
+		t.Error("absent form field should keep private message push")
+	}
+
+	// 迁移之后用户主动关闭的类型，再次迁移时不会重新开启
+	settings.Settings = MergePushSetting(settings.Settings, model.PrivateMessage, "0", true)
+	settings.KnownTypes = KnownPushTypes()
+	if added = newPushTypes(settings.KnownTypes); added != 0 || pushEnabled(&settings, model.PrivateMessage) {
+		t.Error("migration should not enable pushes the user turned off")
+	}
+
+	if !pushEnabled(nil, model.PrivateMessage) || pushEnabled(nil, model.CommentInFavorited) {
+		t.Error("users without push settings should use the defaults")
+	}
+}
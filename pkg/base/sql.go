@@ -2,6 +2,7 @@ package base
 
 import (
 	"context"
+	"encoding/json"
 	"errors"
 	"fmt"
 	"io"
@@ -26,12 +27,17 @@ const HotListKey = "webhole:hot_list:zset"
 
 var db *gorm.DB
 
+// ErrMediaUnavailable 上传的图片不存在、不属于该用户或已经被使用
+var ErrMediaUnavailable = errors.New("media unavailable")
+
 func AutoMigrateDb() {
 	err := db.AutoMigrate(&User{}, &Email{},
-		&Device{}, &PushSettings{}, &Vote{},
-		&VerificationCode{}, &Post{}, &PostCommenter{}, &PushMessage{},
+		&Device{}, &PushSettings{}, &DigestSettings{}, &Vote{},
+		&VerificationCode{}, &Post{}, &PostCommenter{}, &PostCommenterBlock{}, &PrivateConversation{}, &PrivateMessage{}, &PostMedia{}, &MediaBlob{}, &ImageBlock{}, &PostReference{}, &Reaction{}, &PostSeries{}, &SeriesPost{}, &Board{}, &BoardModerator{}, &MediaUpload{}, &PushMessage{}, &Like{}, &UserSettings{}, &AttentionCollection{},
 		&Comment{}, &Attention{}, &Report{}, &SystemMessage{}, Ban{})
 	utils.FatalErrorHandle(&err, "error migrating database!")
+	err = MigratePushSettings(db)
+	utils.FatalErrorHandle(&err, "error migrating push settings!")
 }
 
 func InitDb() {
@@ -75,13 +81,26 @@ func GetDb(unscoped bool) *gorm.DB {
 	return db
 }
 
-func ListPosts(tx *gorm.DB, p int, user *User) (posts []Post, err error) {
+// ListPosts 获取时间线，boardID为0时是主时间线，不包括不在主时间线显示的版块
+func ListPosts(tx *gorm.DB, p int, user *User, boardID int32) (posts []Post, err error) {
 	offset := (p - 1) * consts.PageSize
 	limit := consts.PageSize
 	pinnedPids := viper.GetIntSlice("pin_pids")
 	if CanViewDeletedPost(user) {
 		tx = tx.Unscoped()
 	}
+	if boardID > 0 {
+		tx = tx.Where("board_id = ?", boardID)
+		pinnedPids = nil
+	} else {
+		hiddenBoards, err2 := GetHiddenBoardIDs()
+		if err2 != nil {
+			return nil, err2
+		}
+		if len(hiddenBoards) > 0 {
+			tx = tx.Where("board_id not in ?", hiddenBoards)
+		}
+	}
 	if len(pinnedPids) == 0 {
 		err = tx.Order("id desc").Limit(limit).Offset(offset).Find(&posts).Error
 	} else {
@@ -91,18 +110,42 @@ func ListPosts(tx *gorm.DB, p int, user *User) (posts []Post, err error) {
 	return
 }
 
-func ListMsgs(p int, minId int32, userId int32, pushOnly bool) (msgs []PushMessage, err error) {
+func ListMsgs(p int, minId int32, userId int32, pushOnly bool, unreadOnly bool) (msgs []PushMessage, err error) {
 	offset := (p - 1) * consts.MsgPageSize
 	limit := consts.MsgPageSize
 	tx := db
 	if pushOnly {
 		tx = tx.Where("do_push = ?", true)
 	}
+	if unreadOnly {
+		tx = tx.Where("is_read = ?", false)
+	}
 	err = tx.Where("user_id = ? and id > ?", userId, minId).Order("id desc").Limit(limit).Offset(offset).
 		Find(&msgs).Error
 	return
 }
 
+// CountUnreadMsgs 每个用户的未读通知数，用于通知角标
+func CountUnreadMsgs(tx *gorm.DB, userIds []int32, pushOnly bool) (map[int32]int64, error) {
+	unread := make(map[int32]int64, len(userIds))
+	if len(userIds) == 0 {
+		return unread, nil
+	}
+	var counts []struct {
+		UserID int32
+		Count  int64
+	}
+	tx = tx.Model(&PushMessage{}).Where("user_id in ? and is_read = ?", userIds, false)
+	if pushOnly {
+		tx = tx.Where("do_push = ?", true)
+	}
+	err := tx.Select("user_id, count(*) as count").Group("user_id").Scan(&counts).Error
+	for _, count := range counts {
+		unread[count.UserID] = count.Count
+	}
+	return unread, err
+}
+
 func GetComments(pid int32) ([]Comment, error) {
 	var comments []Comment
 	err := db.Unscoped().Where("post_id = ?", pid).Order("id asc").Find(&comments).Error
@@ -133,8 +176,9 @@ func GetMultipleComments(tx *gorm.DB, pids []int32) ([]Comment, error) {
 	return comments, err
 }
 
+// SearchPosts 搜索树洞，boardID大于0时只搜索该版块
 func SearchPosts(page int, keywords string, limitPids []int32, user User, order model.SearchOrder,
-	includeComment bool, beforeTimestamp int64, afterTimestamp int64) (posts []Post, err error) {
+	includeComment bool, beforeTimestamp int64, afterTimestamp int64, boardID int32) (posts []Post, err error) {
 	canViewDelete := CanViewDeletedPost(&user)
 	var thePost Post
 	var err2 error
@@ -146,7 +190,11 @@ func SearchPosts(page int, keywords string, limitPids []int32, user User, order
 			pid, err2 = strconv.Atoi(keywords)
 		}
 		if err2 == nil {
-			err2 = GetDb(canViewDelete).First(&thePost, int32(pid)).Error
+			thePostTx := GetDb(canViewDelete)
+			if boardID > 0 {
+				thePostTx = thePostTx.Where("board_id = ?", boardID)
+			}
+			err2 = thePostTx.First(&thePost, int32(pid)).Error
 		}
 	}
 	offset := (page - 1) * consts.SearchPageSize
@@ -156,6 +204,9 @@ func SearchPosts(page int, keywords string, limitPids []int32, user User, order
 	if limitPids != nil {
 		tx = tx.Where("id in ?", limitPids)
 	}
+	if boardID > 0 {
+		tx = tx.Where("board_id = ?", boardID)
+	}
 
 	subSearch := func(tx0 *gorm.DB, isTag bool) *gorm.DB {
 		if isTag {
@@ -168,7 +219,7 @@ func SearchPosts(page int, keywords string, limitPids []int32, user User, order
 	if canViewDelete && keywords == "dels" {
 		subQuery1 := db.Unscoped().Model(&Report{}).Distinct().
 			Where("type in (?) and user_id != reported_user_id and post_id = posts.id",
-				[]ReportType{UserDelete, AdminDeleteAndBan}).Select("post_id")
+				[]ReportType{UserDelete, AdminDeleteAndBan, ImageHold, BoardModDelete}).Select("post_id")
 		err = db.Unscoped().Where("id in (?)", subQuery1).
 			Order(order.ToString()).Limit(limit).Offset(offset).Find(&posts).Error
 	} else {
@@ -208,33 +259,441 @@ func GetVerificationCode(emailHash string) (string, int64, int, error) {
 	return vc.Code, vc.UpdatedAt.Unix(), vc.FailedTimes, err
 }
 
-func SavePost(uid int32, text string, tag string, typ string, filePath string, metaStr string, voteData string) (id int32, err error) {
-	post := Post{Tag: tag, UserID: uid, Text: text, Type: typ, FilePath: filePath, LikeNum: 0, ReplyNum: 0,
-		ReportNum: 0, FileMetadata: metaStr, VoteData: voteData}
-	err = db.Save(&post).Error
+// SavePost 保存树洞，afterSave不为nil时在同一事务中调用，用于在树洞可见之前完成审核等操作
+func SavePost(uid int32, text string, tag string, typ string, voteData string, media []PostMedia,
+	quotePid int32, quoteCid int32, nameTheme string, officialName string, boardID int32,
+	afterSave func(tx *gorm.DB, pid int32) error) (id int32, err error) {
+	post := Post{Tag: tag, UserID: uid, Text: text, Type: typ, FilePath: "", LikeNum: 0, ReplyNum: 0,
+		ReportNum: 0, FileMetadata: "{}", VoteData: voteData, QuotePostID: quotePid, QuoteCommentID: quoteCid,
+		NameTheme: nameTheme, OfficialName: officialName, BoardID: boardID}
+	err = db.Transaction(func(tx *gorm.DB) error {
+		if err2 := tx.Save(&post).Error; err2 != nil {
+			return err2
+		}
+		if err2 := saveOfficialUse(tx, uid, post.ID, 0, officialName); err2 != nil {
+			return err2
+		}
+		if err2 := SaveMedia(tx, uid, post.ID, 0, media); err2 != nil {
+			return err2
+		}
+		if err2 := SaveReferences(tx, uid, post.ID, 0, text); err2 != nil {
+			return err2
+		}
+		if afterSave != nil {
+			return afterSave(tx, post.ID)
+		}
+		return nil
+	})
 	id = post.ID
 	return
 }
 
 func GetHotPosts() (posts []Post, err error) {
-	err = db.Order("like_num*3+reply_num+UNIX_TIMESTAMP(created_at)/1800-report_num*10 DESC").
+	err = db.Order("like_num*3+reply_num+reaction_num*2+UNIX_TIMESTAMP(created_at)/1800-report_num*10 DESC").
         Limit(200).Find(&posts).Error
 	return
 }
 
-func SaveComment(tx *gorm.DB, uid int32, text string, tag string, typ string, filePath string, pid int32, replyTo int32, name string,
-	metaStr string) (id int32, err error) {
-	comment := Comment{Tag: tag, UserID: uid, PostID: pid, ReplyTo: replyTo, Text: text, Type: typ, FilePath: filePath,
-		Name: name, FileMetadata: metaStr}
+// SaveComment 保存回复，officialName不为空时以官方身份回复，name应与officialName相同
+func SaveComment(tx *gorm.DB, uid int32, text string, tag string, typ string, pid int32, replyTo int32, name string,
+	officialName string, mentions []utils.Mention, media []PostMedia) (id int32, err error) {
+	comment := Comment{Tag: tag, UserID: uid, PostID: pid, ReplyTo: replyTo, Text: text, Type: typ, FilePath: "",
+		Name: name, OfficialName: officialName, FileMetadata: "{}"}
+	if len(mentions) > 0 {
+		mentionsBytes, err2 := json.Marshal(mentions)
+		if err2 != nil {
+			return 0, err2
+		}
+		comment.Mentions = string(mentionsBytes)
+	}
 	err = tx.Save(&comment).Error
 	id = comment.ID
+	if err == nil {
+		err = saveOfficialUse(tx, uid, pid, comment.ID, officialName)
+	}
+	if err == nil {
+		err = SaveMedia(tx, uid, pid, comment.ID, media)
+	}
+	if err == nil {
+		err = SaveReferences(tx, uid, pid, comment.ID, text)
+	}
 	if err == nil {
 		err = DelCommentCache(int(pid))
 	}
 	return
 }
 
-func GenCommenterName(tx *gorm.DB, dzUserID int32, czUserID int32, postID int32, names0 []string, names1 []string) (string, error) {
+// SaveMedia 保存树洞或回复附带的图片记录，cid为0表示属于树洞本身。
+// ID不为0的记录是已上传的图片，只有仍属于该用户且未被使用时才会关联到树洞
+func SaveMedia(tx *gorm.DB, uid int32, pid int32, cid int32, media []PostMedia) error {
+	if len(media) == 0 {
+		return nil
+	}
+	newMedia := make([]*PostMedia, 0, len(media))
+	for i := range media {
+		media[i].UserID = uid
+		media[i].PostID = pid
+		media[i].CommentID = cid
+		if media[i].ID == 0 {
+			newMedia = append(newMedia, &media[i])
+			continue
+		}
+		result := tx.Model(&PostMedia{}).
+			Where("id = ? and user_id = ? and post_id = 0", media[i].ID, uid).
+			Updates(map[string]interface{}{
+				"post_id":    pid,
+				"comment_id": cid,
+				"position":   media[i].Position,
+				"alt_text":   media[i].AltText,
+			})
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected != 1 {
+			return ErrMediaUnavailable
+		}
+	}
+	if len(newMedia) == 0 {
+		return nil
+	}
+	if err := tx.Create(&newMedia).Error; err != nil {
+		return err
+	}
+	return acquireMediaBlobs(tx, newMedia)
+}
+
+// acquireMediaBlobs 增加新图片记录对应内容哈希的引用计数，哈希不存在时创建
+func acquireMediaBlobs(tx *gorm.DB, media []*PostMedia) error {
+	for _, m := range media {
+		if len(m.Hash) == 0 {
+			continue
+		}
+		err := tx.Clauses(clause.OnConflict{
+			DoUpdates: clause.Assignments(map[string]interface{}{"ref_count": gorm.Expr("ref_count + 1")}),
+		}).Create(&MediaBlob{Hash: m.Hash, FilePath: m.FilePath, FileMetadata: m.FileMetadata,
+			AHash: m.AHash, DHash: m.DHash, RefCount: 1}).Error
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ReleaseMediaBlobs 在删除图片记录后调用，减少对应内容哈希的引用计数。
+// 返回引用计数归零的图片记录，其文件已不再被引用；没有内容哈希的旧图片总是被返回
+func ReleaseMediaBlobs(tx *gorm.DB, media []PostMedia) ([]PostMedia, error) {
+	counts := make(map[string]int)
+	var freed []PostMedia
+	for _, m := range media {
+		if len(m.Hash) == 0 {
+			freed = append(freed, m)
+			continue
+		}
+		counts[m.Hash]++
+	}
+	if len(counts) == 0 {
+		return freed, nil
+	}
+	hashes := make([]string, 0, len(counts))
+	for hash, n := range counts {
+		err := tx.Model(&MediaBlob{}).Where("hash = ?", hash).
+			Update("ref_count", gorm.Expr("ref_count - ?", n)).Error
+		if err != nil {
+			return nil, err
+		}
+		hashes = append(hashes, hash)
+	}
+	var released []string
+	err := tx.Model(&MediaBlob{}).Where("hash in ? and ref_count <= 0", hashes).Pluck("hash", &released).Error
+	if err != nil {
+		return nil, err
+	}
+	if len(released) == 0 {
+		return freed, nil
+	}
+	if err = tx.Where("hash in ?", released).Delete(&MediaBlob{}).Error; err != nil {
+		return nil, err
+	}
+	releasedSet := make(map[string]bool, len(released))
+	for _, hash := range released {
+		releasedSet[hash] = true
+	}
+	for _, m := range media {
+		if releasedSet[m.Hash] {
+			freed = append(freed, m)
+			releasedSet[m.Hash] = false
+		}
+	}
+	return freed, nil
+}
+
+// SaveReferences 保存树洞或回复文本中对其他树洞和回复的引用，忽略引用自身和不存在的树洞或回复
+func SaveReferences(tx *gorm.DB, uid int32, srcPid int32, srcCid int32, text string) error {
+	refs := utils.ParseReferences(text, consts.MaxReferencesPerText)
+	if len(refs) == 0 {
+		return nil
+	}
+	var pids, cids []int32
+	for _, ref := range refs {
+		if ref.PostID == srcPid {
+			continue
+		}
+		pids = append(pids, ref.PostID)
+		if ref.CommentID > 0 {
+			cids = append(cids, ref.CommentID)
+		}
+	}
+	if len(pids) == 0 {
+		return nil
+	}
+	var existingPids []int32
+	if err := tx.Model(&Post{}).Where("id in ?", pids).Pluck("id", &existingPids).Error; err != nil {
+		return err
+	}
+	postSet := utils.Int32SliceToSet(existingPids)
+	var comments []Comment
+	if len(cids) > 0 {
+		if err := tx.Select("id", "post_id").Where("id in ?", cids).Find(&comments).Error; err != nil {
+			return err
+		}
+	}
+	commentPost := make(map[int32]int32, len(comments))
+	for _, comment := range comments {
+		commentPost[comment.ID] = comment.PostID
+	}
+
+	var references []PostReference
+	for _, ref := range refs {
+		if ref.PostID == srcPid || !utils.Int32IsInSet(ref.PostID, postSet) {
+			continue
+		}
+		if ref.CommentID > 0 && commentPost[ref.CommentID] != ref.PostID {
+			continue
+		}
+		references = append(references, PostReference{
+			PostID:       ref.PostID,
+			CommentID:    ref.CommentID,
+			SrcPostID:    srcPid,
+			SrcCommentID: srcCid,
+			UserID:       uid,
+		})
+	}
+	if len(references) == 0 {
+		return nil
+	}
+	return tx.Create(&references).Error
+}
+
+// GetReferencedBy 获取引用了某条树洞或其回复的树洞和回复，不能查看已删除内容的用户看不到已删除的来源
+func GetReferencedBy(pid int32, canViewDelete bool) (refs []PostReference, err error) {
+	tx := db.Model(&PostReference{}).Select("post_references.*").Where("post_references.post_id = ?", pid)
+	if !canViewDelete {
+		tx = tx.Joins("join posts on posts.id = post_references.src_post_id and posts.deleted_at is null").
+			Joins("left join comments on comments.id = post_references.src_comment_id").
+			Where("post_references.src_comment_id = 0 or comments.deleted_at is null")
+	}
+	err = tx.Order("post_references.id desc").Limit(consts.ReferencedByPageSize).Find(&refs).Error
+	return
+}
+
+// saveOfficialUse 使用官方身份发布时记录日志，officialName为空时不做任何事
+func saveOfficialUse(tx *gorm.DB, uid int32, pid int32, cid int32, officialName string) error {
+	if len(officialName) == 0 {
+		return nil
+	}
+	return tx.Create(&Report{
+		UserID:         uid,
+		ReportedUserID: uid,
+		PostID:         pid,
+		CommentID:      cid,
+		Reason:         "以「" + officialName + "」身份发布",
+		Type:           OfficialIdentity,
+		IsComment:      cid != 0,
+		Weight:         0,
+	}).Error
+}
+
+// GetBlockedCommenterNames 洞主在树洞下禁止回复的化名
+func GetBlockedCommenterNames(tx *gorm.DB, pid int32) (names []string, err error) {
+	err = tx.Model(&PostCommenterBlock{}).Where("post_id = ?", pid).Order("created_at asc").
+		Pluck("commenter_name", &names).Error
+	return
+}
+
+// GetPseudonymInPost 用户在树洞中的化名，洞主为DzName，没有回复过的用户没有化名
+func GetPseudonymInPost(tx *gorm.DB, post *Post, uid int32) (string, bool, error) {
+	if post.UserID == uid {
+		return consts.DzName, true, nil
+	}
+	var names []string
+	err := tx.Model(&PostCommenter{}).Where("post_id = ? and user_id = ?", post.ID, uid).
+		Limit(1).Pluck("commenter_name", &names).Error
+	if err != nil || len(names) == 0 {
+		return "", false, err
+	}
+	return names[0], true, nil
+}
+
+// GetUserSettings 用户的偏好设置，没有记录时返回默认设置
+func GetUserSettings(tx *gorm.DB, uid int32) (UserSettings, error) {
+	var settings []UserSettings
+	err := tx.Where("user_id = ?", uid).Limit(1).Find(&settings).Error
+	if err != nil || len(settings) == 0 {
+		return UserSettings{UserID: uid}, err
+	}
+	return settings[0], nil
+}
+
+// IsAutoFollowEnabled 回复树洞时是否自动关注，默认自动关注
+func IsAutoFollowEnabled(tx *gorm.DB, uid int32) (bool, error) {
+	settings, err := GetUserSettings(tx, uid)
+	return !settings.DisableAutoFollow, err
+}
+
+// GetCommenterNames 树洞下所有参与者的化名，包括洞主
+func GetCommenterNames(tx *gorm.DB, pid int32) ([]string, error) {
+	var names []string
+	err := tx.Model(&PostCommenter{}).Where("post_id = ?", pid).Pluck("commenter_name", &names).Error
+	if err != nil {
+		return nil, err
+	}
+	return append(names, consts.DzName), nil
+}
+
+// GetUserByPseudonym 按树洞中的化名找到用户，找不到时返回false
+func GetUserByPseudonym(tx *gorm.DB, post *Post, name string) (int32, bool, error) {
+	if name == consts.DzName {
+		return post.UserID, true, nil
+	}
+	var uids []int32
+	err := tx.Model(&PostCommenter{}).Where("post_id = ? and commenter_name = ?", post.ID, name).
+		Limit(1).Pluck("user_id", &uids).Error
+	if err != nil || len(uids) == 0 {
+		return 0, false, err
+	}
+	return uids[0], true, nil
+}
+
+// GetSeriesOfPost 获取树洞所在的系列，树洞不在任何系列中时返回gorm.ErrRecordNotFound
+func GetSeriesOfPost(tx *gorm.DB, pid int32) (series PostSeries, err error) {
+	err = tx.Model(&PostSeries{}).Select("post_series.*").
+		Joins("join series_posts on series_posts.series_id = post_series.id").
+		Where("series_posts.post_id = ?", pid).First(&series).Error
+	return
+}
+
+// GetSeriesNeighbours 获取系列中pid的前一条和后一条树洞，不存在时为0
+func GetSeriesNeighbours(tx *gorm.DB, seriesID int32, pid int32, canViewDelete bool) (prev int32, next int32, err error) {
+	query := func() *gorm.DB {
+		q := tx.Model(&SeriesPost{}).Select("series_posts.post_id").Where("series_posts.series_id = ?", seriesID)
+		if !canViewDelete {
+			q = q.Joins("join posts on posts.id = series_posts.post_id and posts.deleted_at is null")
+		}
+		return q
+	}
+	var ids []int32
+	if err = query().Where("series_posts.post_id < ?", pid).Order("series_posts.post_id desc").
+		Limit(1).Pluck("series_posts.post_id", &ids).Error; err != nil {
+		return
+	}
+	if len(ids) > 0 {
+		prev = ids[0]
+	}
+	ids = nil
+	if err = query().Where("series_posts.post_id > ?", pid).Order("series_posts.post_id asc").
+		Limit(1).Pluck("series_posts.post_id", &ids).Error; err != nil {
+		return
+	}
+	if len(ids) > 0 {
+		next = ids[0]
+	}
+	return
+}
+
+// GetMediaBlob 按内容哈希查找已保存的图片文件
+func GetMediaBlob(tx *gorm.DB, hash string) (blob MediaBlob, err error) {
+	err = tx.Where("hash = ? and ref_count > 0", hash).First(&blob).Error
+	return
+}
+
+// GetImageBlocks 获取所有被屏蔽的图片
+func GetImageBlocks(tx *gorm.DB) (blocks []ImageBlock, err error) {
+	err = tx.Order("id desc").Find(&blocks).Error
+	return
+}
+
+// UpdateMediaMetadata 更新图片的metadata，有内容哈希时同时更新共用同一文件的所有图片
+func UpdateMediaMetadata(tx *gorm.DB, media *PostMedia, metaStr string) error {
+	if len(media.Hash) == 0 {
+		return tx.Model(&PostMedia{}).Where("id = ?", media.ID).Update("file_metadata", metaStr).Error
+	}
+	if err := tx.Model(&MediaBlob{}).Where("hash = ?", media.Hash).Update("file_metadata", metaStr).Error; err != nil {
+		return err
+	}
+	return tx.Model(&PostMedia{}).Where("hash = ?", media.Hash).Update("file_metadata", metaStr).Error
+}
+
+// GetUploadedMedia 获取用户已上传但尚未发送的图片，按ids的顺序返回
+func GetUploadedMedia(tx *gorm.DB, uid int32, ids []int32) ([]PostMedia, error) {
+	if len(ids) == 0 {
+		return nil, nil
+	}
+	var media []PostMedia
+	err := tx.Where("id in ? and user_id = ? and post_id = 0", ids, uid).Find(&media).Error
+	if err != nil {
+		return nil, err
+	}
+	byID := make(map[int32]PostMedia, len(media))
+	for _, m := range media {
+		byID[m.ID] = m
+	}
+	rtn := make([]PostMedia, 0, len(ids))
+	for _, id := range ids {
+		m, ok := byID[id]
+		if !ok {
+			return nil, ErrMediaUnavailable
+		}
+		rtn = append(rtn, m)
+	}
+	return rtn, nil
+}
+
+// GetMediaByPosts 批量获取树洞本身的图片，按上传顺序排列
+func GetMediaByPosts(tx *gorm.DB, pids []int32) (map[int32][]PostMedia, error) {
+	rtn := make(map[int32][]PostMedia)
+	if len(pids) == 0 {
+		return rtn, nil
+	}
+	var media []PostMedia
+	err := tx.Where("post_id in ? and comment_id = 0", pids).Order("position asc").Find(&media).Error
+	if err != nil {
+		return nil, err
+	}
+	for _, m := range media {
+		rtn[m.PostID] = append(rtn[m.PostID], m)
+	}
+	return rtn, nil
+}
+
+// GetMediaByComments 批量获取回复的图片，按上传顺序排列
+func GetMediaByComments(tx *gorm.DB, cids []int32) (map[int32][]PostMedia, error) {
+	rtn := make(map[int32][]PostMedia)
+	if len(cids) == 0 {
+		return rtn, nil
+	}
+	var media []PostMedia
+	err := tx.Where("comment_id in ?", cids).Order("position asc").Find(&media).Error
+	if err != nil {
+		return nil, err
+	}
+	for _, m := range media {
+		rtn[m.CommentID] = append(rtn[m.CommentID], m)
+	}
+	return rtn, nil
+}
+
+// GenCommenterName 获取回复者在树洞中的化名，新回复者按树洞的化名主题依次分配
+func GenCommenterName(tx *gorm.DB, dzUserID int32, czUserID int32, postID int32) (string, error) {
     // 1. 洞主判断，此逻辑不变且高效
     if dzUserID == czUserID {
         return consts.DzName, nil
@@ -265,7 +724,8 @@ func GenCommenterName(tx *gorm.DB, dzUserID int32, czUserID int32, postID int32,
 
     // 计数值+1
     newCount := post.DistinctCommenterCount + 1
-    newName := utils.GetCommenterName(int(newCount), names0, names1)
+    theme, _ := utils.GetNameTheme(post.NameTheme)
+    newName := theme.CommenterName(int(newCount))
 
     // 创建新的映射关系
     newMapping := PostCommenter{
@@ -333,6 +793,9 @@ func DeleteByReport(tx *gorm.DB, report Report) (err error) {
 		if err == nil {
 			// 帖子被删除，从热榜中移除
 			GetRedisClient().ZRem(context.Background(), HotListKey, strconv.Itoa(int(report.PostID)))
+			if boardID := GetBoardIDOfPost(tx, report.PostID); boardID > 0 {
+				GetRedisClient().ZRem(context.Background(), HotListKeyOfBoard(boardID), strconv.Itoa(int(report.PostID)))
+			}
 		}
 	}
 	return
@@ -387,7 +850,7 @@ func UnbanByReport(tx *gorm.DB, report Report) (err error) {
 }
 
 func ComputeScore(post *Post)(score float64){
-	score = float64(post.LikeNum*3+post.ReplyNum) +
+	score = float64(post.LikeNum*3+post.ReplyNum+post.ReactionNum*2) +
 			float64(post.CreatedAt.Unix())/1800.0 -
 			float64(post.ReportNum*10)
 	return
@@ -408,12 +871,23 @@ func UpdateHotListScore(tx *gorm.DB, postID int32) error {
 
 	// 热度分计算公式
 	score := ComputeScore(&post)
-
-	// 更新到 Redis ZSET
-	return GetRedisClient().ZAdd(context.Background(), HotListKey, &redis.Z{
+	member := &redis.Z{
 		Score:  score,
 		Member: strconv.Itoa(int(postID)),
-	}).Err()
+	}
+
+	// 版块内的树洞同时进入版块热榜，不在主时间线显示的版块不进入总热榜
+	if post.BoardID > 0 {
+		if err := GetRedisClient().ZAdd(context.Background(), HotListKeyOfBoard(post.BoardID), member).Err(); err != nil {
+			return err
+		}
+		if !ShowInTimeline(post.BoardID) {
+			return GetRedisClient().ZRem(context.Background(), HotListKey, member.Member).Err()
+		}
+	}
+
+	// 更新到 Redis ZSET
+	return GetRedisClient().ZAdd(context.Background(), HotListKey, member).Err()
 }
 
 // GetPostsByIDsInOrder 根据ID列表获取帖子，并保持传入的顺序
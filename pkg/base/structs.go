@@ -1,6 +1,7 @@
 package base
 
 import (
+	"encoding/json"
 	"fmt"
 	"gorm.io/gorm"
 	"time"
@@ -32,6 +33,12 @@ const (
 	AdminDeleteAndBan ReportType = "AdminDeleteBan" // delete, ban
 	AdminUndelete     ReportType = "Undelete"       // undelete + unban
 	AdminUnban        ReportType = "AdminUnban"     // delete + unban
+	ImageHold         ReportType = "ImageHold"      // delete, waiting for review
+	BoardModDelete    ReportType = "BoardModDelete" // moderator delete in own board, no ban
+	AdminSeriesUnlink ReportType = "AdminSeriesUnlink"
+	OfficialIdentity  ReportType = "OfficialIdentity" // post or comment as official account
+	AdminCommentCtrl  ReportType = "AdminCommentCtrl" // moderator changed comment controls
+	PrivateMsgReport  ReportType = "PrivateMsgReport"
 	//	For now, there's no "undelete + no unban" option
 )
 
@@ -80,9 +87,46 @@ type Device struct {
 	DeletedAt      gorm.DeletedAt `gorm:"index"`
 }
 
+// UserSettings 与推送无关的用户偏好设置，没有记录时均为默认值
+type UserSettings struct {
+	UserID int32 `gorm:"primaryKey;not null"`
+	// 回复树洞时不再自动关注该树洞
+	DisableAutoFollow bool `gorm:"default:false"`
+}
+
 type PushSettings struct {
 	UserID   int32 `gorm:"primaryKey;not null"`
 	Settings model.PushType
+	// 用户已经选择过是否推送的通知类型，默认值为加入该字段之前已有的类型。
+	// 新增默认开启的通知类型时，据此为已有推送设置的用户开启
+	KnownTypes model.PushType `gorm:"default:15"`
+	// 是否接收同一树洞下其他参与者的私信，默认不接收
+	AllowPrivateMsg bool `gorm:"default:false"`
+}
+
+// DigestFrequency 邮件摘要的频率，数值为两次摘要间隔的天数
+type DigestFrequency int8
+
+const (
+	NoDigest     DigestFrequency = 0
+	DailyDigest  DigestFrequency = 1
+	WeeklyDigest DigestFrequency = 7
+)
+
+// DigestSettings 邮件摘要设置。账号中的邮箱是用密码加密的，服务器无法读取，
+// 因此开启摘要时需要用户再次提供邮箱，用配置中的digest_email_key加密后单独保存，退订时删除
+type DigestSettings struct {
+	UserID    int32 `gorm:"primaryKey;not null"`
+	Frequency DigestFrequency
+	// 发送时间，按服务器所在时区
+	Hour           int8
+	Weekday        int8
+	EmailEncrypted string `gorm:"type:varchar(200) NOT NULL"`
+	// 邮件中一键退订链接使用的token
+	UnsubscribeToken string `gorm:"uniqueIndex;type:varchar(40) NOT NULL"`
+	NextSendAt       *time.Time
+	LastSentAt       *time.Time
+	UpdatedAt        time.Time
 }
 
 type VerificationCode struct {
@@ -105,8 +149,25 @@ type Post struct {
 	VoteData     string `gorm:"type:varchar(200) NOT NULL"`
 	LikeNum      int32  `gorm:"index"`
 	ReplyNum     int32  `gorm:"index"`
+	// 关注人数，与点赞数LikeNum分开计数
+	FollowNum    int32  `gorm:"default:0"`
 	ReportNum    int32
 	DistinctCommenterCount int32 `gorm:"default:0"` 
+	// 引用转发的树洞或回复，QuoteCommentID为0时引用的是树洞本身
+	QuotePostID    int32 `gorm:"default:0"`
+	QuoteCommentID int32 `gorm:"default:0"`
+	// 洞主选择的回复者化名主题，为空时使用默认主题
+	NameTheme string `gorm:"type:varchar(20) NOT NULL;default:''"`
+	// 使用官方身份发布时的认证名称，为空时是普通匿名树洞
+	OfficialName string `gorm:"type:varchar(60) NOT NULL;default:''"`
+	// 所在版块，为0时不属于任何版块
+	BoardID int32 `gorm:"index;default:0"`
+	// 洞主设置的回复限制，CommentMode为CommentModeOpen、CommentModeLocked或CommentModeParticipants，
+	// SlowModeMinutes大于0时每人每SlowModeMinutes分钟只能回复一次
+	CommentMode     string `gorm:"type:varchar(20) NOT NULL;default:''"`
+	SlowModeMinutes int32  `gorm:"default:0"`
+	// 树洞本身收到的表态数，不包括对回复的表态
+	ReactionNum int32 `gorm:"default:0"`
 	//Comments     []Comment
 	CreatedAt time.Time      `gorm:"index"`
 	UpdatedAt time.Time      `gorm:"index"`
@@ -119,6 +180,37 @@ type PostCommenter struct {
     CommenterName string `gorm:"type:varchar(60) NOT NULL"`
 }
 
+// PrivateConversation 同一条树洞下两位参与者之间的私信会话，UserA < UserB。
+// 双方只能看到对方在树洞中的化名NameA、NameB，UserID只在服务端使用
+type PrivateConversation struct {
+	ID         int32  `gorm:"primaryKey;autoIncrement;not null"`
+	UserA      int32  `gorm:"uniqueIndex:idx_private_conversation"`
+	UserB      int32  `gorm:"uniqueIndex:idx_private_conversation;index"`
+	PostID     int32  `gorm:"uniqueIndex:idx_private_conversation"`
+	NameA      string `gorm:"type:varchar(60) NOT NULL"`
+	NameB      string `gorm:"type:varchar(60) NOT NULL"`
+	BlockedByA bool
+	BlockedByB bool
+	CreatedAt  time.Time
+	UpdatedAt  time.Time `gorm:"index"`
+}
+
+type PrivateMessage struct {
+	ID             int32  `gorm:"primaryKey;autoIncrement;not null"`
+	ConversationID int32  `gorm:"index"`
+	SenderID       int32
+	Text           string `gorm:"type:varchar(1000) NOT NULL"`
+	CreatedAt      time.Time
+}
+
+// PostCommenterBlock 洞主按化名禁止某位回复者在树洞下回复，UserID不会返回给前端
+type PostCommenterBlock struct {
+	PostID        int32     `gorm:"primaryKey"`
+	UserID        int32     `gorm:"primaryKey"`
+	CommenterName string    `gorm:"type:varchar(60) NOT NULL"`
+	CreatedAt     time.Time `gorm:"index"`
+}
+
 type Comment struct {
 	ID      int32 `gorm:"primaryKey;autoIncrement;not null"`
 	ReplyTo int32 `gorm:"index"`
@@ -132,9 +224,126 @@ type Comment struct {
 	FilePath     string `gorm:"type:varchar(60) NOT NULL"`
 	FileMetadata string `gorm:"type:varchar(40) NOT NULL"`
 	Name         string `gorm:"type:varchar(60) NOT NULL"`
+	// 使用官方身份回复时的认证名称，此时Name也是该名称而不是化名
+	OfficialName string `gorm:"type:varchar(60) NOT NULL;default:''"`
+	// 回复中@化名的位置，JSON格式的[]utils.Mention，至多MaxMentionSpansPerComment个，保证不超过长度限制
+	Mentions  string `gorm:"type:varchar(2000) NOT NULL;default:''"`
+	CreatedAt time.Time
+	UpdatedAt time.Time
+	DeletedAt gorm.DeletedAt `gorm:"index"`
+}
+
+const (
+	CommentModeOpen         = ""
+	CommentModeLocked       = "locked"
+	CommentModeParticipants = "participants"
+)
+
+const (
+	MediaKindImage = "image"
+	MediaKindAudio = "audio"
+)
+
+// PostMedia 树洞或回复附带的图片或语音，CommentID为0时属于树洞本身，
+// PostID为0时是通过上传接口上传、尚未发送的图片
+type PostMedia struct {
+	ID           int32 `gorm:"primaryKey;autoIncrement;not null"`
+	UserID       int32 `gorm:"index"`
+	PostID       int32 `gorm:"index"`
+	CommentID    int32 `gorm:"index"`
+	Position     int32
+	FilePath     string `gorm:"type:varchar(60) NOT NULL"`
+	FileMetadata string `gorm:"type:varchar(1000) NOT NULL"`
+	AltText      string `gorm:"type:varchar(200) NOT NULL"`
+	Kind         string `gorm:"type:varchar(10) NOT NULL;default:'image'"`
+	Hash         string `gorm:"index;type:char(64) NOT NULL;default:''"`
+	AHash        int64
+	DHash        int64
+	CreatedAt    time.Time
+}
+
+// MediaBlob 按内容哈希保存的图片文件，内容相同的图片共用同一个文件，
+// RefCount为引用该文件的PostMedia数量，归零后文件可以被删除
+type MediaBlob struct {
+	Hash         string `gorm:"primaryKey;type:char(64)"`
+	FilePath     string `gorm:"type:varchar(60) NOT NULL"`
+	FileMetadata string `gorm:"type:varchar(1000) NOT NULL"`
+	AHash        int64
+	DHash        int64
+	RefCount     int32
 	CreatedAt    time.Time
 	UpdatedAt    time.Time
-	DeletedAt    gorm.DeletedAt `gorm:"index"`
+}
+
+// PostReference 树洞或回复(SrcPostID, SrcCommentID)中对其他树洞或回复(PostID, CommentID)的引用，
+// CommentID为0时引用的是树洞本身
+type PostReference struct {
+	ID           int32 `gorm:"primaryKey;autoIncrement;not null"`
+	PostID       int32 `gorm:"index"`
+	CommentID    int32
+	SrcPostID    int32 `gorm:"index"`
+	SrcCommentID int32
+	UserID       int32
+	CreatedAt    time.Time
+}
+
+// Board 管理员创建的版块。AllowedTypes和PostRoles为逗号分隔的列表，为空时不做限制；
+// ShowInTimeline为false时版块内的树洞不出现在主时间线和总热榜中
+type Board struct {
+	ID             int32  `gorm:"primaryKey;autoIncrement;not null"`
+	Name           string `gorm:"type:varchar(30) NOT NULL;uniqueIndex"`
+	Description    string `gorm:"type:varchar(500) NOT NULL"`
+	AllowedTypes   string `gorm:"type:varchar(100) NOT NULL"`
+	PostRoles      string `gorm:"type:varchar(100) NOT NULL"`
+	ShowInTimeline bool   `gorm:"default:true"`
+	CreatedAt      time.Time
+}
+
+// BoardModerator 版块的版主，可以删除版块内的树洞和回复
+type BoardModerator struct {
+	BoardID   int32 `gorm:"primaryKey"`
+	UserID    int32 `gorm:"primaryKey;index"`
+	CreatedAt time.Time
+}
+
+// PostSeries 洞主把自己发布的多条树洞串成的系列。UserID只用于服务端校验，不会返回给前端
+type PostSeries struct {
+	ID        int32  `gorm:"primaryKey;autoIncrement;not null"`
+	UserID    int32  `gorm:"index"`
+	Name      string `gorm:"type: varchar(60) NOT NULL"`
+	CreatedAt time.Time
+}
+
+// SeriesPost 系列中的树洞，一条树洞最多属于一个系列，系列内按PostID排序
+type SeriesPost struct {
+	PostID    int32 `gorm:"primaryKey"`
+	SeriesID  int32 `gorm:"index"`
+	CreatedAt time.Time
+}
+
+// ImageBlock 管理员屏蔽的图片，aHash和dHash与其汉明距离都不超过Threshold的图片
+// 会被拒绝发送，Hold为true时发送后进入待审核状态
+type ImageBlock struct {
+	ID        int32 `gorm:"primaryKey;autoIncrement;not null"`
+	UserID    int32
+	PostID    int32
+	CommentID int32
+	AHash     int64
+	DHash     int64
+	Threshold int32
+	Hold      bool
+	CreatedAt time.Time
+}
+
+// MediaUpload 分片上传的会话，全部分片上传完成后生成一条PostMedia
+type MediaUpload struct {
+	ID        string `gorm:"primaryKey;type:char(32)"`
+	UserID    int32  `gorm:"index"`
+	Size      int64
+	Received  int64
+	AltText   string `gorm:"type:varchar(200) NOT NULL"`
+	CreatedAt time.Time
+	UpdatedAt time.Time `gorm:"index"`
 }
 
 type Report struct {
@@ -162,6 +371,35 @@ type Attention struct {
 	UserID int32 `gorm:"primaryKey;index"`
 	Post   Post
 	PostID int32 `gorm:"primaryKey;index"`
+	// 所在的收藏夹，为0时未分类
+	CollectionID int32  `gorm:"index;default:0"`
+	Note         string `gorm:"type:varchar(200) NOT NULL;default:''"`
+	// 最后看过的回复编号，为NULL时未知，不计算未读回复
+	LastReadCommentID *int32
+}
+
+// AttentionCollection 用户给关注的树洞建立的收藏夹
+type AttentionCollection struct {
+	ID        int32  `gorm:"primaryKey;autoIncrement;not null"`
+	UserID    int32  `gorm:"index"`
+	Name      string `gorm:"type:varchar(60) NOT NULL"`
+	CreatedAt time.Time
+}
+
+// Reaction 用户对树洞或回复的表态，CommentID为0时是对树洞本身的表态，每人对每个对象只有一个表态
+type Reaction struct {
+	UserID    int32  `gorm:"primaryKey"`
+	PostID    int32  `gorm:"primaryKey;index:idx_reaction_target"`
+	CommentID int32  `gorm:"primaryKey;index:idx_reaction_target"`
+	Emoji     string `gorm:"type:varchar(20) NOT NULL"`
+	CreatedAt time.Time
+}
+
+// Like 用户对树洞的点赞，计入Post.LikeNum；关注(Attention)只决定是否接收回复通知
+type Like struct {
+	UserID    int32 `gorm:"primaryKey"`
+	PostID    int32 `gorm:"primaryKey;index"`
+	CreatedAt time.Time
 }
 
 type Vote struct {
@@ -206,6 +444,7 @@ type PushMessage struct {
 	BanID     int32 `gorm:"index"`
 	DoPush    bool  `gorm:"index"`
 	Type      model.PushType
+	IsRead    bool           `gorm:"index;default:false"`
 	UpdatedAt time.Time      `gorm:"index"`
 	DeletedAt gorm.DeletedAt `gorm:"index"`
 }
@@ -244,6 +483,18 @@ func (typ *ReportType) ToString() string {
 		return "删帖禁言"
 	case AdminUnban:
 		return "解禁"
+	case ImageHold:
+		return "图片命中屏蔽列表待审核"
+	case BoardModDelete:
+		return "版主删除"
+	case AdminSeriesUnlink:
+		return "管理员解除系列链接"
+	case OfficialIdentity:
+		return "使用官方身份发布"
+	case AdminCommentCtrl:
+		return "管理员修改回复限制"
+	case PrivateMsgReport:
+		return "私信举报"
 	default:
 		return "unknown"
 	}
@@ -262,3 +513,32 @@ func (report *Report) ToDetailedString() string {
 func (msg *SystemMessage) ToString() string {
 	return fmt.Sprintf("User ID:%d\nTitle:%s\n***\n%s", msg.UserID, msg.Title, msg.Text)
 }
+
+// MentionSpans 返回回复中@化名的位置，没有时返回空数组
+func (comment *Comment) MentionSpans() []utils.Mention {
+	mentions := []utils.Mention{}
+	if len(comment.Mentions) > 0 {
+		_ = json.Unmarshal([]byte(comment.Mentions), &mentions)
+	}
+	return mentions
+}
+
+// Files 返回图片原图及缩略图、WebP等衍生文件的哈希路径
+func (media *PostMedia) Files() []string {
+	files := []string{utils.GetHashedFilePath(media.FilePath)}
+	var metadata struct {
+		Thumbnails []struct {
+			Url string `json:"url"`
+		} `json:"thumbnails"`
+		WebP string `json:"webp"`
+	}
+	if err := json.Unmarshal([]byte(media.FileMetadata), &metadata); err == nil {
+		for _, t := range metadata.Thumbnails {
+			files = append(files, t.Url)
+		}
+		if len(metadata.WebP) > 0 {
+			files = append(files, metadata.WebP)
+		}
+	}
+	return files
+}
@@ -6,6 +6,8 @@ import (
 	"github.com/spf13/viper"
 	"log"
 	"net"
+	"os"
+	"path/filepath"
 	"treehollow-v3-backend/pkg/consts"
 	"treehollow-v3-backend/pkg/utils"
 )
@@ -23,10 +25,31 @@ func refreshAllowedSubnets() {
 func refreshConfig() {
 	refreshAllowedSubnets()
 	utils.RefreshGeoDb()
+	utils.RefreshNameThemes()
 	viper.SetDefault("sys_load_threshold", consts.SystemLoadThreshold)
 	viper.SetDefault("ws_ping_period_sec", 90)
 	viper.SetDefault("ws_pong_timeout_sec", 10)
 	viper.SetDefault("push_internal_api_listen_address", "127.0.0.1:3009")
+	viper.SetDefault("image_thumbnail_widths", []int{320, 640, 1280})
+	viper.SetDefault("cwebp_path", "cwebp")
+	viper.SetDefault("upload_tmp_path", filepath.Join(os.TempDir(), "treehollow-uploads"))
+	viper.SetDefault("upload_expire_minutes", 60)
+	viper.SetDefault("media_gc_retention_days", 30)
+	viper.SetDefault("image_block_threshold", 8)
+	viper.SetDefault("audio_max_duration_seconds", 60)
+	viper.SetDefault("official_admin_name", "树洞管理员")
+	viper.SetDefault("reactions", []string{"👍", "❤️", "😂", "😮", "😢", "😡"})
+	for _, hook := range refreshHooks {
+		hook()
+	}
+}
+
+// 配置文件变化时需要重新加载的模块，由模块自己注册，避免config依赖这些模块
+var refreshHooks []func()
+
+// OnRefresh 注册读取或重新读取配置文件后执行的函数
+func OnRefresh(hook func()) {
+	refreshHooks = append(refreshHooks, hook)
 }
 
 func InitConfigFile() {
@@ -48,5 +71,21 @@ func GetFrontendConfigInfo() gin.H {
 	return gin.H{
 		"web_frontend_version": viper.GetString("web_frontend_version"),
 		"announcement":         viper.GetString("announcement"),
+		"name_themes":          nameThemesJson(),
+		"reactions":            viper.GetStringSlice("reactions"),
+	}
+}
+
+// nameThemesJson 前端发帖时可选的化名主题，附带前三个化名作为示例
+func nameThemesJson() []gin.H {
+	themes := utils.GetNameThemes()
+	data := make([]gin.H, 0, len(themes))
+	for _, theme := range themes {
+		data = append(data, gin.H{
+			"id":       theme.ID,
+			"name":     theme.DisplayName,
+			"examples": []string{theme.CommenterName(1), theme.CommenterName(2), theme.CommenterName(3)},
+		})
 	}
+	return data
 }
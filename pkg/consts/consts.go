@@ -20,6 +20,35 @@ const VoteMaxOptions = 4
 const MaxDevicesPerUser = 6
 const ReportMaxLength = 1000
 const ImgMaxLength = 2000000
+const PostMaxImages = 9
+const CommentMaxImages = 4
+const ImageAltMaxLength = 200
+const AudioMaxLength = 2000000
+const AudioMaxPeaks = 100
+const MaxReferencesPerText = 10
+const MaxMentionsPerComment = 10
+const MaxMentionSpansPerComment = 20
+const ReferencedByPageSize = 50
+const SeriesNameMaxLength = 30
+const MaxSeriesPerUser = 50
+const BoardCacheSeconds = 60
+const ImageBlockCacheSeconds = 60
+const BoardModeratorDeleteLimit = 20
+const MaxSlowModeMinutes = 1440
+const MaxBlockedCommentersPerPost = 10
+const MaxCommenterBlocksIn24h = 20
+const PrivateMessageMaxLength = 1000
+const PrivateMessagePageSize = 30
+const CollectionNameMaxLength = 30
+const MaxCollectionsPerUser = 50
+const AttentionNoteMaxLength = 200
+const CollectionExportMaxPosts = 5000
+const ReadStateFlushSeconds = 60
+const ReadStateExpireDays = 7
+const MaxMarkReadMsgs = 100
+const DigestMaxMessages = 20
+const DigestHotPosts = 5
+const UploadFormOverhead = 16384
 const Base64Rate = 1.33333333
 const AesIv = "12345678901234567890123456789012"
 
@@ -35,7 +64,6 @@ const DatabaseDamagedString = "数据库损坏，请联系管理员"
 const DatabaseEncryptFailedString = "数据库加密失败，请联系管理员"
 
 const DzName = "洞主"
-const ExtraNamePrefix = "You Win "
 
 var TimeLoc, _ = time.LoadLocation("Asia/Shanghai")
 
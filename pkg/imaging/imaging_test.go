@@ -0,0 +1,159 @@
+package imaging
+
+import (
+	"bytes"
+	"encoding/binary"
+	"hash/crc32"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"image/png"
+	"testing"
+)
+
+func testImage(w, h int) *image.RGBA {
+	img := image.NewRGBA(image.Rect(0, 0, w, h))
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			img.Set(x, y, color.RGBA{R: uint8(x * 255 / w), G: uint8(y * 255 / h), B: 128, A: 255})
+		}
+	}
+	return img
+}
+
+// exifSegment 构造一个只包含Orientation的APP1段
+func exifSegment(orientation uint16) []byte {
+	tiff := []byte("II*\x00\x08\x00\x00\x00")
+	ifd := make([]byte, 2+12+4)
+	binary.LittleEndian.PutUint16(ifd[0:], 1)
+	binary.LittleEndian.PutUint16(ifd[2:], 0x0112)
+	binary.LittleEndian.PutUint16(ifd[4:], 3)
+	binary.LittleEndian.PutUint32(ifd[6:], 1)
+	binary.LittleEndian.PutUint16(ifd[10:], orientation)
+	payload := append([]byte("Exif\x00\x00"), append(tiff, ifd...)...)
+	seg := []byte{0xFF, 0xE1, 0, 0}
+	binary.BigEndian.PutUint16(seg[2:], uint16(len(payload)+2))
+	return append(seg, payload...)
+}
+
+func jpegWithExif(t *testing.T, img image.Image, orientation uint16) []byte {
+	var buf bytes.Buffer
+	if err := jpeg.Encode(&buf, img, nil); err != nil {
+		t.Fatal(err)
+	}
+	data := buf.Bytes()
+	return append(append([]byte{0xFF, 0xD8}, exifSegment(orientation)...), data[2:]...)
+}
+
+func TestStripJpeg(t *testing.T) {
+	data := jpegWithExif(t, testImage(40, 20), 1)
+	if jpegOrientation(data) != 1 {
+		t.Fatal("orientation should be 1")
+	}
+	out, err := StripMetadata(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bytes.Contains(out, []byte("Exif")) {
+		t.Error("exif segment not removed")
+	}
+	if _, err = jpeg.Decode(bytes.NewReader(out)); err != nil {
+		t.Error("stripped jpeg is not decodable:", err)
+	}
+}
+
+func TestStripJpegRotated(t *testing.T) {
+	data := jpegWithExif(t, testImage(40, 20), 6)
+	if jpegOrientation(data) != 6 {
+		t.Fatal("orientation should be 6")
+	}
+	out, err := StripMetadata(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfg, err := jpeg.DecodeConfig(bytes.NewReader(out))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cfg.Width != 20 || cfg.Height != 40 {
+		t.Errorf("rotated size = %dx%d, want 20x40", cfg.Width, cfg.Height)
+	}
+}
+
+func TestStripPng(t *testing.T) {
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, testImage(10, 10)); err != nil {
+		t.Fatal(err)
+	}
+	data := buf.Bytes()
+	text := []byte("Commentsecret location")
+	chunk := make([]byte, 8)
+	binary.BigEndian.PutUint32(chunk, uint32(len(text)))
+	copy(chunk[4:], "tEXt")
+	chunk = append(chunk, text...)
+	crc := make([]byte, 4)
+	binary.BigEndian.PutUint32(crc, crc32.ChecksumIEEE(append([]byte("tEXt"), text...)))
+	chunk = append(chunk, crc...)
+	// 插入在IHDR之后
+	data = append(append(append([]byte{}, data[:33]...), chunk...), data[33:]...)
+
+	out, err := StripMetadata(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bytes.Contains(out, []byte("secret location")) {
+		t.Error("text chunk not removed")
+	}
+	if _, err = png.Decode(bytes.NewReader(out)); err != nil {
+		t.Error("stripped png is not decodable:", err)
+	}
+}
+
+func TestResize(t *testing.T) {
+	thumb := Resize(testImage(400, 300), 100)
+	if thumb.Bounds().Dx() != 100 || thumb.Bounds().Dy() != 75 {
+		t.Errorf("thumbnail size = %v", thumb.Bounds())
+	}
+}
+
+func TestBlurHash(t *testing.T) {
+	hash := BlurHash(testImage(32, 24), 4, 3)
+	if len(hash) != 4+2*4*3 {
+		t.Errorf("blurhash length = %d", len(hash))
+	}
+	img := image.NewRGBA(image.Rect(0, 0, 8, 8))
+	for i := range img.Pix {
+		img.Pix[i] = 255
+	}
+	if hash = BlurHash(img, 1, 1); hash != "00TSUA" {
+		t.Errorf("white blurhash = %s", hash)
+	}
+}
+
+func TestPerceptualHash(t *testing.T) {
+	img := testImage(400, 300)
+	var buf bytes.Buffer
+	if err := jpeg.Encode(&buf, Resize(img, 200), &jpeg.Options{Quality: 60}); err != nil {
+		t.Fatal(err)
+	}
+	similar, err := jpeg.Decode(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if d := HammingDistance(DifferenceHash(img), DifferenceHash(similar)); d > 4 {
+		t.Errorf("dHash distance of resized image = %d", d)
+	}
+	if d := HammingDistance(AverageHash(img), AverageHash(similar)); d > 4 {
+		t.Errorf("aHash distance of resized image = %d", d)
+	}
+
+	flipped := image.NewRGBA(img.Bounds())
+	for y := 0; y < 300; y++ {
+		for x := 0; x < 400; x++ {
+			flipped.Set(x, y, img.At(399-x, 299-y))
+		}
+	}
+	if d := HammingDistance(DifferenceHash(img), DifferenceHash(flipped)); d < 20 {
+		t.Errorf("dHash distance of different image = %d", d)
+	}
+}
@@ -0,0 +1,63 @@
+package imaging
+
+import (
+	"image"
+	"math/bits"
+)
+
+// luminance 把图片缩放到w*h后转换为灰度值
+func luminance(img image.Image, w int, h int) []uint32 {
+	small := scale(img, w, h)
+	rtn := make([]uint32, 0, w*h)
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			c := small.RGBAAt(x, y)
+			rtn = append(rtn, (299*uint32(c.R)+587*uint32(c.G)+114*uint32(c.B))/1000)
+		}
+	}
+	return rtn
+}
+
+// AverageHash 计算图片的aHash：缩放到8x8灰度图后，每个像素是否不低于平均亮度
+func AverageHash(img image.Image) uint64 {
+	b := img.Bounds()
+	if b.Dx() == 0 || b.Dy() == 0 {
+		return 0
+	}
+	pixels := luminance(img, 8, 8)
+	var sum uint32
+	for _, p := range pixels {
+		sum += p
+	}
+	avg := sum / uint32(len(pixels))
+	var hash uint64
+	for i, p := range pixels {
+		if p >= avg {
+			hash |= 1 << uint(i)
+		}
+	}
+	return hash
+}
+
+// DifferenceHash 计算图片的dHash：缩放到9x8灰度图后，每个像素是否比右侧像素更亮
+func DifferenceHash(img image.Image) uint64 {
+	b := img.Bounds()
+	if b.Dx() == 0 || b.Dy() == 0 {
+		return 0
+	}
+	pixels := luminance(img, 9, 8)
+	var hash uint64
+	for y := 0; y < 8; y++ {
+		for x := 0; x < 8; x++ {
+			if pixels[y*9+x] > pixels[y*9+x+1] {
+				hash |= 1 << uint(y*8+x)
+			}
+		}
+	}
+	return hash
+}
+
+// HammingDistance 返回两个感知哈希不同的位数
+func HammingDistance(a uint64, b uint64) int {
+	return bits.OnesCount64(a ^ b)
+}
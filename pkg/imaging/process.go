@@ -0,0 +1,99 @@
+package imaging
+
+import (
+	"bytes"
+	"fmt"
+	"image"
+	_ "image/gif"
+	"image/jpeg"
+	_ "image/png"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+const blurHashSourceWidth = 32
+
+type Thumbnail struct {
+	Width  int
+	Height int
+	File   string
+}
+
+// Derived 由原图生成的衍生文件，文件名均相对于原图所在的文件夹
+type Derived struct {
+	BlurHash   string
+	Thumbnails []Thumbnail
+	WebP       string
+}
+
+// Process 读取dir中的原图name，在同一文件夹下生成缩略图和可选的WebP版本。
+// 缩略图命名为<token>_w<width>.jpeg，只生成比原图窄的尺寸；cwebpPath为空时不生成WebP
+func Process(dir string, name string, widths []int, cwebpPath string) (*Derived, error) {
+	srcPath := filepath.Join(dir, name)
+	data, err := ioutil.ReadFile(srcPath)
+	if err != nil {
+		return nil, err
+	}
+	img, format, err := image.Decode(bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	token := strings.TrimSuffix(name, filepath.Ext(name))
+	rtn := &Derived{}
+
+	sorted := append([]int{}, widths...)
+	sort.Sort(sort.Reverse(sort.IntSlice(sorted)))
+	var src image.Image = img
+	for _, width := range sorted {
+		if width <= 0 || width >= src.Bounds().Dx() {
+			continue
+		}
+		// 从上一个较大的缩略图继续缩小，减少计算量
+		thumb := Resize(src, width)
+		file := token + "_w" + strconv.Itoa(width) + ".jpeg"
+		var buf bytes.Buffer
+		if err = jpeg.Encode(&buf, thumb, &jpeg.Options{Quality: 80}); err != nil {
+			return nil, err
+		}
+		if err = ioutil.WriteFile(filepath.Join(dir, file), buf.Bytes(), 0644); err != nil {
+			return nil, err
+		}
+		rtn.Thumbnails = append(rtn.Thumbnails, Thumbnail{Width: thumb.Bounds().Dx(), Height: thumb.Bounds().Dy(), File: file})
+		src = thumb
+	}
+	sort.Slice(rtn.Thumbnails, func(i, j int) bool {
+		return rtn.Thumbnails[i].Width < rtn.Thumbnails[j].Width
+	})
+
+	rtn.BlurHash = BlurHash(Resize(src, blurHashSourceWidth), 4, 3)
+
+	// 动图转换后会丢失动画，因此不生成WebP
+	if len(cwebpPath) > 0 && format != "gif" {
+		file := token + ".webp"
+		dst := filepath.Join(dir, file)
+		out, err2 := exec.Command(cwebpPath, "-quiet", "-q", "80", "-metadata", "none", srcPath, "-o", dst).CombinedOutput()
+		if err2 != nil {
+			_ = os.Remove(dst)
+			return nil, fmt.Errorf("cwebp failed: %s: %w", strings.TrimSpace(string(out)), err2)
+		}
+		rtn.WebP = file
+	}
+	return rtn, nil
+}
+
+// Files 返回所有衍生文件的文件名
+func (d *Derived) Files() []string {
+	files := make([]string, 0, len(d.Thumbnails)+1)
+	for _, t := range d.Thumbnails {
+		files = append(files, t.File)
+	}
+	if len(d.WebP) > 0 {
+		files = append(files, d.WebP)
+	}
+	return files
+}
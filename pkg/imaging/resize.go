@@ -0,0 +1,65 @@
+package imaging
+
+import (
+	"image"
+	"image/color"
+)
+
+// Resize 把图片按面积平均缩小到指定宽度，高度按比例计算，背景透明部分填充为白色
+func Resize(img image.Image, width int) *image.RGBA {
+	b := img.Bounds()
+	sw, sh := b.Dx(), b.Dy()
+	if width <= 0 || sw == 0 || sh == 0 {
+		return image.NewRGBA(image.Rect(0, 0, 0, 0))
+	}
+	if width > sw {
+		width = sw
+	}
+	height := sh * width / sw
+	if height < 1 {
+		height = 1
+	}
+	return scale(img, width, height)
+}
+
+// scale 按面积平均把图片缩放到指定大小
+func scale(img image.Image, width int, height int) *image.RGBA {
+	b := img.Bounds()
+	sw, sh := b.Dx(), b.Dy()
+	dst := image.NewRGBA(image.Rect(0, 0, width, height))
+	for y := 0; y < height; y++ {
+		y0 := y * sh / height
+		y1 := (y + 1) * sh / height
+		if y1 <= y0 {
+			y1 = y0 + 1
+		}
+		for x := 0; x < width; x++ {
+			x0 := x * sw / width
+			x1 := (x + 1) * sw / width
+			if x1 <= x0 {
+				x1 = x0 + 1
+			}
+			var r, g, bl, a uint64
+			for sy := y0; sy < y1; sy++ {
+				for sx := x0; sx < x1; sx++ {
+					cr, cg, cb, ca := img.At(b.Min.X+sx, b.Min.Y+sy).RGBA()
+					r += uint64(cr)
+					g += uint64(cg)
+					bl += uint64(cb)
+					a += uint64(ca)
+				}
+			}
+			n := uint64((x1 - x0) * (y1 - y0))
+			r, g, bl, a = r/n, g/n, bl/n, a/n
+			// 预乘alpha的颜色叠加到白色背景上
+			white := 0xffff - a
+			dst.SetRGBA(x, y, color.RGBA{
+				R: uint8((r + white) >> 8),
+				G: uint8((g + white) >> 8),
+				B: uint8((bl + white) >> 8),
+				A: 0xff,
+			})
+		}
+	}
+	return dst
+}
@@ -0,0 +1,94 @@
+package mail
+
+import (
+	"bytes"
+	htmlTemplate "html/template"
+	"strconv"
+	textTemplate "text/template"
+
+	"github.com/spf13/viper"
+	"gopkg.in/gomail.v2"
+)
+
+// DigestItem 邮件摘要中的一条通知或热门树洞
+type DigestItem struct {
+	PostID int32
+	Title  string
+	Body   string
+}
+
+// Digest 邮件摘要的内容，MoreMessages为没有列出的未读通知数
+type Digest struct {
+	WebsiteName    string
+	Title          string
+	Messages       []DigestItem
+	MoreMessages   int64
+	HotPosts       []DigestItem
+	UnsubscribeURL string
+}
+
+// 树洞内容由用户发布，使用html/template转义
+var digestHtmlTemplate = htmlTemplate.Must(htmlTemplate.New("digest").Parse(`<!DOCTYPE html>
+<html lang="cn">
+<head>
+    <meta charset="UTF-8">
+    <meta name="viewport" content="width=device-width, initial-scale=1.0">
+    <title>{{.Title}}</title>
+</head>
+<body>
+{{if .Messages}}<h3>未读通知</h3>
+{{range .Messages}}<p><strong>{{.Title}}</strong>{{if .PostID}} #{{.PostID}}{{end}}<br>{{.Body}}</p>
+{{end}}{{if .MoreMessages}}<p>还有{{.MoreMessages}}条未读通知，请登录{{.WebsiteName}}查看。</p>
+{{end}}{{end}}{{if .HotPosts}}<h3>热门树洞</h3>
+{{range .HotPosts}}<p><strong>#{{.PostID}}</strong><br>{{.Body}}</p>
+{{end}}{{end}}<hr>
+<p>您收到这封邮件是因为您在{{.WebsiteName}}开启了邮件摘要。<a href="{{.UnsubscribeURL}}">退订</a></p>
+</body>
+</html>`))
+
+var digestTextTemplate = textTemplate.Must(textTemplate.New("digest").Parse(`您好：
+{{if .Messages}}
+未读通知：
+{{range .Messages}}
+{{.Title}}{{if .PostID}} #{{.PostID}}{{end}}
+{{.Body}}
+{{end}}{{if .MoreMessages}}
+还有{{.MoreMessages}}条未读通知，请登录{{.WebsiteName}}查看。
+{{end}}{{end}}{{if .HotPosts}}
+热门树洞：
+{{range .HotPosts}}
+#{{.PostID}}
+{{.Body}}
+{{end}}{{end}}
+您收到这封邮件是因为您在{{.WebsiteName}}开启了邮件摘要。退订：{{.UnsubscribeURL}}
+`))
+
+// SendDigestEmail 发送邮件摘要，邮件头中带有一键退订链接
+func SendDigestEmail(digest Digest, recipient string) error {
+	digest.WebsiteName = viper.GetString("name")
+	digest.Title = "【" + digest.WebsiteName + "】动态摘要"
+	var htmlBody, textBody bytes.Buffer
+	if err := digestHtmlTemplate.Execute(&htmlBody, digest); err != nil {
+		return err
+	}
+	if err := digestTextTemplate.Execute(&textBody, digest); err != nil {
+		return err
+	}
+
+	m := gomail.NewMessage()
+	m.SetHeader("From", viper.GetString("smtp_username"))
+	m.SetHeader("To", recipient)
+	m.SetHeader("Subject", digest.Title)
+	m.SetHeader("List-Unsubscribe", "<"+digest.UnsubscribeURL+">")
+	m.SetHeader("List-Unsubscribe-Post", "List-Unsubscribe=One-Click")
+
+	port, err := strconv.Atoi(viper.GetString("smtp_port"))
+	if err != nil {
+		return err
+	}
+	m.SetBody("text/html", htmlBody.String())
+	m.AddAlternative("text/plain", textBody.String())
+	d := gomail.NewDialer(viper.GetString("smtp_host"), port, viper.GetString("smtp_username"), viper.GetString("smtp_password"))
+
+	return d.DialAndSend(m)
+}
@@ -0,0 +1,273 @@
+package media
+
+import (
+	"errors"
+	"fmt"
+	"github.com/robfig/cron/v3"
+	"github.com/spf13/viper"
+	"gorm.io/gorm"
+	"log"
+	"strings"
+	"time"
+	"treehollow-v3-backend/pkg/base"
+	"treehollow-v3-backend/pkg/storage"
+	"treehollow-v3-backend/pkg/utils"
+)
+
+const batchSize = 1000
+const reportSampleSize = 20
+
+// GCReport 一次图片清理的结果，DryRun时只统计不删除
+type GCReport struct {
+	DryRun        bool
+	DeletedMedia  int
+	OrphanMedia   int
+	LocalFiles    int
+	BucketFiles   int
+	Failed        int
+	SampleKeys    []string
+	ReferencedNum int
+}
+
+func (r *GCReport) addSample(key string) {
+	if len(r.SampleKeys) < reportSampleSize {
+		r.SampleKeys = append(r.SampleKeys, key)
+	}
+}
+
+func (r *GCReport) ToString() string {
+	var sb strings.Builder
+	if r.DryRun {
+		sb.WriteString("图片清理预览（未实际删除）\n")
+	} else {
+		sb.WriteString("图片清理结果\n")
+	}
+	sb.WriteString(fmt.Sprintf("已删除超过%d天的树洞和回复中的图片：%d\n", viper.GetInt("media_gc_retention_days"), r.DeletedMedia))
+	sb.WriteString(fmt.Sprintf("所属树洞已不存在的图片：%d\n", r.OrphanMedia))
+	sb.WriteString(fmt.Sprintf("本地未被引用的文件：%d\n", r.LocalFiles))
+	sb.WriteString(fmt.Sprintf("存储桶中未被引用的文件：%d\n", r.BucketFiles))
+	sb.WriteString(fmt.Sprintf("仍被引用的文件：%d\n", r.ReferencedNum))
+	if r.Failed > 0 {
+		sb.WriteString(fmt.Sprintf("删除失败：%d\n", r.Failed))
+	}
+	if len(r.SampleKeys) > 0 {
+		sb.WriteString("***\n")
+		sb.WriteString(strings.Join(r.SampleKeys, "\n"))
+	}
+	return sb.String()
+}
+
+// RunGC 清理图片：
+// 1. 删除超过media_gc_retention_days天的已删除树洞和回复的图片记录
+// 2. 删除所属树洞已不存在的图片记录
+// 3. 删除images_path和存储桶中没有被任何记录引用的文件
+func RunGC(dryRun bool) (*GCReport, error) {
+	report := &GCReport{DryRun: dryRun}
+	cutoff := time.Now().AddDate(0, 0, -viper.GetInt("media_gc_retention_days"))
+
+	deleted, err := findDeletedMedia(cutoff)
+	if err != nil {
+		return nil, err
+	}
+	report.DeletedMedia = len(deleted)
+	orphans, err := findOrphanMedia()
+	if err != nil {
+		return nil, err
+	}
+	report.OrphanMedia = len(orphans)
+	if !dryRun {
+		for _, ids := range [][]int32{deleted, orphans} {
+			for start := 0; start < len(ids); start += batchSize {
+				end := start + batchSize
+				if end > len(ids) {
+					end = len(ids)
+				}
+				if err = deleteMedia(ids[start:end]); err != nil {
+					return nil, err
+				}
+			}
+		}
+	}
+
+	exclude := make(map[int32]bool, len(deleted)+len(orphans))
+	for _, ids := range [][]int32{deleted, orphans} {
+		for _, id := range ids {
+			exclude[id] = true
+		}
+	}
+	referenced, err := referencedFiles(exclude)
+	if err != nil {
+		return nil, err
+	}
+	report.ReferencedNum = len(referenced)
+
+	// 刚上传的文件可能还没有写入数据库，只清理一段时间之前的文件
+	grace := time.Now().Add(-time.Duration(viper.GetInt("upload_expire_minutes")*2) * time.Minute)
+	if len(viper.GetString("images_path")) > 0 {
+		local := storage.NewLocalStorage(viper.GetString("images_path"), viper.GetString("img_base_url"))
+		report.LocalFiles, err = sweep(local, referenced, grace, dryRun, report)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	// 本地存储时存储桶就是images_path，不需要重复清理
+	if s := storage.Default(); !isLocal(s) {
+		report.BucketFiles, err = sweep(s, referenced, grace, dryRun, report)
+		if errors.Is(err, storage.ErrNoMediaPrefix) {
+			log.Printf("media gc: skip sweeping bucket: %s\n", err)
+		} else if err != nil {
+			return nil, err
+		}
+	}
+	return report, nil
+}
+
+// findDeletedMedia 查找所属树洞或回复被删除超过保留期限的图片
+func findDeletedMedia(cutoff time.Time) ([]int32, error) {
+	var ids []int32
+	err := base.GetDb(false).Table("post_media").
+		Joins("join posts on posts.id = post_media.post_id").
+		Where("posts.deleted_at is not null and posts.deleted_at < ?", cutoff).
+		Pluck("post_media.id", &ids).Error
+	if err != nil {
+		return nil, err
+	}
+	var ids2 []int32
+	err = base.GetDb(false).Table("post_media").
+		Joins("join comments on comments.id = post_media.comment_id").
+		Where("post_media.comment_id != 0 and comments.deleted_at is not null and comments.deleted_at < ?", cutoff).
+		Pluck("post_media.id", &ids2).Error
+	if err != nil {
+		return nil, err
+	}
+	return append(ids, ids2...), nil
+}
+
+// findOrphanMedia 查找所属树洞或回复已经不存在的图片，上传后尚未发送的图片由上传清理任务处理
+func findOrphanMedia() ([]int32, error) {
+	var ids []int32
+	err := base.GetDb(false).Table("post_media").
+		Joins("left join posts on posts.id = post_media.post_id").
+		Where("post_media.post_id != 0 and posts.id is null").
+		Pluck("post_media.id", &ids).Error
+	if err != nil {
+		return nil, err
+	}
+	var ids2 []int32
+	err = base.GetDb(false).Table("post_media").
+		Joins("left join comments on comments.id = post_media.comment_id").
+		Where("post_media.comment_id != 0 and comments.id is null").
+		Pluck("post_media.id", &ids2).Error
+	if err != nil {
+		return nil, err
+	}
+	return append(ids, ids2...), nil
+}
+
+// deleteMedia 删除图片记录并减少对应内容哈希的引用计数，文件由之后的清理步骤按引用情况删除
+func deleteMedia(ids []int32) error {
+	return base.GetDb(false).Transaction(func(tx *gorm.DB) error {
+		var media []base.PostMedia
+		if err := tx.Where("id in ?", ids).Find(&media).Error; err != nil {
+			return err
+		}
+		if err := tx.Where("id in ?", ids).Delete(&base.PostMedia{}).Error; err != nil {
+			return err
+		}
+		_, err := base.ReleaseMediaBlobs(tx, media)
+		return err
+	})
+}
+
+// referencedFiles 返回所有仍被引用的文件的哈希路径，包括尚未迁移到post_media的旧图片
+func referencedFiles(exclude map[int32]bool) (map[string]bool, error) {
+	referenced := make(map[string]bool)
+	var media []base.PostMedia
+	lastID := int32(0)
+	for {
+		media = media[:0]
+		err := base.GetDb(false).Where("id > ?", lastID).Order("id asc").Limit(batchSize).Find(&media).Error
+		if err != nil {
+			return nil, err
+		}
+		if len(media) == 0 {
+			break
+		}
+		for _, m := range media {
+			if !exclude[m.ID] {
+				for _, file := range m.Files() {
+					referenced[file] = true
+				}
+			}
+		}
+		lastID = media[len(media)-1].ID
+	}
+
+	for _, table := range []string{"posts", "comments"} {
+		var paths []string
+		err := base.GetDb(false).Table(table).Where("file_path != ''").Pluck("file_path", &paths).Error
+		if err != nil {
+			return nil, err
+		}
+		for _, path := range paths {
+			referenced[utils.GetHashedFilePath(path)] = true
+		}
+	}
+	return referenced, nil
+}
+
+func isLocal(s storage.Storage) bool {
+	_, ok := s.(*storage.LocalStorage)
+	return ok
+}
+
+func sweep(s storage.Storage, referenced map[string]bool, grace time.Time, dryRun bool, report *GCReport) (int, error) {
+	lister, ok := s.(storage.Lister)
+	if !ok {
+		return 0, nil
+	}
+	var keys []string
+	err := lister.List(func(key string, modTime time.Time) error {
+		if !referenced[key] && modTime.Before(grace) {
+			keys = append(keys, key)
+		}
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+	for _, key := range keys {
+		report.addSample(key)
+		if dryRun {
+			continue
+		}
+		if err = s.Delete(key); err != nil {
+			log.Printf("media gc: delete %s failed: %s\n", key, err)
+			report.Failed++
+		}
+	}
+	return len(keys), nil
+}
+
+// InitGCCron 按media_gc_cron定时清理图片，为空时不启用
+func InitGCCron() {
+	spec := viper.GetString("media_gc_cron")
+	if len(spec) == 0 {
+		return
+	}
+	c := cron.New()
+	_, err := c.AddFunc(spec, func() {
+		report, err := RunGC(false)
+		if err != nil {
+			log.Printf("media gc failed: %s\n", err)
+			return
+		}
+		log.Printf("media gc finished: %s\n", strings.ReplaceAll(report.ToString(), "\n", "; "))
+	})
+	if err != nil {
+		log.Printf("invalid media_gc_cron %s: %s\n", spec, err)
+		return
+	}
+	c.Start()
+}
@@ -0,0 +1,51 @@
+package media
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+	"treehollow-v3-backend/pkg/storage"
+)
+
+func TestSweep(t *testing.T) {
+	root := t.TempDir()
+	for _, key := range []string{"ab/abcd.jpeg", "ab/abcd_w320.jpeg", "cd/cdef.png"} {
+		_ = os.MkdirAll(filepath.Join(root, filepath.Dir(key)), os.ModePerm)
+		if err := ioutil.WriteFile(filepath.Join(root, key), []byte("x"), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	s := storage.NewLocalStorage(root, "")
+	referenced := map[string]bool{"ab/abcd.jpeg": true}
+	grace := time.Now().Add(time.Minute)
+
+	report := &GCReport{DryRun: true}
+	n, err := sweep(s, referenced, grace, true, report)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != 2 || len(report.SampleKeys) != 2 {
+		t.Fatalf("dry-run found %d files, samples=%v", n, report.SampleKeys)
+	}
+	if _, err = os.Stat(filepath.Join(root, "cd/cdef.png")); err != nil {
+		t.Error("dry-run should not delete files")
+	}
+
+	n, err = sweep(s, referenced, grace, false, &GCReport{})
+	if err != nil || n != 2 {
+		t.Fatalf("n=%d err=%v", n, err)
+	}
+	if _, err = os.Stat(filepath.Join(root, "cd/cdef.png")); !os.IsNotExist(err) {
+		t.Error("unreferenced file should be deleted")
+	}
+	if _, err = os.Stat(filepath.Join(root, "ab/abcd.jpeg")); err != nil {
+		t.Error("referenced file should be kept")
+	}
+
+	n, _ = sweep(s, map[string]bool{}, time.Now().Add(-time.Hour), true, &GCReport{})
+	if n != 0 {
+		t.Error("files newer than grace period should be kept")
+	}
+}
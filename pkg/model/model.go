@@ -15,6 +15,9 @@ const (
 	SystemMessage      PushType = 0x01
 	ReplyMeComment     PushType = 0x02
 	CommentInFavorited PushType = 0x04
+	PostReferenced     PushType = 0x08
+	PostQuoted         PushType = 0x10
+	PrivateMessage     PushType = 0x20
 )
 
 type SearchOrder int8
@@ -70,6 +70,12 @@ func SendMessages(msgs []base.PushMessage, Api *API, isDoRecall bool) {
 		return
 	}
 
+	// 通知角标显示未读通知数
+	unread, err := base.CountUnreadMsgs(base.GetDb(false), pushUserIDs, false)
+	if err != nil {
+		log.Printf("count unread messages failed: %s", err)
+	}
+
 	pushClient, err := getIOSPushClient()
 	if err != nil {
 		log.Printf("getIOSPushClient error: %s\n", err)
@@ -85,11 +91,11 @@ func SendMessages(msgs []base.PushMessage, Api *API, isDoRecall bool) {
 				var p *payload.Payload
 				if isDoRecall {
 					p = payload.NewPayload().AlertTitle("消息已被删除").Custom("delete", 1).
-						Custom("pid", msg.PostID)
+						Custom("pid", msg.PostID).Badge(int(unread[device.UserID]))
 				} else {
 					p = payload.NewPayload().AlertTitle(utils.TrimText(msg.Title, 50)).
-						AlertBody(utils.TrimText(msg.Message, 100)).Sound("default")
-					if (msg.Type & (model.ReplyMeComment | model.CommentInFavorited)) > 0 {
+						AlertBody(utils.TrimText(msg.Message, 100)).Sound("default").Badge(int(unread[device.UserID]))
+					if (msg.Type & (model.ReplyMeComment | model.CommentInFavorited | model.PostReferenced | model.PostQuoted | model.PrivateMessage)) > 0 {
 						p = p.Custom("pid", msg.PostID).Custom("cid", msg.CommentID)
 					}
 					p.Custom("type", msg.Type)
@@ -144,11 +150,12 @@ func SendMessages(msgs []base.PushMessage, Api *API, isDoRecall bool) {
 					"type":      msg.Type,
 					"timestamp": msg.UpdatedAt.Unix(),
 				}
-				if (msg.Type & (model.ReplyMeComment | model.CommentInFavorited)) > 0 {
+				if (msg.Type & (model.ReplyMeComment | model.CommentInFavorited | model.PostReferenced | model.PostQuoted | model.PrivateMessage)) > 0 {
 					p["pid"] = msg.PostID
 					p["cid"] = msg.CommentID
 				}
 			}
+			p["badge"] = unread[device.UserID]
 			postBody, _ := json.Marshal(p)
 			Api.Notify(device.Token, &postBody)
 		}
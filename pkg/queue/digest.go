@@ -0,0 +1,170 @@
+package queue
+
+import (
+	"context"
+	"errors"
+	"log"
+	"strconv"
+	"strings"
+	"time"
+	"treehollow-v3-backend/pkg/base"
+	"treehollow-v3-backend/pkg/consts"
+	"treehollow-v3-backend/pkg/mail"
+	"treehollow-v3-backend/pkg/utils"
+
+	"github.com/robfig/cron/v3"
+	"github.com/spf13/viper"
+	"github.com/vmihailenco/msgpack/v5"
+	"gorm.io/gorm"
+)
+
+// NextDigestTime 下一次发送邮件摘要的时间，按服务器所在时区计算
+func NextDigestTime(settings *base.DigestSettings, now time.Time) time.Time {
+	t := time.Date(now.Year(), now.Month(), now.Day(), int(settings.Hour), 0, 0, 0, now.Location())
+	if settings.Frequency == base.WeeklyDigest {
+		t = t.AddDate(0, 0, (int(settings.Weekday)-int(t.Weekday())+7)%7)
+	}
+	for !t.After(now) {
+		t = t.AddDate(0, 0, int(settings.Frequency))
+	}
+	return t
+}
+
+// DigestUnsubscribeURL 邮件中的一键退订链接，digest_api_base_url为security接口的地址
+func DigestUnsubscribeURL(token string) string {
+	return strings.TrimSuffix(viper.GetString("digest_api_base_url"), "/") + "/v3/security/digest/unsubscribe?token=" + token
+}
+
+// ScheduleDigest 计算下一次发送时间并加入延迟队列。只有NextSendAt没有被其他人修改时才会加入，
+// 避免多个实例重复发送
+func ScheduleDigest(settings *base.DigestSettings) error {
+	if settings.Frequency == base.NoDigest {
+		return nil
+	}
+	next := NextDigestTime(settings, time.Now())
+	result := base.GetDb(false).Model(&base.DigestSettings{}).
+		Where("user_id = ? and next_send_at <=> ?", settings.UserID, settings.NextSendAt).
+		UpdateColumn("next_send_at", next)
+	if result.Error != nil || result.RowsAffected == 0 {
+		return result.Error
+	}
+	settings.NextSendAt = &next
+	return EnqueueWithDelay(time.Until(next), TaskSendDigest, DigestPayload{
+		UserID:      settings.UserID,
+		ScheduledAt: next.Unix(),
+	})
+}
+
+// InitDigestCron 每小时检查一次错过发送时间的摘要，例如redis中的任务丢失时，重新加入队列
+func InitDigestCron() {
+	c := cron.New()
+	_, _ = c.AddFunc("@hourly", func() {
+		var settingsList []base.DigestSettings
+		err := base.GetDb(false).Where("frequency > 0 and next_send_at < ?", time.Now().Add(-time.Hour)).
+			Find(&settingsList).Error
+		if err != nil {
+			log.Printf("read missed digests failed: %s\n", err)
+			return
+		}
+		for i := range settingsList {
+			if err = ScheduleDigest(&settingsList[i]); err != nil {
+				log.Printf("reschedule digest of user %d failed: %s\n", settingsList[i].UserID, err)
+			}
+		}
+	})
+	c.Start()
+}
+
+func getDigestHotPosts() ([]mail.DigestItem, error) {
+	idStrs, err := base.GetRedisClient().ZRevRange(context.Background(), base.HotListKey, 0, consts.DigestHotPosts-1).Result()
+	if err != nil {
+		return nil, err
+	}
+	pids := make([]int32, 0, len(idStrs))
+	for _, s := range idStrs {
+		if id, err2 := strconv.Atoi(s); err2 == nil {
+			pids = append(pids, int32(id))
+		}
+	}
+	var posts []base.Post
+	if err = base.GetDb(false).Where("id in ?", pids).Find(&posts).Error; err != nil {
+		return nil, err
+	}
+	postMap := make(map[int32]base.Post, len(posts))
+	for _, post := range posts {
+		postMap[post.ID] = post
+	}
+	items := make([]mail.DigestItem, 0, len(pids))
+	for _, pid := range pids {
+		if post, ok := postMap[pid]; ok {
+			items = append(items, mail.DigestItem{PostID: post.ID, Body: utils.TrimText(post.Text, 100)})
+		}
+	}
+	return items, nil
+}
+
+// handleSendDigest 发送邮件摘要，包含上次摘要之后的未读通知和热门树洞，没有新的未读通知时不发送。
+// 无论是否发送成功都会安排下一次摘要
+func handleSendDigest(payloadBytes []byte) error {
+	var payload DigestPayload
+	if err := msgpack.Unmarshal(payloadBytes, &payload); err != nil {
+		return err
+	}
+	var settings base.DigestSettings
+	err := base.GetDb(false).First(&settings, payload.UserID).Error
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil
+		}
+		return err
+	}
+	if settings.Frequency == base.NoDigest || settings.NextSendAt == nil || settings.NextSendAt.Unix() != payload.ScheduledAt {
+		return nil
+	}
+	defer func() {
+		if err2 := ScheduleDigest(&settings); err2 != nil {
+			log.Printf("schedule digest of user %d failed: %s\n", settings.UserID, err2)
+		}
+	}()
+
+	tx := base.GetDb(false).Model(&base.PushMessage{}).Where("user_id = ? and is_read = ?", settings.UserID, false)
+	if settings.LastSentAt != nil {
+		tx = tx.Where("updated_at > ?", *settings.LastSentAt)
+	}
+	var total int64
+	if err = tx.Count(&total).Error; err != nil || total == 0 {
+		return err
+	}
+	var msgs []base.PushMessage
+	if err = tx.Order("id desc").Limit(consts.DigestMaxMessages).Find(&msgs).Error; err != nil {
+		return err
+	}
+	hotPosts, err := getDigestHotPosts()
+	if err != nil {
+		return err
+	}
+	recipient, err := utils.AESDecrypt(settings.EmailEncrypted, viper.GetString("digest_email_key"))
+	if err != nil {
+		return err
+	}
+
+	digest := mail.Digest{
+		Messages:       make([]mail.DigestItem, 0, len(msgs)),
+		MoreMessages:   total - int64(len(msgs)),
+		HotPosts:       hotPosts,
+		UnsubscribeURL: DigestUnsubscribeURL(settings.UnsubscribeToken),
+	}
+	for _, msg := range msgs {
+		digest.Messages = append(digest.Messages, mail.DigestItem{
+			PostID: msg.PostID,
+			Title:  msg.Title,
+			Body:   utils.TrimText(msg.Message, 100),
+		})
+	}
+	if err = mail.SendDigestEmail(digest, recipient); err != nil {
+		return err
+	}
+	now := time.Now()
+	return base.GetDb(false).Model(&base.DigestSettings{}).Where("user_id = ?", settings.UserID).
+		UpdateColumn("last_sent_at", now).Error
+}
@@ -7,6 +7,11 @@ type TaskType string
 const (
 	TaskSendEmail        TaskType = "email:send"
 	TaskPushNotification TaskType = "notification:push"
+	TaskProcessImage     TaskType = "image:process"
+	TaskReferenceNotify  TaskType = "notification:reference"
+	TaskQuoteNotify      TaskType = "notification:quote"
+	TaskPrivateMsgNotify TaskType = "notification:private_message"
+	TaskSendDigest       TaskType = "email:digest"
 )
 
 // EmailPayload 定义了发送邮件任务所需的数据
@@ -25,6 +30,42 @@ type PushNotificationPayload struct {
 	CommentText      string
 	CommenterName    string
 	ReplyToCommentID int
+	// 回复中@的化名，在worker中通过PostCommenter对应到用户
+	MentionedNames []string
+}
+
+// ProcessImagePayload 定义了生成缩略图等衍生图片任务所需的数据
+type ProcessImagePayload struct {
+	MediaID int32
+}
+
+// ReferenceNotificationPayload 定义了通知被引用树洞的关注者所需的数据，
+// 引用关系在worker中从post_references读取
+type ReferenceNotificationPayload struct {
+	SrcPostID    int32
+	SrcCommentID int32
+	UserID       int32
+}
+
+// QuoteNotificationPayload 定义了通知被引用转发的树洞或回复作者所需的数据
+type QuoteNotificationPayload struct {
+	PostID         int32
+	QuotePostID    int32
+	QuoteCommentID int32
+	UserID         int32
+}
+
+// PrivateMessageNotificationPayload 定义了通知私信接收者所需的数据，
+// 会话和接收者在worker中从数据库读取
+type PrivateMessageNotificationPayload struct {
+	MessageID int32
+}
+
+// DigestPayload 定义了发送邮件摘要所需的数据，ScheduledAt与设置中的NextSendAt不同时，
+// 说明用户已经修改了设置，任务作废
+type DigestPayload struct {
+	UserID      int32
+	ScheduledAt int64
 }
 
 // FullPushNotificationTask 包含了处理推送所需的完整上下文
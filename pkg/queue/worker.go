@@ -1,19 +1,25 @@
 package queue
 
 import (
+	"bytes"
 	"context"
 	"errors"
+	"io/ioutil"
 	"log"
+	"os"
+	"path/filepath"
 	"strconv"
 	"time"
 	"treehollow-v3-backend/pkg/base"
 	// "treehollow-v3-backend/pkg/mail"
 	"treehollow-v3-backend/pkg/model"
+	"treehollow-v3-backend/pkg/storage"
 	"treehollow-v3-backend/pkg/utils"
 
 	"gorm.io/gorm"
 
 	"github.com/go-redis/redis/v8"
+	"github.com/spf13/viper"
 	"github.com/vmihailenco/msgpack/v5"
 )
 
@@ -92,6 +98,16 @@ func processTask(task Task) error {
 		return handleSendEmail(task.Payload)
 	case TaskPushNotification:
 		return handlePushNotification(task.Payload)
+	case TaskProcessImage:
+		return handleProcessImage(task.Payload)
+	case TaskReferenceNotify:
+		return handleReferenceNotification(task.Payload)
+	case TaskQuoteNotify:
+		return handleQuoteNotification(task.Payload)
+	case TaskPrivateMsgNotify:
+		return handlePrivateMessageNotification(task.Payload)
+	case TaskSendDigest:
+		return handleSendDigest(task.Payload)
 	default:
 		return errors.New("unknown task type: " + string(task.Type))
 	}
@@ -124,6 +140,64 @@ func handleSendEmail(payloadBytes []byte) error {
 	// return err
 }
 
+// handleProcessImage 为已保存的图片生成衍生文件，上传后更新图片的metadata
+func handleProcessImage(payloadBytes []byte) error {
+	var payload ProcessImagePayload
+	if err := msgpack.Unmarshal(payloadBytes, &payload); err != nil {
+		return err
+	}
+
+	db := base.GetDb(false)
+	var media base.PostMedia
+	if err := db.First(&media, payload.MediaID).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil
+		}
+		return err
+	}
+	if media.Kind == base.MediaKindAudio {
+		return nil
+	}
+
+	s := storage.Default()
+	localPath := filepath.Join(viper.GetString("images_path"), utils.GetHashedFilePath(media.FilePath))
+	if _, err := os.Stat(localPath); os.IsNotExist(err) {
+		// 本机没有原图时先从存储后端下载
+		if err = fetchFromStorage(s, utils.GetHashedFilePath(media.FilePath), localPath); err != nil {
+			return err
+		}
+	}
+
+	metaStr, files, err := utils.ProcessImage(media.FilePath, media.FileMetadata)
+	if err != nil {
+		return err.Err
+	}
+	for _, file := range files {
+		data, err2 := ioutil.ReadFile(filepath.Join(viper.GetString("images_path"), file))
+		if err2 != nil {
+			return err2
+		}
+		if err2 = s.Put(file, bytes.NewReader(data)); err2 != nil {
+			log.Printf("storage upload failed, err=%s\n", err2)
+		}
+	}
+	return base.UpdateMediaMetadata(db, &media, metaStr)
+}
+
+func fetchFromStorage(s storage.Storage, key string, localPath string) error {
+	r, err := s.Get(key)
+	if err != nil {
+		return err
+	}
+	defer r.Close()
+	data, err := ioutil.ReadAll(r)
+	if err != nil {
+		return err
+	}
+	_ = os.MkdirAll(filepath.Dir(localPath), os.ModePerm)
+	return ioutil.WriteFile(localPath, data, 0644)
+}
+
 // handlePushNotification 处理推送通知任务 (从 routeApiPOST.go 移动并适配)
 func handlePushNotification(payloadBytes []byte) error {
 	var payload PushNotificationPayload
@@ -194,6 +268,38 @@ func handlePushNotification(payloadBytes []byte) error {
 		}
 	}
 
+	// 被@的用户收到ReplyMeComment类型的通知，已经是回复对象或关注者时只合并通知类型
+	if len(payload.MentionedNames) > 0 {
+		notified := make(map[int32]int, len(pushMessages))
+		for i, msg := range pushMessages {
+			notified[msg.UserID] = i
+		}
+		for _, name := range payload.MentionedNames {
+			uid, ok, err := base.GetUserByPseudonym(db, &post, name)
+			if err != nil {
+				log.Printf("Error getting mentioned user for push notification: %v", err)
+				return err
+			}
+			if !ok || uid == payload.CommenterUserID {
+				continue
+			}
+			if i, ok := notified[uid]; ok {
+				pushMessages[i].Type |= model.ReplyMeComment
+				continue
+			}
+			notified[uid] = len(pushMessages)
+			pushMessages = append(pushMessages, base.PushMessage{
+				Message:   utils.TrimText(payload.CommentText, 100),
+				Title:     payload.CommenterName + "在树洞#" + strconv.Itoa(int(post.ID)) + "中提到了你",
+				PostID:    post.ID,
+				CommentID: payload.CommentID,
+				Type:      model.ReplyMeComment,
+				UserID:    uid,
+				UpdatedAt: time.Now(),
+			})
+		}
+	}
+
 	err = base.PreProcessPushMessages(db, pushMessages)
 	if err != nil {
 		log.Printf("Error preprocessing push messages: %v", err)
@@ -201,4 +307,188 @@ func handlePushNotification(payloadBytes []byte) error {
 	}
 	base.SendToPushService(pushMessages)
 	return nil
-}
\ No newline at end of file
+}
+
+// handleReferenceNotification 通知被引用树洞的关注者，每个用户只收到一条通知
+func handleReferenceNotification(payloadBytes []byte) error {
+	var payload ReferenceNotificationPayload
+	if err := msgpack.Unmarshal(payloadBytes, &payload); err != nil {
+		return err
+	}
+
+	db := base.GetDb(false)
+	var text string
+	source := "#" + strconv.Itoa(int(payload.SrcPostID))
+	if payload.SrcCommentID > 0 {
+		var comment base.Comment
+		if err := db.First(&comment, payload.SrcCommentID).Error; err != nil {
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				return nil
+			}
+			return err
+		}
+		text = comment.Text
+		source += "-" + strconv.Itoa(int(payload.SrcCommentID))
+	} else {
+		var post base.Post
+		if err := db.First(&post, payload.SrcPostID).Error; err != nil {
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				return nil
+			}
+			return err
+		}
+		text = post.Text
+	}
+
+	var refs []base.PostReference
+	err := db.Where("src_post_id = ? and src_comment_id = ?", payload.SrcPostID, payload.SrcCommentID).
+		Find(&refs).Error
+	if err != nil {
+		return err
+	}
+	if len(refs) == 0 {
+		return nil
+	}
+	pids := make([]int32, 0, len(refs))
+	for _, ref := range refs {
+		pids = append(pids, ref.PostID)
+	}
+
+	var attentions []base.Attention
+	if err = db.Where("post_id in ?", pids).Order("post_id asc").Find(&attentions).Error; err != nil {
+		return err
+	}
+	notified := make(map[int32]bool)
+	pushMessages := make([]base.PushMessage, 0, len(attentions))
+	for _, attention := range attentions {
+		if attention.UserID == payload.UserID || notified[attention.UserID] {
+			continue
+		}
+		notified[attention.UserID] = true
+		pushMessages = append(pushMessages, base.PushMessage{
+			Message:   utils.TrimText(text, 100),
+			Title:     "你关注的树洞#" + strconv.Itoa(int(attention.PostID)) + "被" + source + "引用",
+			PostID:    payload.SrcPostID,
+			CommentID: payload.SrcCommentID,
+			Type:      model.PostReferenced,
+			UserID:    attention.UserID,
+			UpdatedAt: time.Now(),
+		})
+	}
+	if len(pushMessages) == 0 {
+		return nil
+	}
+
+	if err = base.PreProcessPushMessages(db, pushMessages); err != nil {
+		log.Printf("Error preprocessing push messages: %v", err)
+		return err
+	}
+	base.SendToPushService(pushMessages)
+	return nil
+}
+
+// handleQuoteNotification 通知被引用转发的树洞或回复的作者
+func handleQuoteNotification(payloadBytes []byte) error {
+	var payload QuoteNotificationPayload
+	if err := msgpack.Unmarshal(payloadBytes, &payload); err != nil {
+		return err
+	}
+
+	db := base.GetDb(false)
+	var post base.Post
+	if err := db.First(&post, payload.PostID).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil
+		}
+		return err
+	}
+
+	var authorID int32
+	var title string
+	if payload.QuoteCommentID > 0 {
+		var comment base.Comment
+		if err := db.First(&comment, payload.QuoteCommentID).Error; err != nil {
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				return nil
+			}
+			return err
+		}
+		authorID = comment.UserID
+		title = "你在树洞#" + strconv.Itoa(int(payload.QuotePostID)) + "的回复被树洞#" +
+			strconv.Itoa(int(post.ID)) + "引用"
+	} else {
+		var quoted base.Post
+		if err := db.First(&quoted, payload.QuotePostID).Error; err != nil {
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				return nil
+			}
+			return err
+		}
+		authorID = quoted.UserID
+		title = "你的树洞#" + strconv.Itoa(int(payload.QuotePostID)) + "被树洞#" + strconv.Itoa(int(post.ID)) + "引用"
+	}
+	if authorID == payload.UserID {
+		return nil
+	}
+
+	pushMessages := []base.PushMessage{{
+		Message:   utils.TrimText(post.Text, 100),
+		Title:     title,
+		PostID:    post.ID,
+		CommentID: 0,
+		Type:      model.PostQuoted,
+		UserID:    authorID,
+		UpdatedAt: time.Now(),
+	}}
+	if err := base.PreProcessPushMessages(db, pushMessages); err != nil {
+		log.Printf("Error preprocessing push messages: %v", err)
+		return err
+	}
+	base.SendToPushService(pushMessages)
+	return nil
+}
+
+// handlePrivateMessageNotification 通知私信的接收者，标题中只出现发送者的化名
+func handlePrivateMessageNotification(payloadBytes []byte) error {
+	var payload PrivateMessageNotificationPayload
+	if err := msgpack.Unmarshal(payloadBytes, &payload); err != nil {
+		return err
+	}
+
+	db := base.GetDb(false)
+	var msg base.PrivateMessage
+	if err := db.First(&msg, payload.MessageID).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil
+		}
+		return err
+	}
+	var conv base.PrivateConversation
+	if err := db.First(&conv, msg.ConversationID).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil
+		}
+		return err
+	}
+
+	receiverID, senderName := conv.UserB, conv.NameA
+	if msg.SenderID == conv.UserB {
+		receiverID, senderName = conv.UserA, conv.NameB
+	}
+
+	pushMessages := []base.PushMessage{{
+		Message:   utils.TrimText(msg.Text, 100),
+		Title:     "树洞#" + strconv.Itoa(int(conv.PostID)) + "的" + senderName + "给你发来私信",
+		PostID:    conv.PostID,
+		CommentID: 0,
+		Type:      model.PrivateMessage,
+		UserID:    receiverID,
+		UpdatedAt: time.Now(),
+	}}
+	if err := base.PreProcessPushMessages(db, pushMessages); err != nil {
+		log.Printf("Error preprocessing push messages: %v", err)
+		return err
+	}
+	base.SendToPushService(pushMessages)
+	return nil
+}
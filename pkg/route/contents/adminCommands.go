@@ -4,14 +4,17 @@ import (
 	"fmt"
 	"github.com/gin-gonic/gin"
 	"github.com/spf13/viper"
+	"io/ioutil"
 	"log"
 	"net/http"
 	"os"
 	"strconv"
+	"strings"
 	"time"
 	"treehollow-v3-backend/pkg/base"
 	"treehollow-v3-backend/pkg/consts"
 	"treehollow-v3-backend/pkg/logger"
+	"treehollow-v3-backend/pkg/media"
 	"treehollow-v3-backend/pkg/utils"
 )
 
@@ -46,11 +49,27 @@ func adminHelpCommand() gin.HandlerFunc {
 				info += "`log_tags`: 查看所有【管理员打Tag】的操作日志\n"
 				info += "`log_dels`: 查看所有的【管理员删除】\n"
 				info += "`log_unbans`: 查看所有【撤销删除】、【解禁】的操作日志\n"
+				info += "`log_officials`: 查看所有使用官方身份发布的日志\n"
+				info += "`rep_pms`: 查看所有用户的【私信举报】\n"
 				info += "`logs`: 查看所有举报、删帖、打tag的操作日志\n"
 			}
 			if base.CanShutdown(&user) {
 				info += "`shutdown`: 关闭树洞, 请谨慎使用此命令\n"
 			}
+			if base.CanRunMediaGC(&user) {
+				info += "`gc_images`: 预览可以清理的图片文件（不会实际删除）\n"
+			}
+			if base.CanManageImageBlocks(&user) {
+				info += "`block_image 树洞号[-回复号] [hold] [阈值]`: 屏蔽该树洞或回复中的图片，之后发送的相似图片会被拒绝，加hold则改为发送后待审核\n"
+				info += "`unblock_image 屏蔽编号`: 取消屏蔽图片\n"
+				info += "`image_blocks`: 查看所有被屏蔽的图片\n"
+			}
+			if base.CanManageBoards(&user) {
+				info += "`boards`: 查看所有版块及版主\n"
+				info += "`board_create 版块名 [简介]`: 创建版块\n"
+				info += "`board_set 版块编号 name|desc|types|roles|timeline 值`: 修改版块的名称、简介、允许的树洞类型、可发帖的角色、是否显示在主时间线\n"
+				info += "`board_mod 版块编号 用户ID`、`board_unmod 版块编号 用户ID`: 添加、移除版主\n"
+			}
 
 			if base.GetDeletePostRateLimitIn24h(user.Role) > 0 {
 				uidStr := strconv.Itoa(int(user.ID))
@@ -201,7 +220,7 @@ func adminLogsCommand() gin.HandlerFunc {
 		keywords := c.Query("keywords")
 		if base.CanViewLogs(&user) {
 			if _, ok := utils.ContainsString([]string{"logs", "rep_dels", "rep_folds", "log_tags", "log_dels",
-				"rep_recalls", "log_unbans"}, keywords); ok {
+				"rep_recalls", "log_unbans", "log_officials", "rep_pms"}, keywords); ok {
 
 				page := c.MustGet("page").(int)
 				offset := (page - 1) * consts.SearchPageSize
@@ -216,7 +235,7 @@ func adminLogsCommand() gin.HandlerFunc {
 					err = base.GetDb(false).Order("id desc").Where(base.GetDb(false).
 						Where("type = ?", base.UserDelete).
 						Where("user_id != reported_user_id")).
-						Or("type = ?", base.AdminDeleteAndBan).Limit(limit).Offset(offset).Find(&reports).Error
+						Or("type in (?)", []base.ReportType{base.AdminDeleteAndBan, base.ImageHold, base.BoardModDelete}).Limit(limit).Offset(offset).Find(&reports).Error
 				} else if keywords == "rep_recalls" {
 					err = base.GetDb(false).Order("id desc").Where("type = ?", base.UserDelete).
 						Where("user_id = reported_user_id").Limit(limit).Offset(offset).Find(&reports).Error
@@ -229,6 +248,12 @@ func adminLogsCommand() gin.HandlerFunc {
 				} else if keywords == "log_tags" {
 					err = base.GetDb(false).Order("id desc").Where("type = ?", base.AdminTag).
 						Limit(limit).Offset(offset).Find(&reports).Error
+				} else if keywords == "log_officials" {
+					err = base.GetDb(false).Order("id desc").Where("type = ?", base.OfficialIdentity).
+						Limit(limit).Offset(offset).Find(&reports).Error
+				} else if keywords == "rep_pms" {
+					err = base.GetDb(false).Order("id desc").Where("type = ?", base.PrivateMsgReport).
+						Limit(limit).Offset(offset).Find(&reports).Error
 				} else if keywords == "log_unbans" {
 					err = base.GetDb(false).Order("id desc").Where("type in (?)",
 						[]base.ReportType{base.AdminUnban, base.AdminUndelete}).
@@ -296,6 +321,167 @@ func adminSysMsgsCommand() gin.HandlerFunc {
 	}
 }
 
+func adminMediaGCCommand() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if !viper.GetBool("allow_admin_commands") {
+			c.Next()
+			return
+		}
+		user := c.MustGet("user").(base.User)
+		keywords := c.Query("keywords")
+		if base.CanRunMediaGC(&user) && keywords == "gc_images" {
+			report, err := media.RunGC(true)
+			if err != nil {
+				base.HttpReturnWithCodeMinusOneAndAbort(c, logger.NewError(err, "MediaGCDryRunFailed", consts.DatabaseReadFailedString))
+				return
+			}
+			httpReturnInfo(c, report.ToString())
+			return
+		}
+		c.Next()
+	}
+}
+
+func adminImageBlockCommand() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if !viper.GetBool("allow_admin_commands") {
+			c.Next()
+			return
+		}
+		user := c.MustGet("user").(base.User)
+		keywords := c.Query("keywords")
+		if !base.CanManageImageBlocks(&user) {
+			c.Next()
+			return
+		}
+		fields := strings.Fields(keywords)
+		if len(fields) == 0 {
+			c.Next()
+			return
+		}
+		switch fields[0] {
+		case "block_image":
+			blockImages(c, &user, fields[1:])
+		case "unblock_image":
+			if len(fields) != 2 {
+				httpReturnInfo(c, "用法：`unblock_image 屏蔽编号`")
+				return
+			}
+			id, err := strconv.Atoi(fields[1])
+			if err != nil {
+				httpReturnInfo(c, "屏蔽编号不合法")
+				return
+			}
+			result := base.GetDb(false).Delete(&base.ImageBlock{}, int32(id))
+			if result.Error != nil {
+				base.HttpReturnWithCodeMinusOneAndAbort(c, logger.NewError(result.Error, "UnblockImageFailed", consts.DatabaseWriteFailedString))
+				return
+			}
+			if result.RowsAffected == 0 {
+				httpReturnInfo(c, "没有找到这条屏蔽记录")
+				return
+			}
+			base.RefreshImageBlocks()
+			log.Printf("user %d unblocked image #%d\n", user.ID, id)
+			httpReturnInfo(c, fmt.Sprintf("已取消屏蔽#%d", id))
+		case "image_blocks":
+			blocks, err := base.GetImageBlocks(base.GetDb(false))
+			if err != nil {
+				base.HttpReturnWithCodeMinusOneAndAbort(c, logger.NewError(err, "GetImageBlocksFailed", consts.DatabaseReadFailedString))
+				return
+			}
+			info := fmt.Sprintf("共%d条屏蔽记录\n", len(blocks))
+			for _, block := range blocks {
+				info += fmt.Sprintf("#%d: 来自#%d-%d, 阈值%d, %s, %s\n", block.ID, block.PostID, block.CommentID,
+					block.Threshold, utils.IfThenElse(block.Hold, "待审核", "拒绝").(string),
+					block.CreatedAt.Format("2006-01-02 15:04"))
+			}
+			httpReturnInfo(c, info)
+		default:
+			c.Next()
+		}
+	}
+}
+
+// blockImages 把树洞或回复中的所有图片加入屏蔽列表，参数为`树洞号[-回复号] [hold] [阈值]`
+func blockImages(c *gin.Context, user *base.User, args []string) {
+	if len(args) == 0 {
+		httpReturnInfo(c, "用法：`block_image 树洞号[-回复号] [hold] [阈值]`")
+		return
+	}
+	var pid, cid int
+	var err error
+	ids := strings.SplitN(strings.TrimPrefix(args[0], "#"), "-", 2)
+	pid, err = strconv.Atoi(ids[0])
+	if err == nil && len(ids) == 2 {
+		cid, err = strconv.Atoi(ids[1])
+	}
+	if err != nil {
+		httpReturnInfo(c, "树洞号不合法")
+		return
+	}
+	hold := false
+	threshold := viper.GetInt("image_block_threshold")
+	for _, arg := range args[1:] {
+		if arg == "hold" {
+			hold = true
+		} else if threshold, err = strconv.Atoi(arg); err != nil || threshold < 0 || threshold > 64 {
+			httpReturnInfo(c, "阈值必须是0到64之间的整数")
+			return
+		}
+	}
+
+	var media []base.PostMedia
+	if cid == 0 {
+		err = base.GetDb(false).Where("post_id = ? and comment_id = 0", pid).Find(&media).Error
+	} else {
+		err = base.GetDb(false).Where("post_id = ? and comment_id = ?", pid, cid).Find(&media).Error
+	}
+	if err != nil {
+		base.HttpReturnWithCodeMinusOneAndAbort(c, logger.NewError(err, "GetMediaFailed", consts.DatabaseReadFailedString))
+		return
+	}
+	if len(media) == 0 {
+		httpReturnInfo(c, "没有找到图片")
+		return
+	}
+
+	blocks := make([]base.ImageBlock, 0, len(media))
+	for _, m := range media {
+		aHash, dHash := m.AHash, m.DHash
+		if aHash == 0 && dHash == 0 {
+			// 旧图片没有保存感知哈希，从本地文件计算
+			data, err2 := ioutil.ReadFile(localImagePath([]base.PostMedia{m}))
+			if err2 != nil {
+				httpReturnInfo(c, "读取图片失败："+m.FilePath)
+				return
+			}
+			var err3 *logger.InternalError
+			if aHash, dHash, err3 = utils.PerceptualHash(data); err3 != nil {
+				httpReturnInfo(c, "解析图片失败："+m.FilePath)
+				return
+			}
+		}
+		blocks = append(blocks, base.ImageBlock{
+			UserID:    user.ID,
+			PostID:    int32(pid),
+			CommentID: int32(cid),
+			AHash:     aHash,
+			DHash:     dHash,
+			Threshold: int32(threshold),
+			Hold:      hold,
+		})
+	}
+	if err = base.GetDb(false).Create(&blocks).Error; err != nil {
+		base.HttpReturnWithCodeMinusOneAndAbort(c, logger.NewError(err, "BlockImageFailed", consts.DatabaseWriteFailedString))
+		return
+	}
+	base.RefreshImageBlocks()
+	log.Printf("user %d blocked %d images in #%d-%d\n", user.ID, len(blocks), pid, cid)
+	httpReturnInfo(c, fmt.Sprintf("已屏蔽%d张图片，阈值%d，相似图片将%s", len(blocks), threshold,
+		utils.IfThenElse(hold, "在发送后等待审核", "被拒绝发送").(string)))
+}
+
 var shutdownCountDown int
 
 func adminShutdownCommand() gin.HandlerFunc {
@@ -0,0 +1,77 @@
+package contents
+
+import (
+	"errors"
+	"github.com/gin-gonic/gin"
+	"github.com/spf13/viper"
+	"gorm.io/gorm"
+	"net/http"
+	"strconv"
+	"treehollow-v3-backend/pkg/base"
+	"treehollow-v3-backend/pkg/consts"
+	"treehollow-v3-backend/pkg/logger"
+	"treehollow-v3-backend/pkg/utils"
+)
+
+// issueAuthorshipToken 为自己发布的树洞签发洞主证明
+func issueAuthorshipToken(c *gin.Context) {
+	user := c.MustGet("user").(base.User)
+	pid, err := strconv.Atoi(c.PostForm("pid"))
+	if err != nil {
+		base.HttpReturnWithCodeMinusOne(c, logger.NewSimpleError("IssueAuthorshipInvalidPid", "签发失败，pid不合法", logger.WARN))
+		return
+	}
+
+	var post base.Post
+	err2 := base.GetDb(false).First(&post, int32(pid)).Error
+	if err2 != nil {
+		if errors.Is(err2, gorm.ErrRecordNotFound) {
+			base.HttpReturnWithCodeMinusOne(c, logger.NewSimpleError("IssueAuthorshipNoPid", "签发失败，pid不存在", logger.WARN))
+		} else {
+			base.HttpReturnWithCodeMinusOne(c, logger.NewError(err2, "IssueAuthorshipGetPostFailed", consts.DatabaseReadFailedString))
+		}
+		return
+	}
+	if post.UserID != user.ID {
+		base.HttpReturnWithCodeMinusOne(c, logger.NewSimpleError("IssueAuthorshipNotOwner", "签发失败，只能为自己发布的树洞签发证明", logger.WARN))
+		return
+	}
+	key := viper.GetString("authorship_key")
+	if len(key) == 0 {
+		base.HttpReturnWithCodeMinusOne(c, logger.NewSimpleError("IssueAuthorshipNoKey", "签发失败，服务器未开启洞主证明", logger.WARN))
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"code":  0,
+		"pid":   post.ID,
+		"token": utils.AuthorshipToken(key, user.ID, post.ID),
+	})
+}
+
+// verifyAuthorshipToken 检查洞主证明是否有效，不返回洞主的任何信息
+func verifyAuthorshipToken(c *gin.Context) {
+	pid, err := strconv.Atoi(c.Query("pid"))
+	if err != nil {
+		base.HttpReturnWithCodeMinusOne(c, logger.NewSimpleError("VerifyAuthorshipInvalidPid", "验证失败，pid不合法", logger.WARN))
+		return
+	}
+	token := c.Query("token")
+
+	var post base.Post
+	err2 := base.GetDb(false).First(&post, int32(pid)).Error
+	if err2 != nil {
+		if errors.Is(err2, gorm.ErrRecordNotFound) {
+			base.HttpReturnWithCodeMinusOne(c, logger.NewSimpleError("VerifyAuthorshipNoPid", "验证失败，pid不存在", logger.WARN))
+		} else {
+			base.HttpReturnWithCodeMinusOne(c, logger.NewError(err2, "VerifyAuthorshipGetPostFailed", consts.DatabaseReadFailedString))
+		}
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"code":  0,
+		"pid":   post.ID,
+		"valid": utils.VerifyAuthorshipToken(viper.GetString("authorship_key"), post.UserID, post.ID, token),
+	})
+}
@@ -0,0 +1,240 @@
+package contents
+
+import (
+	"fmt"
+	"github.com/gin-gonic/gin"
+	"github.com/spf13/viper"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"treehollow-v3-backend/pkg/base"
+	"treehollow-v3-backend/pkg/consts"
+	"treehollow-v3-backend/pkg/logger"
+	"treehollow-v3-backend/pkg/utils"
+	"unicode/utf8"
+)
+
+// getBoardIDQuery 读取列表、搜索、热榜接口的board_id参数，不合法时为0，即不按版块筛选
+func getBoardIDQuery(c *gin.Context) int32 {
+	boardID, err := strconv.Atoi(c.Query("board_id"))
+	if err != nil || boardID < 0 {
+		return 0
+	}
+	return int32(boardID)
+}
+
+// getBoardParameter 读取发帖的board_id，检查版块是否存在、是否允许该类型和该用户发帖
+func getBoardParameter(c *gin.Context, user *base.User, typ string) (int32, *logger.InternalError) {
+	boardIDStr := c.PostForm("board_id")
+	if len(boardIDStr) == 0 || boardIDStr == "0" {
+		return 0, nil
+	}
+	boardID, err := strconv.Atoi(boardIDStr)
+	if err != nil {
+		return 0, logger.NewSimpleError("InvalidBoardID", "发送失败，版块不存在", logger.WARN)
+	}
+	board, ok, err := base.GetBoard(int32(boardID))
+	if err != nil {
+		return 0, logger.NewError(err, "GetBoardFailed", consts.DatabaseReadFailedString)
+	}
+	if !ok {
+		return 0, logger.NewSimpleError("BoardNotFound", "发送失败，版块不存在", logger.WARN)
+	}
+	if !board.AllowsType(typ) {
+		return 0, logger.NewSimpleError("BoardTypeNotAllowed", "发送失败，该版块不允许发布这种类型的树洞", logger.WARN)
+	}
+	if !board.AllowsRole(user) {
+		return 0, logger.NewSimpleError("BoardRoleNotAllowed", "发送失败，你不能在该版块发帖", logger.WARN)
+	}
+	return board.ID, nil
+}
+
+func boardToJson(board *base.Board, user *base.User) gin.H {
+	return gin.H{
+		"id":               board.ID,
+		"name":             board.Name,
+		"description":      board.Description,
+		"allowed_types":    base.SplitBoardList(board.AllowedTypes),
+		"can_post":         board.AllowsRole(user),
+		"show_in_timeline": board.ShowInTimeline,
+		"is_moderator":     base.IsBoardModerator(user.ID, board.ID),
+	}
+}
+
+// listBoards 列出所有版块
+func listBoards(c *gin.Context) {
+	user := c.MustGet("user").(base.User)
+	boards, err := base.GetBoards()
+	if err != nil {
+		base.HttpReturnWithCodeMinusOne(c, logger.NewError(err, "ListBoardsFailed", consts.DatabaseReadFailedString))
+		return
+	}
+	data := make([]gin.H, 0, len(boards))
+	for _, board := range boards {
+		data = append(data, boardToJson(&board, &user))
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"code": 0,
+		"data": data,
+	})
+}
+
+func adminBoardCommand() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if !viper.GetBool("allow_admin_commands") {
+			c.Next()
+			return
+		}
+		user := c.MustGet("user").(base.User)
+		keywords := c.Query("keywords")
+		if !base.CanManageBoards(&user) {
+			c.Next()
+			return
+		}
+		fields := strings.Fields(keywords)
+		if len(fields) == 0 {
+			c.Next()
+			return
+		}
+		switch fields[0] {
+		case "boards":
+			showBoards(c)
+		case "board_create":
+			createBoard(c, &user, fields[1:])
+		case "board_set":
+			setBoard(c, &user, fields[1:])
+		case "board_mod", "board_unmod":
+			setBoardModerator(c, &user, fields[0] == "board_mod", fields[1:])
+		default:
+			c.Next()
+		}
+	}
+}
+
+func showBoards(c *gin.Context) {
+	boards, err := base.GetBoards()
+	if err != nil {
+		base.HttpReturnWithCodeMinusOneAndAbort(c, logger.NewError(err, "ListBoardsFailed", consts.DatabaseReadFailedString))
+		return
+	}
+	var moderators []base.BoardModerator
+	if err = base.GetDb(false).Order("board_id asc, user_id asc").Find(&moderators).Error; err != nil {
+		base.HttpReturnWithCodeMinusOneAndAbort(c, logger.NewError(err, "ListBoardModeratorsFailed", consts.DatabaseReadFailedString))
+		return
+	}
+	moderatorsByBoard := make(map[int32][]string)
+	for _, m := range moderators {
+		moderatorsByBoard[m.BoardID] = append(moderatorsByBoard[m.BoardID], strconv.Itoa(int(m.UserID)))
+	}
+	info := fmt.Sprintf("共%d个版块\n", len(boards))
+	for _, board := range boards {
+		info += fmt.Sprintf("#%d %s: 类型[%s], 发帖角色[%s], %s, 版主[%s]\n", board.ID, board.Name,
+			board.AllowedTypes, board.PostRoles,
+			utils.IfThenElse(board.ShowInTimeline, "显示在主时间线", "不显示在主时间线").(string),
+			strings.Join(moderatorsByBoard[board.ID], ","))
+	}
+	httpReturnInfo(c, info)
+}
+
+func createBoard(c *gin.Context, user *base.User, args []string) {
+	if len(args) == 0 || utf8.RuneCountInString(args[0]) > 30 {
+		httpReturnInfo(c, "用法：`board_create 版块名(不超过30字) [简介]`")
+		return
+	}
+	board := base.Board{
+		Name:           args[0],
+		Description:    strings.Join(args[1:], " "),
+		ShowInTimeline: true,
+	}
+	if err := base.GetDb(false).Create(&board).Error; err != nil {
+		base.HttpReturnWithCodeMinusOneAndAbort(c, logger.NewError(err, "CreateBoardFailed", consts.DatabaseWriteFailedString))
+		return
+	}
+	base.RefreshBoards()
+	log.Printf("user %d created board #%d %s\n", user.ID, board.ID, board.Name)
+	httpReturnInfo(c, fmt.Sprintf("已创建版块#%d %s", board.ID, board.Name))
+}
+
+// setBoard 修改版块设置，types和roles为逗号分隔的列表，值为-时不做限制
+func setBoard(c *gin.Context, user *base.User, args []string) {
+	usage := "用法：`board_set 版块编号 name|desc|types|roles|timeline 值`，types和roles为逗号分隔的列表，" +
+		"值为-时不做限制，timeline的值为on或off"
+	if len(args) < 3 {
+		httpReturnInfo(c, usage)
+		return
+	}
+	boardID, err := strconv.Atoi(args[0])
+	if err != nil {
+		httpReturnInfo(c, "版块编号不合法")
+		return
+	}
+	value := strings.Join(args[2:], " ")
+	if value == "-" {
+		value = ""
+	}
+	var column string
+	var columnValue interface{} = value
+	switch args[1] {
+	case "name":
+		column = "name"
+		if len(value) == 0 || utf8.RuneCountInString(value) > 30 {
+			httpReturnInfo(c, "版块名需要在1~30字之间")
+			return
+		}
+	case "desc":
+		column = "description"
+	case "types":
+		column = "allowed_types"
+	case "roles":
+		column = "post_roles"
+	case "timeline":
+		column = "show_in_timeline"
+		columnValue = value == "on"
+	default:
+		httpReturnInfo(c, usage)
+		return
+	}
+	result := base.GetDb(false).Model(&base.Board{}).Where("id = ?", boardID).Update(column, columnValue)
+	if result.Error != nil {
+		base.HttpReturnWithCodeMinusOneAndAbort(c, logger.NewError(result.Error, "SetBoardFailed", consts.DatabaseWriteFailedString))
+		return
+	}
+	if result.RowsAffected == 0 {
+		httpReturnInfo(c, "没有找到这个版块，或设置没有变化")
+		return
+	}
+	base.RefreshBoards()
+	log.Printf("user %d set board #%d %s=%v\n", user.ID, boardID, column, columnValue)
+	httpReturnInfo(c, fmt.Sprintf("已修改版块#%d", boardID))
+}
+
+func setBoardModerator(c *gin.Context, user *base.User, add bool, args []string) {
+	if len(args) != 2 {
+		httpReturnInfo(c, "用法：`board_mod 版块编号 用户ID`或`board_unmod 版块编号 用户ID`")
+		return
+	}
+	boardID, err := strconv.Atoi(args[0])
+	uid, err2 := strconv.Atoi(args[1])
+	if err != nil || err2 != nil {
+		httpReturnInfo(c, "版块编号或用户ID不合法")
+		return
+	}
+	if _, ok, err3 := base.GetBoard(int32(boardID)); err3 != nil || !ok {
+		httpReturnInfo(c, "没有找到这个版块")
+		return
+	}
+	moderator := base.BoardModerator{BoardID: int32(boardID), UserID: int32(uid)}
+	if add {
+		err = base.GetDb(false).FirstOrCreate(&moderator, moderator).Error
+	} else {
+		err = base.GetDb(false).Where("board_id = ? and user_id = ?", boardID, uid).Delete(&base.BoardModerator{}).Error
+	}
+	if err != nil {
+		base.HttpReturnWithCodeMinusOneAndAbort(c, logger.NewError(err, "SetBoardModeratorFailed", consts.DatabaseWriteFailedString))
+		return
+	}
+	base.RefreshBoards()
+	log.Printf("user %d set board #%d moderator %d: %v\n", user.ID, boardID, uid, add)
+	httpReturnInfo(c, fmt.Sprintf("已%s用户%d在版块#%d的版主身份", utils.IfThenElse(add, "添加", "移除").(string), uid, boardID))
+}
@@ -0,0 +1,322 @@
+package contents
+
+import (
+	"errors"
+	"fmt"
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+	"treehollow-v3-backend/pkg/base"
+	"treehollow-v3-backend/pkg/consts"
+	"treehollow-v3-backend/pkg/logger"
+	"treehollow-v3-backend/pkg/utils"
+	"unicode/utf8"
+)
+
+// getCollectionID 读取收藏夹编号并检查是否属于该用户。参数为空时返回-1，即不按收藏夹筛选，0为未分类
+func getCollectionID(tx *gorm.DB, idStr string, user *base.User) (int32, *logger.InternalError) {
+	if len(idStr) == 0 {
+		return -1, nil
+	}
+	id, err := strconv.Atoi(idStr)
+	if err != nil || id < 0 {
+		return 0, logger.NewSimpleError("InvalidCollectionID", "收藏夹不存在", logger.WARN)
+	}
+	if id == 0 {
+		return 0, nil
+	}
+	var count int64
+	err = tx.Model(&base.AttentionCollection{}).Where("id = ? and user_id = ?", id, user.ID).Count(&count).Error
+	if err != nil {
+		return 0, logger.NewError(err, "GetCollectionFailed", consts.DatabaseReadFailedString)
+	}
+	if count == 0 {
+		return 0, logger.NewSimpleError("CollectionNotFound", "收藏夹不存在", logger.WARN)
+	}
+	return int32(id), nil
+}
+
+// attentionsInCollection 用户的关注记录，collectionID为-1时不按收藏夹筛选
+func attentionsInCollection(tx *gorm.DB, uid int32, collectionID int32) *gorm.DB {
+	tx = tx.Model(&base.Attention{}).Where("user_id = ?", uid)
+	if collectionID >= 0 {
+		tx = tx.Where("collection_id = ?", collectionID)
+	}
+	return tx
+}
+
+// getAttentionNotes 用户给关注的树洞写的备注，只包含有备注的树洞
+func getAttentionNotes(tx *gorm.DB, uid int32, pids []int32) (map[int32]string, error) {
+	notes := make(map[int32]string)
+	if len(pids) == 0 {
+		return notes, nil
+	}
+	var attentions []base.Attention
+	err := tx.Select("post_id", "note").Where("user_id = ? and post_id in ? and note != ''", uid, pids).
+		Find(&attentions).Error
+	for _, attention := range attentions {
+		notes[attention.PostID] = attention.Note
+	}
+	return notes, err
+}
+
+// unreadCommentsExpr 关注记录对应的树洞中别人发的、比最后看过的回复更新的回复
+const unreadCommentsExpr = "comments.post_id = attentions.post_id and comments.id > attentions.last_read_comment_id " +
+	"and comments.user_id != attentions.user_id and comments.deleted_at is null"
+
+// getUnreadCommentCounts 关注的树洞中的未读回复数，只包含有未读回复的树洞
+func getUnreadCommentCounts(tx *gorm.DB, uid int32, pids []int32) (map[int32]int64, error) {
+	unread := make(map[int32]int64)
+	if len(pids) == 0 {
+		return unread, nil
+	}
+	var counts []struct {
+		PostID int32
+		Count  int64
+	}
+	err := tx.Table("attentions").Select("attentions.post_id, count(*) as count").
+		Joins("join comments on "+unreadCommentsExpr).
+		Where("attentions.user_id = ? and attentions.post_id in ?", uid, pids).
+		Group("attentions.post_id").Scan(&counts).Error
+	for _, count := range counts {
+		unread[count.PostID] = count.Count
+	}
+	return unread, err
+}
+
+func checkCollectionName(name string) *logger.InternalError {
+	if len(name) == 0 || utf8.RuneCountInString(name) > consts.CollectionNameMaxLength {
+		return logger.NewSimpleError("InvalidCollectionName",
+			fmt.Sprintf("收藏夹名需要在1~%d字之间", consts.CollectionNameMaxLength), logger.WARN)
+	}
+	return nil
+}
+
+// listCollections 列出自己的收藏夹及其中的树洞数量，id为0的是未分类
+func listCollections(c *gin.Context) {
+	user := c.MustGet("user").(base.User)
+	db := base.GetDb(false)
+	var collections []base.AttentionCollection
+	if err := db.Where("user_id = ?", user.ID).Order("id asc").Find(&collections).Error; err != nil {
+		base.HttpReturnWithCodeMinusOne(c, logger.NewError(err, "ListCollectionsFailed", consts.DatabaseReadFailedString))
+		return
+	}
+	var counts []struct {
+		CollectionID int32
+		Count        int64
+	}
+	err := db.Model(&base.Attention{}).Select("collection_id, count(*) as count").Where("user_id = ?", user.ID).
+		Group("collection_id").Scan(&counts).Error
+	if err != nil {
+		base.HttpReturnWithCodeMinusOne(c, logger.NewError(err, "CountCollectionsFailed", consts.DatabaseReadFailedString))
+		return
+	}
+	countMap := make(map[int32]int64, len(counts))
+	for _, count := range counts {
+		countMap[count.CollectionID] = count.Count
+	}
+
+	data := make([]gin.H, 0, len(collections)+1)
+	data = append(data, gin.H{"id": 0, "name": "未分类", "count": countMap[0]})
+	for _, collection := range collections {
+		data = append(data, gin.H{
+			"id":    collection.ID,
+			"name":  collection.Name,
+			"count": countMap[collection.ID],
+		})
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"code": 0,
+		"data": data,
+	})
+}
+
+func createCollection(c *gin.Context) {
+	user := c.MustGet("user").(base.User)
+	name := strings.TrimSpace(c.PostForm("name"))
+	if err := checkCollectionName(name); err != nil {
+		base.HttpReturnWithCodeMinusOne(c, err)
+		return
+	}
+	db := base.GetDb(false)
+	var count int64
+	if err := db.Model(&base.AttentionCollection{}).Where("user_id = ?", user.ID).Count(&count).Error; err != nil {
+		base.HttpReturnWithCodeMinusOne(c, logger.NewError(err, "CountCollectionsFailed", consts.DatabaseReadFailedString))
+		return
+	}
+	if count >= consts.MaxCollectionsPerUser {
+		base.HttpReturnWithCodeMinusOne(c, logger.NewSimpleError("TooManyCollections",
+			fmt.Sprintf("创建失败，最多只能创建%d个收藏夹", consts.MaxCollectionsPerUser), logger.WARN))
+		return
+	}
+	collection := base.AttentionCollection{UserID: user.ID, Name: name}
+	if err := db.Create(&collection).Error; err != nil {
+		base.HttpReturnWithCodeMinusOne(c, logger.NewError(err, "CreateCollectionFailed", consts.DatabaseWriteFailedString))
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"code": 0,
+		"data": gin.H{"id": collection.ID, "name": collection.Name, "count": 0},
+	})
+}
+
+func renameCollection(c *gin.Context) {
+	user := c.MustGet("user").(base.User)
+	name := strings.TrimSpace(c.PostForm("name"))
+	if err := checkCollectionName(name); err != nil {
+		base.HttpReturnWithCodeMinusOne(c, err)
+		return
+	}
+	db := base.GetDb(false)
+	id, err := getCollectionID(db, c.PostForm("collection_id"), &user)
+	if err == nil && id <= 0 {
+		err = logger.NewSimpleError("InvalidCollectionID", "收藏夹不存在", logger.WARN)
+	}
+	if err != nil {
+		base.HttpReturnWithCodeMinusOne(c, err)
+		return
+	}
+	if err2 := db.Model(&base.AttentionCollection{}).Where("id = ?", id).Update("name", name).Error; err2 != nil {
+		base.HttpReturnWithCodeMinusOne(c, logger.NewError(err2, "RenameCollectionFailed", consts.DatabaseWriteFailedString))
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"code": 0,
+	})
+}
+
+// deleteCollection 删除收藏夹，其中的树洞移回未分类，不会取消关注
+func deleteCollection(c *gin.Context) {
+	user := c.MustGet("user").(base.User)
+	_ = base.GetDb(false).Transaction(func(tx *gorm.DB) error {
+		id, err := getCollectionID(tx, c.PostForm("collection_id"), &user)
+		if err == nil && id <= 0 {
+			err = logger.NewSimpleError("InvalidCollectionID", "收藏夹不存在", logger.WARN)
+		}
+		if err != nil {
+			base.HttpReturnWithCodeMinusOne(c, err)
+			return errors.New(err.InternalMsg)
+		}
+		err2 := tx.Model(&base.Attention{}).Where("user_id = ? and collection_id = ?", user.ID, id).
+			Update("collection_id", 0).Error
+		if err2 == nil {
+			err2 = tx.Delete(&base.AttentionCollection{}, id).Error
+		}
+		if err2 != nil {
+			base.HttpReturnWithCodeMinusOne(c, logger.NewError(err2, "DeleteCollectionFailed", consts.DatabaseWriteFailedString))
+			return err2
+		}
+		c.JSON(http.StatusOK, gin.H{
+			"code": 0,
+		})
+		return nil
+	})
+}
+
+// editAttentionEntry 修改关注的树洞所在的收藏夹或备注，只修改请求中带有的参数
+func editAttentionEntry(c *gin.Context) {
+	user := c.MustGet("user").(base.User)
+	pid, err := strconv.Atoi(c.PostForm("pid"))
+	if err != nil {
+		base.HttpReturnWithCodeMinusOne(c, logger.NewSimpleError("EditAttentionEntryInvalidPid", "修改失败，pid不合法", logger.WARN))
+		return
+	}
+	db := base.GetDb(false)
+	updates := make(map[string]interface{})
+	if idStr, ok := c.GetPostForm("collection_id"); ok {
+		id, err2 := getCollectionID(db, idStr, &user)
+		if err2 == nil && id < 0 {
+			err2 = logger.NewSimpleError("InvalidCollectionID", "收藏夹不存在", logger.WARN)
+		}
+		if err2 != nil {
+			base.HttpReturnWithCodeMinusOne(c, err2)
+			return
+		}
+		updates["collection_id"] = id
+	}
+	if note, ok := c.GetPostForm("note"); ok {
+		note = strings.TrimSpace(note)
+		if utf8.RuneCountInString(note) > consts.AttentionNoteMaxLength {
+			base.HttpReturnWithCodeMinusOne(c, logger.NewSimpleError("AttentionNoteTooLong",
+				fmt.Sprintf("修改失败，备注不能超过%d字", consts.AttentionNoteMaxLength), logger.WARN))
+			return
+		}
+		updates["note"] = note
+	}
+	if len(updates) == 0 {
+		base.HttpReturnWithCodeMinusOne(c, logger.NewSimpleError("EditAttentionEntryNoParams", "修改失败，没有要修改的内容", logger.WARN))
+		return
+	}
+
+	result := db.Model(&base.Attention{}).Where("user_id = ? and post_id = ?", user.ID, pid).UpdateColumns(updates)
+	if result.Error != nil {
+		base.HttpReturnWithCodeMinusOne(c, logger.NewError(result.Error, "EditAttentionEntryFailed", consts.DatabaseWriteFailedString))
+		return
+	}
+	var count int64
+	if result.RowsAffected == 0 {
+		_ = db.Model(&base.Attention{}).Where("user_id = ? and post_id = ?", user.ID, pid).Count(&count).Error
+		if count == 0 {
+			base.HttpReturnWithCodeMinusOne(c, logger.NewSimpleError("EditAttentionEntryNotFound", "修改失败，你没有关注这条树洞", logger.WARN))
+			return
+		}
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"code": 0,
+	})
+}
+
+// exportCollection 导出收藏夹中的树洞和备注，不带collection_id时导出全部关注
+func exportCollection(c *gin.Context) {
+	user := c.MustGet("user").(base.User)
+	db := base.GetDb(false)
+	collectionID, err := getCollectionID(db, c.Query("collection_id"), &user)
+	if err != nil {
+		base.HttpReturnWithCodeMinusOne(c, err)
+		return
+	}
+	var attentions []base.Attention
+	err2 := attentionsInCollection(db, user.ID, collectionID).Select("post_id", "collection_id", "note").
+		Order("post_id desc").Limit(consts.CollectionExportMaxPosts).Find(&attentions).Error
+	if err2 != nil {
+		base.HttpReturnWithCodeMinusOne(c, logger.NewError(err2, "ExportCollectionFailed", consts.DatabaseReadFailedString))
+		return
+	}
+	pids := make([]int32, 0, len(attentions))
+	for _, attention := range attentions {
+		pids = append(pids, attention.PostID)
+	}
+	var posts []base.Post
+	if err2 = db.Select("id", "text", "created_at").Where("id in ?", pids).Find(&posts).Error; err2 != nil {
+		base.HttpReturnWithCodeMinusOne(c, logger.NewError(err2, "ExportCollectionFailed", consts.DatabaseReadFailedString))
+		return
+	}
+	postMap := make(map[int32]base.Post, len(posts))
+	for _, post := range posts {
+		postMap[post.ID] = post
+	}
+
+	data := make([]gin.H, 0, len(attentions))
+	for _, attention := range attentions {
+		post, ok := postMap[attention.PostID]
+		// 与其他接口一样加上每个用户不同的时间偏移
+		offset := utils.CalcExtra(user.ForgetPwNonce, strconv.Itoa(int(attention.PostID)))
+		data = append(data, gin.H{
+			"pid":           attention.PostID,
+			"collection_id": attention.CollectionID,
+			"note":          attention.Note,
+			"text":          post.Text,
+			"timestamp":     utils.IfThenElse(ok, post.CreatedAt.Unix()-offset, nil),
+			"deleted":       !ok,
+		})
+	}
+	c.Header("Content-Disposition", fmt.Sprintf("attachment; filename=\"attentions-%s.json\"",
+		time.Now().Format("20060102")))
+	c.JSON(http.StatusOK, gin.H{
+		"code": 0,
+		"data": data,
+	})
+}
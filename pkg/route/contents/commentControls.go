@@ -0,0 +1,236 @@
+package contents
+
+import (
+	"errors"
+	"fmt"
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+	"treehollow-v3-backend/pkg/base"
+	"treehollow-v3-backend/pkg/consts"
+	"treehollow-v3-backend/pkg/logger"
+)
+
+func commentControlsToJson(post *base.Post) gin.H {
+	return gin.H{
+		"mode":              post.CommentMode,
+		"slow_mode_minutes": post.SlowModeMinutes,
+	}
+}
+
+// checkCommentControls 检查洞主设置的回复限制，洞主本人和管理员、版主不受限制
+func checkCommentControls(tx *gorm.DB, post *base.Post, user *base.User) *logger.InternalError {
+	if post.UserID == user.ID || base.CanOverrideCommentControls(user, post) {
+		return nil
+	}
+	var blocked int64
+	err := tx.Model(&base.PostCommenterBlock{}).Where("post_id = ? and user_id = ?", post.ID, user.ID).
+		Count(&blocked).Error
+	if err != nil {
+		return logger.NewError(err, "CheckCommenterBlockFailed", consts.DatabaseReadFailedString)
+	}
+	if blocked > 0 {
+		return logger.NewSimpleError("CommenterBlocked", "洞主已禁止你在这条树洞下回复", logger.INFO)
+	}
+	switch post.CommentMode {
+	case base.CommentModeLocked:
+		return logger.NewSimpleError("CommentLocked", "洞主已关闭回复", logger.INFO)
+	case base.CommentModeParticipants:
+		var count int64
+		err := tx.Model(&base.PostCommenter{}).Where("post_id = ? and user_id = ?", post.ID, user.ID).
+			Count(&count).Error
+		if err != nil {
+			return logger.NewError(err, "CheckCommenterFailed", consts.DatabaseReadFailedString)
+		}
+		if count == 0 {
+			return logger.NewSimpleError("CommentParticipantsOnly", "洞主设置了只有参与过讨论的人可以回复", logger.INFO)
+		}
+	}
+	if post.SlowModeMinutes > 0 {
+		var lastComments []base.Comment
+		err := tx.Unscoped().Select("created_at").Where("post_id = ? and user_id = ?", post.ID, user.ID).
+			Order("id desc").Limit(1).Find(&lastComments).Error
+		if err != nil {
+			return logger.NewError(err, "GetLastCommentFailed", consts.DatabaseReadFailedString)
+		}
+		if len(lastComments) > 0 &&
+			time.Since(lastComments[0].CreatedAt) < time.Duration(post.SlowModeMinutes)*time.Minute {
+			return logger.NewSimpleError("CommentSlowMode",
+				fmt.Sprintf("洞主开启了慢速模式，每%d分钟只能回复一次", post.SlowModeMinutes), logger.INFO)
+		}
+	}
+	return nil
+}
+
+// setCommentControls 洞主设置回复限制，管理员和版主也可以修改，修改会记录在日志中
+func setCommentControls(c *gin.Context) {
+	user := c.MustGet("user").(base.User)
+	pid, err := strconv.Atoi(c.PostForm("pid"))
+	if err != nil {
+		base.HttpReturnWithCodeMinusOne(c, logger.NewSimpleError("CommentControlsInvalidPid", "设置失败，pid不合法", logger.WARN))
+		return
+	}
+	mode := c.PostForm("mode")
+	if mode != base.CommentModeOpen && mode != base.CommentModeLocked && mode != base.CommentModeParticipants {
+		base.HttpReturnWithCodeMinusOne(c, logger.NewSimpleError("InvalidCommentMode", "设置失败，回复限制不合法", logger.WARN))
+		return
+	}
+	slowModeMinutes, err := strconv.Atoi(c.DefaultPostForm("slow_mode_minutes", "0"))
+	if err != nil || slowModeMinutes < 0 || slowModeMinutes > consts.MaxSlowModeMinutes {
+		base.HttpReturnWithCodeMinusOne(c, logger.NewSimpleError("InvalidSlowMode",
+			fmt.Sprintf("设置失败，慢速模式的间隔需要在0~%d分钟之间", consts.MaxSlowModeMinutes), logger.WARN))
+		return
+	}
+
+	_ = base.GetDb(false).Transaction(func(tx *gorm.DB) error {
+		var post base.Post
+		err2 := tx.Clauses(clause.Locking{Strength: "UPDATE"}).First(&post, int32(pid)).Error
+		if err2 != nil {
+			if errors.Is(err2, gorm.ErrRecordNotFound) {
+				base.HttpReturnWithCodeMinusOne(c, logger.NewSimpleError("CommentControlsNoPid", "设置失败，pid不存在", logger.WARN))
+			} else {
+				base.HttpReturnWithCodeMinusOne(c, logger.NewError(err2, "CommentControlsGetPostFailed", consts.DatabaseReadFailedString))
+			}
+			return err2
+		}
+
+		isOwner := post.UserID == user.ID
+		if !isOwner && !base.CanOverrideCommentControls(&user, &post) {
+			base.HttpReturnWithCodeMinusOne(c, logger.NewSimpleError("CommentControlsNoPermission", "设置失败，只有洞主可以设置回复限制", logger.WARN))
+			return errors.New("no permission")
+		}
+
+		post.CommentMode = mode
+		post.SlowModeMinutes = int32(slowModeMinutes)
+		err2 = tx.Model(&base.Post{}).Where("id = ?", post.ID).UpdateColumns(map[string]interface{}{
+			"comment_mode":      post.CommentMode,
+			"slow_mode_minutes": post.SlowModeMinutes,
+		}).Error
+		if err2 != nil {
+			base.HttpReturnWithCodeMinusOne(c, logger.NewError(err2, "SetCommentControlsFailed", consts.DatabaseWriteFailedString))
+			return err2
+		}
+
+		if !isOwner {
+			err2 = tx.Create(&base.Report{
+				UserID:         user.ID,
+				ReportedUserID: post.UserID,
+				PostID:         post.ID,
+				CommentID:      0,
+				Reason:         fmt.Sprintf("回复限制改为[%s]，慢速模式%d分钟", mode, slowModeMinutes),
+				Type:           base.AdminCommentCtrl,
+				IsComment:      false,
+				Weight:         0,
+			}).Error
+			if err2 != nil {
+				base.HttpReturnWithCodeMinusOne(c, logger.NewError(err2, "SaveCommentControlsReportFailed", consts.DatabaseWriteFailedString))
+				return err2
+			}
+		}
+
+		c.JSON(http.StatusOK, gin.H{
+			"code":             0,
+			"comment_controls": commentControlsToJson(&post),
+		})
+		return nil
+	})
+}
+
+// blockCommenter 洞主按化名禁止回复者在树洞下回复，unblock为1时解除。化名通过PostCommenter
+// 对应到用户，返回值中只有化名
+func blockCommenter(c *gin.Context) {
+	user := c.MustGet("user").(base.User)
+	pid, err := strconv.Atoi(c.PostForm("pid"))
+	if err != nil {
+		base.HttpReturnWithCodeMinusOne(c, logger.NewSimpleError("BlockCommenterInvalidPid", "操作失败，pid不合法", logger.WARN))
+		return
+	}
+	name := strings.TrimSpace(c.PostForm("name"))
+	unblock := c.PostForm("unblock") == "1"
+
+	_ = base.GetDb(false).Transaction(func(tx *gorm.DB) error {
+		var post base.Post
+		err2 := tx.Clauses(clause.Locking{Strength: "UPDATE"}).First(&post, int32(pid)).Error
+		if err2 != nil {
+			if errors.Is(err2, gorm.ErrRecordNotFound) {
+				base.HttpReturnWithCodeMinusOne(c, logger.NewSimpleError("BlockCommenterNoPid", "操作失败，pid不存在", logger.WARN))
+			} else {
+				base.HttpReturnWithCodeMinusOne(c, logger.NewError(err2, "BlockCommenterGetPostFailed", consts.DatabaseReadFailedString))
+			}
+			return err2
+		}
+		if post.UserID != user.ID {
+			base.HttpReturnWithCodeMinusOne(c, logger.NewSimpleError("BlockCommenterNotOwner", "操作失败，只有洞主可以禁止回复者", logger.WARN))
+			return errors.New("not owner")
+		}
+
+		if unblock {
+			err2 = tx.Where("post_id = ? and commenter_name = ?", post.ID, name).Delete(&base.PostCommenterBlock{}).Error
+			if err2 != nil {
+				base.HttpReturnWithCodeMinusOne(c, logger.NewError(err2, "UnblockCommenterFailed", consts.DatabaseWriteFailedString))
+				return err2
+			}
+		} else {
+			var commenter base.PostCommenter
+			err2 = tx.Where("post_id = ? and commenter_name = ?", post.ID, name).First(&commenter).Error
+			if err2 != nil {
+				if errors.Is(err2, gorm.ErrRecordNotFound) {
+					base.HttpReturnWithCodeMinusOne(c, logger.NewSimpleError("BlockCommenterNoName", "操作失败，这条树洞下没有这位回复者", logger.WARN))
+				} else {
+					base.HttpReturnWithCodeMinusOne(c, logger.NewError(err2, "BlockCommenterGetCommenterFailed", consts.DatabaseReadFailedString))
+				}
+				return err2
+			}
+
+			var count int64
+			err2 = tx.Model(&base.PostCommenterBlock{}).Where("post_id = ?", post.ID).Count(&count).Error
+			if err2 != nil {
+				base.HttpReturnWithCodeMinusOne(c, logger.NewError(err2, "CountCommenterBlocksFailed", consts.DatabaseReadFailedString))
+				return err2
+			}
+			if count >= consts.MaxBlockedCommentersPerPost {
+				base.HttpReturnWithCodeMinusOne(c, logger.NewSimpleError("TooManyCommenterBlocks",
+					fmt.Sprintf("操作失败，每条树洞最多禁止%d位回复者", consts.MaxBlockedCommentersPerPost), logger.WARN))
+				return errors.New("too many blocks")
+			}
+			err2 = tx.Model(&base.PostCommenterBlock{}).
+				Joins("join posts on posts.id = post_commenter_blocks.post_id").
+				Where("posts.user_id = ? and post_commenter_blocks.created_at > ?", user.ID, time.Now().Add(-24*time.Hour)).
+				Count(&count).Error
+			if err2 != nil {
+				base.HttpReturnWithCodeMinusOne(c, logger.NewError(err2, "CountCommenterBlocksFailed", consts.DatabaseReadFailedString))
+				return err2
+			}
+			if count >= consts.MaxCommenterBlocksIn24h {
+				base.HttpReturnWithCodeMinusOne(c, logger.NewSimpleError("TooManyCommenterBlocksIn24h",
+					"操作失败，你24小时内禁止的回复者太多了", logger.WARN))
+				return errors.New("too many blocks in 24h")
+			}
+
+			err2 = tx.Clauses(clause.OnConflict{DoNothing: true}).Create(&base.PostCommenterBlock{
+				PostID:        post.ID,
+				UserID:        commenter.UserID,
+				CommenterName: commenter.CommenterName,
+			}).Error
+			if err2 != nil {
+				base.HttpReturnWithCodeMinusOne(c, logger.NewError(err2, "BlockCommenterFailed", consts.DatabaseWriteFailedString))
+				return err2
+			}
+		}
+
+		names, err2 := base.GetBlockedCommenterNames(tx, post.ID)
+		if err2 != nil {
+			base.HttpReturnWithCodeMinusOne(c, logger.NewError(err2, "GetBlockedCommentersFailed", consts.DatabaseReadFailedString))
+			return err2
+		}
+		c.JSON(http.StatusOK, gin.H{
+			"code":               0,
+			"blocked_commenters": append([]string{}, names...),
+		})
+		return nil
+	})
+}
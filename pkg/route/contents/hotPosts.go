@@ -12,14 +12,14 @@ import (
 )
 
 
-// GetHotPostIDsFromCache 从 Redis ZSET 中获取热榜帖子ID
-func GetHotPostIDsFromCache(page, pageSize int) ([]int32, error) {
+// GetHotPostIDsFromCache 从 Redis ZSET 中获取热榜帖子ID，boardID为0时是总热榜
+func GetHotPostIDsFromCache(page, pageSize int, boardID int32) ([]int32, error) {
 	client := base.GetRedisClient()
 	start := int64((page - 1) * pageSize)
 	stop := start + int64(pageSize) - 1
 
 	// 从 ZSET 中按分值从高到低获取成员（帖子ID）
-	idStrs, err := client.ZRevRange(context.Background(), base.HotListKey, start, stop).Result()
+	idStrs, err := client.ZRevRange(context.Background(), base.HotListKeyOfBoard(boardID), start, stop).Result()
 	if err != nil {
 		return nil, err
 	}
@@ -56,14 +56,28 @@ func ColdStartHotList() {
 	pipe := client.Pipeline()
 	// 清理旧的热榜，以防万一
 	pipe.Del(context.Background(), base.HotListKey)
+	boards, err := base.GetBoards()
+	if err != nil {
+		log.Printf("Cold start failed: base.GetBoards() failed: err=%s\n", err)
+		return
+	}
+	for _, board := range boards {
+		pipe.Del(context.Background(), base.HotListKeyOfBoard(board.ID))
+	}
 
-	// 将查询到的帖子批量加入ZSET
+	// 将查询到的帖子批量加入ZSET，版块内的树洞同时加入版块热榜
 	for _, post := range hotPosts {
 		score := base.ComputeScore(&post)
-		pipe.ZAdd(context.Background(), base.HotListKey, &redis.Z{
+		member := &redis.Z{
 			Score:  score,
 			Member: strconv.Itoa(int(post.ID)),
-		})
+		}
+		if post.BoardID > 0 {
+			pipe.ZAdd(context.Background(), base.HotListKeyOfBoard(post.BoardID), member)
+		}
+		if base.ShowInTimeline(post.BoardID) {
+			pipe.ZAdd(context.Background(), base.HotListKey, member)
+		}
 	}
 
 	if _, err := pipe.Exec(context.Background()); err != nil {
@@ -0,0 +1,148 @@
+package contents
+
+import (
+	"fmt"
+	"github.com/gin-gonic/gin"
+	"net/http"
+	"strconv"
+	"strings"
+	"treehollow-v3-backend/pkg/base"
+	"treehollow-v3-backend/pkg/consts"
+	"treehollow-v3-backend/pkg/logger"
+	"treehollow-v3-backend/pkg/model"
+	"treehollow-v3-backend/pkg/utils"
+)
+
+// unreadMsgsCount 未读通知数，用于App角标
+func unreadMsgsCount(c *gin.Context) {
+	user := c.MustGet("user").(base.User)
+	counts, err := base.CountUnreadMsgs(base.GetDb(false), []int32{user.ID}, c.Query("push_only") == "1")
+	if err != nil {
+		base.HttpReturnWithCodeMinusOne(c, logger.NewError(err, "CountUnreadMsgsFailed", consts.DatabaseReadFailedString))
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"code":  0,
+		"count": counts[user.ID],
+	})
+}
+
+// groupedMsgs 按树洞分组的未读通知，如"#123 有5条新回复"，系统消息等不属于树洞的通知不在其中
+func groupedMsgs(c *gin.Context) {
+	user := c.MustGet("user").(base.User)
+	page := c.MustGet("page").(int)
+
+	var groups []struct {
+		PostID   int32
+		Count    int64
+		LatestID int32
+		Types    model.PushType
+	}
+	tx := base.GetDb(false).Model(&base.PushMessage{}).
+		Where("user_id = ? and is_read = ? and post_id > 0", user.ID, false)
+	if c.Query("push_only") == "1" {
+		tx = tx.Where("do_push = ?", true)
+	}
+	err := tx.Select("post_id, count(*) as count, max(id) as latest_id, bit_or(type) as types").
+		Group("post_id").Order("latest_id desc").
+		Limit(consts.MsgPageSize).Offset((page - 1) * consts.MsgPageSize).Scan(&groups).Error
+	if err != nil {
+		base.HttpReturnWithCodeMinusOne(c, logger.NewError(err, "GroupMsgsFailed", consts.DatabaseReadFailedString))
+		return
+	}
+	latestIDs := make([]int32, 0, len(groups))
+	for _, group := range groups {
+		latestIDs = append(latestIDs, group.LatestID)
+	}
+	var latestMsgs []base.PushMessage
+	if err = base.GetDb(false).Where("id in ?", latestIDs).Find(&latestMsgs).Error; err != nil {
+		base.HttpReturnWithCodeMinusOne(c, logger.NewError(err, "GroupMsgsFailed", consts.DatabaseReadFailedString))
+		return
+	}
+	latestMap := make(map[int32]base.PushMessage, len(latestMsgs))
+	for _, msg := range latestMsgs {
+		latestMap[msg.ID] = msg
+	}
+
+	data := make([]gin.H, 0, len(groups))
+	for _, group := range groups {
+		latest := latestMap[group.LatestID]
+		summary := fmt.Sprintf("#%d 有%d条新消息", group.PostID, group.Count)
+		if group.Types&^(model.ReplyMeComment|model.CommentInFavorited) == 0 {
+			summary = fmt.Sprintf("#%d 有%d条新回复", group.PostID, group.Count)
+		}
+		data = append(data, gin.H{
+			"pid":       group.PostID,
+			"count":     group.Count,
+			"type":      group.Types,
+			"summary":   summary,
+			"latest_id": group.LatestID,
+			"title":     latest.Title,
+			"body":      utils.TrimText(latest.Message, 100),
+			"timestamp": latest.UpdatedAt.Unix(),
+		})
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"code": 0,
+		"data": data,
+	})
+}
+
+// markMsgsRead 把ids中的通知标为已读，read为0时标为未读；带pid时把该树洞的通知全部标为已读
+func markMsgsRead(c *gin.Context) {
+	user := c.MustGet("user").(base.User)
+	tx := base.GetDb(false).Model(&base.PushMessage{}).Where("user_id = ?", user.ID)
+	if pidStr, ok := c.GetPostForm("pid"); ok {
+		pid, err := strconv.Atoi(pidStr)
+		if err != nil || pid <= 0 {
+			base.HttpReturnWithCodeMinusOne(c, logger.NewSimpleError("MarkMsgsReadInvalidPid", "操作失败，pid不合法", logger.WARN))
+			return
+		}
+		tx = tx.Where("post_id = ?", pid)
+	} else {
+		var ids []int32
+		for _, item := range strings.Split(c.PostForm("ids"), ",") {
+			id, err := strconv.Atoi(strings.TrimSpace(item))
+			if err != nil {
+				base.HttpReturnWithCodeMinusOne(c, logger.NewSimpleError("MarkMsgsReadInvalidIds", "操作失败，ids不合法", logger.WARN))
+				return
+			}
+			ids = append(ids, int32(id))
+		}
+		if len(ids) > consts.MaxMarkReadMsgs {
+			base.HttpReturnWithCodeMinusOne(c, logger.NewSimpleError("MarkMsgsReadTooManyIds", "操作失败，一次标记的通知太多", logger.WARN))
+			return
+		}
+		tx = tx.Where("id in ?", ids)
+	}
+	if err := tx.UpdateColumn("is_read", c.DefaultPostForm("read", "1") != "0").Error; err != nil {
+		base.HttpReturnWithCodeMinusOne(c, logger.NewError(err, "MarkMsgsReadFailed", consts.DatabaseWriteFailedString))
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"code": 0,
+	})
+}
+
+// markAllMsgsRead 把全部通知标为已读，带up_to时只标记编号不超过up_to的通知
+func markAllMsgsRead(c *gin.Context) {
+	user := c.MustGet("user").(base.User)
+	tx := base.GetDb(false).Model(&base.PushMessage{}).Where("user_id = ? and is_read = ?", user.ID, false)
+	if upToStr, ok := c.GetPostForm("up_to"); ok {
+		upTo, err := strconv.Atoi(upToStr)
+		if err != nil {
+			base.HttpReturnWithCodeMinusOne(c, logger.NewSimpleError("MarkAllMsgsReadInvalidId", "操作失败，up_to不合法", logger.WARN))
+			return
+		}
+		tx = tx.Where("id <= ?", upTo)
+	}
+	result := tx.UpdateColumn("is_read", true)
+	if result.Error != nil {
+		base.HttpReturnWithCodeMinusOne(c, logger.NewError(result.Error, "MarkAllMsgsReadFailed", consts.DatabaseWriteFailedString))
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"code":  0,
+		"count": result.RowsAffected,
+	})
+}
@@ -0,0 +1,82 @@
+package contents
+
+import (
+	"errors"
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+	"net/http"
+	"strconv"
+	"treehollow-v3-backend/pkg/base"
+	"treehollow-v3-backend/pkg/consts"
+	"treehollow-v3-backend/pkg/logger"
+	"treehollow-v3-backend/pkg/utils"
+)
+
+func getLikedPidsInPosts(tx *gorm.DB, user *base.User, posts []base.Post) (likedPids []int32, err error) {
+	pids := make([]int32, 0, len(posts))
+	for _, post := range posts {
+		pids = append(pids, post.ID)
+	}
+	err = tx.Model(&base.Like{}).Where("user_id = ? and post_id in ?", user.ID, pids).
+		Pluck("post_id", &likedPids).Error
+	return
+}
+
+// editLike 点赞或取消点赞，switch为1时点赞，为0时取消。点赞不会关注树洞
+func editLike(c *gin.Context) {
+	user := c.MustGet("user").(base.User)
+	canViewDelete := base.CanViewDeletedPost(&user)
+
+	pid, err := strconv.Atoi(c.PostForm("pid"))
+	if err != nil {
+		base.HttpReturnWithCodeMinusOne(c, logger.NewSimpleError("EditLikeInvalidPid", "点赞失败，pid不合法", logger.WARN))
+		return
+	}
+	s := c.PostForm("switch")
+	if s != "0" && s != "1" {
+		base.HttpReturnWithCodeMinusOne(c, logger.NewSimpleError("EditLikeInvalidSwitch", "点赞失败，参数switch不合法", logger.WARN))
+		return
+	}
+
+	_ = base.GetDb(false).Transaction(func(tx *gorm.DB) error {
+		var post base.Post
+		err2 := utils.UnscopedTx(tx, canViewDelete).Clauses(clause.Locking{Strength: "UPDATE"}).First(&post, int32(pid)).Error
+		if err2 != nil {
+			if errors.Is(err2, gorm.ErrRecordNotFound) {
+				base.HttpReturnWithCodeMinusOne(c, logger.NewSimpleError("EditLikeNoPid", "点赞失败，pid不存在", logger.WARN))
+			} else {
+				base.HttpReturnWithCodeMinusOne(c, logger.NewError(err2, "GetPostFailedEditLike", consts.DatabaseReadFailedString))
+			}
+			return err2
+		}
+
+		var liked int64
+		err2 = tx.Model(&base.Like{}).Where(&base.Like{PostID: post.ID, UserID: user.ID}).Count(&liked).Error
+		if err2 != nil {
+			base.HttpReturnWithCodeMinusOne(c, logger.NewError(err2, "GetIsLikedFailed", consts.DatabaseReadFailedString))
+			return err2
+		}
+		if liked == 0 && s == "1" {
+			err2 = tx.Create(&base.Like{UserID: user.ID, PostID: post.ID}).Error
+			post.LikeNum += 1
+		} else if liked == 1 && s == "0" {
+			err2 = tx.Delete(&base.Like{UserID: user.ID, PostID: post.ID}).Error
+			post.LikeNum -= 1
+		}
+		if err2 != nil {
+			base.HttpReturnWithCodeMinusOne(c, logger.NewError(err2, "SaveLikeFailed", consts.DatabaseWriteFailedString))
+			return err2
+		}
+
+		c.JSON(http.StatusOK, gin.H{
+			"code": 0,
+			"data": gin.H{
+				"pid":     post.ID,
+				"liked":   s == "1",
+				"likenum": post.LikeNum,
+			},
+		})
+		return nil
+	})
+}
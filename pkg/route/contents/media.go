@@ -0,0 +1,415 @@
+package contents
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/gin-gonic/gin"
+	"github.com/spf13/viper"
+	"gorm.io/gorm"
+	"io/ioutil"
+	"log"
+	"math"
+	"path/filepath"
+	"strings"
+	"time"
+	"treehollow-v3-backend/pkg/audio"
+	"treehollow-v3-backend/pkg/base"
+	"treehollow-v3-backend/pkg/bot"
+	"treehollow-v3-backend/pkg/consts"
+	"treehollow-v3-backend/pkg/imaging"
+	"treehollow-v3-backend/pkg/logger"
+	"treehollow-v3-backend/pkg/queue"
+	"treehollow-v3-backend/pkg/storage"
+	"treehollow-v3-backend/pkg/utils"
+)
+
+// uploadFile 待上传到CDN的文件，key为哈希路径
+type uploadFile struct {
+	key  string
+	data []byte
+}
+
+// saveImages 校验并保存上传的图片，返回待写入数据库的图片记录和需要上传的文件
+func saveImages(imgs []string, alts []string) ([]base.PostMedia, []uploadFile, *logger.InternalError) {
+	media := make([]base.PostMedia, 0, len(imgs))
+	files := make([]uploadFile, 0, len(imgs))
+	for i, img := range imgs {
+		sDec, err := utils.DecodeImageBase64(img)
+		if err != nil {
+			return nil, nil, err
+		}
+		alt := ""
+		if i < len(alts) {
+			alt = alts[i]
+		}
+		m, f, err := saveImage(sDec, i, alt)
+		if err != nil {
+			return nil, nil, err
+		}
+		media = append(media, m)
+		files = append(files, f...)
+	}
+	return media, files, nil
+}
+
+// saveImage 保存一张图片，按内容哈希去重。内容相同的图片已经存在时直接复用其文件和metadata，
+// 不再重复写入和上传到存储后端
+func saveImage(sDec []byte, position int, alt string) (base.PostMedia, []uploadFile, *logger.InternalError) {
+	data, suffix, metaStr, err := utils.PrepareImage(sDec)
+	if err != nil {
+		return base.PostMedia{}, nil, err
+	}
+	hash := utils.ContentHash(data)
+	blob, err2 := base.GetMediaBlob(base.GetDb(false), hash)
+	if err2 == nil {
+		return base.PostMedia{
+			Position:     int32(position),
+			FilePath:     blob.FilePath,
+			FileMetadata: blob.FileMetadata,
+			AltText:      strings.TrimSpace(alt),
+			Kind:         base.MediaKindImage,
+			Hash:         hash,
+			AHash:        blob.AHash,
+			DHash:        blob.DHash,
+		}, nil, nil
+	}
+	if !errors.Is(err2, gorm.ErrRecordNotFound) {
+		return base.PostMedia{}, nil, logger.NewError(err2, "GetMediaBlobFailed", consts.DatabaseReadFailedString)
+	}
+
+	aHash, dHash, err := utils.PerceptualHash(data)
+	if err != nil {
+		return base.PostMedia{}, nil, err
+	}
+	filePath := utils.NewMediaFilePath(suffix)
+	if err = utils.WriteImage(filePath, data); err != nil {
+		return base.PostMedia{}, nil, err
+	}
+	m, files := newMedia(filePath, data, metaStr, position, alt)
+	m.Hash = hash
+	m.AHash = aHash
+	m.DHash = dHash
+	return m, files, nil
+}
+
+// matchImageBlock 检查图片是否命中管理员的屏蔽列表，返回命中的屏蔽记录和图片
+func matchImageBlock(media []base.PostMedia) (*base.ImageBlock, *base.PostMedia, error) {
+	if len(media) == 0 {
+		return nil, nil, nil
+	}
+	blocks, err := base.GetCachedImageBlocks()
+	if err != nil {
+		return nil, nil, err
+	}
+	for i := range media {
+		if media[i].Kind == base.MediaKindAudio {
+			continue
+		}
+		for j := range blocks {
+			threshold := int(blocks[j].Threshold)
+			if imaging.HammingDistance(uint64(media[i].AHash), uint64(blocks[j].AHash)) <= threshold &&
+				imaging.HammingDistance(uint64(media[i].DHash), uint64(blocks[j].DHash)) <= threshold {
+				return &blocks[j], &media[i], nil
+			}
+		}
+	}
+	return nil, nil, nil
+}
+
+// reportBlockedImage 把命中屏蔽列表的图片发送到Telegram
+func reportBlockedImage(block *base.ImageBlock, m *base.PostMedia, target string, uid int32) {
+	if !viper.GetBool("enable_telegram") {
+		return
+	}
+	action := utils.IfThenElse(block.Hold, "held for review", "rejected").(string)
+	bot.TgMessageChannel <- bot.TgMessage{
+		Text: fmt.Sprintf("Image matches blocklist #%d (blocked from #%d-%d), %s %s\nUser ID: %d",
+			block.ID, block.PostID, block.CommentID, target, action, uid),
+		ImagePath: localImagePath([]base.PostMedia{*m}),
+	}
+}
+
+// holdByImageBlock 删除命中屏蔽列表的树洞或回复，等待管理员审核后撤销删除
+func holdByImageBlock(tx *gorm.DB, block *base.ImageBlock, uid int32, pid int32, cid int32) error {
+	report := base.Report{
+		UserID:         0,
+		ReportedUserID: uid,
+		PostID:         pid,
+		CommentID:      cid,
+		Reason:         fmt.Sprintf("图片命中屏蔽列表#%d", block.ID),
+		Type:           base.ImageHold,
+		IsComment:      cid != 0,
+		Weight:         0,
+	}
+	if err := tx.Create(&report).Error; err != nil {
+		return err
+	}
+	return base.DeleteByReport(tx, report)
+}
+
+// newMedia 为已保存的图片生成图片记录。
+// 未开启image_processing_async时同步生成缩略图等衍生文件
+func newMedia(filePath string, sDec []byte, metaStr string, position int, alt string) (base.PostMedia, []uploadFile) {
+	files := []uploadFile{{key: utils.GetHashedFilePath(filePath), data: sDec}}
+	if !viper.GetBool("image_processing_async") {
+		metaStr2, derived, err := utils.ProcessImage(filePath, metaStr)
+		if err != nil {
+			log.Printf("image process failed: %s\n", err.Err)
+		} else {
+			metaStr = metaStr2
+			files = append(files, readDerivedFiles(derived)...)
+		}
+	}
+	return base.PostMedia{
+		Position:     int32(position),
+		FilePath:     filePath,
+		FileMetadata: metaStr,
+		AltText:      strings.TrimSpace(alt),
+		Kind:         base.MediaKindImage,
+	}, files
+}
+
+// saveAudio 校验并按内容哈希保存语音，和图片一样写入images_path并上传到存储后端。
+// 服务端不解码音频，波形峰值由客户端生成
+func saveAudio(base64Audio string, peaks []int) (base.PostMedia, []uploadFile, *logger.InternalError) {
+	data, err := base64.StdEncoding.DecodeString(base64Audio)
+	if err != nil {
+		return base.PostMedia{}, nil, logger.NewSimpleError("InvalidAudioBase64", "语音数据不合法", logger.WARN)
+	}
+	info, err := audio.Probe(data)
+	if err != nil {
+		return base.PostMedia{}, nil, logger.NewSimpleError("InvalidAudioType", "语音格式不支持，请使用Opus、AAC或M4A格式", logger.WARN)
+	}
+	maxDuration := viper.GetFloat64("audio_max_duration_seconds")
+	if info.Duration <= 0 || info.Duration > maxDuration {
+		return base.PostMedia{}, nil, logger.NewSimpleError("TooLongAudio",
+			fmt.Sprintf("语音时长超出限制！最长%d秒。", int(maxDuration)), logger.WARN)
+	}
+	if peaks == nil {
+		peaks = []int{}
+	}
+
+	// 波形属于每条语音记录，复用已有文件时也使用本次上传的波形
+	metadataBytes, err := json.Marshal(map[string]interface{}{
+		"duration": math.Round(info.Duration*100) / 100,
+		"mime":     info.MimeType,
+		"peaks":    peaks,
+	})
+	if err != nil {
+		return base.PostMedia{}, nil, logger.NewError(err, "AudioMetadataMarshalFailed", "语音解析失败")
+	}
+
+	hash := utils.ContentHash(data)
+	blob, err := base.GetMediaBlob(base.GetDb(false), hash)
+	if err == nil {
+		return base.PostMedia{FilePath: blob.FilePath, FileMetadata: string(metadataBytes), Kind: base.MediaKindAudio, Hash: hash},
+			nil, nil
+	}
+	if !errors.Is(err, gorm.ErrRecordNotFound) {
+		return base.PostMedia{}, nil, logger.NewError(err, "GetMediaBlobFailed", consts.DatabaseReadFailedString)
+	}
+
+	filePath := utils.NewMediaFilePath(info.Suffix)
+	if err2 := utils.WriteImage(filePath, data); err2 != nil {
+		return base.PostMedia{}, nil, err2
+	}
+	return base.PostMedia{
+		FilePath:     filePath,
+		FileMetadata: string(metadataBytes),
+		Kind:         base.MediaKindAudio,
+		Hash:         hash,
+	}, []uploadFile{{key: utils.GetHashedFilePath(filePath), data: data}}, nil
+}
+
+// appendAudio 保存语音树洞或回复中的语音，追加到图片记录和需要上传的文件之后
+func appendAudio(media []base.PostMedia, files []uploadFile, base64Audio string, peaks []int) (
+	[]base.PostMedia, []uploadFile, *logger.InternalError) {
+	if len(base64Audio) == 0 {
+		return media, files, nil
+	}
+	m, f, err := saveAudio(base64Audio, peaks)
+	if err != nil {
+		return nil, nil, err
+	}
+	m.Position = int32(len(media))
+	return append(media, m), append(files, f...), nil
+}
+
+// appendUploadedMedia 把通过上传接口上传的图片追加到本次发送的图片之后，
+// alt_texts中对应位置不为空时覆盖上传时填写的图片描述
+func appendUploadedMedia(media []base.PostMedia, uid int32, mediaIDs []int32, alts []string) ([]base.PostMedia, *logger.InternalError) {
+	uploaded, err := base.GetUploadedMedia(base.GetDb(false), uid, mediaIDs)
+	if err != nil {
+		if errors.Is(err, base.ErrMediaUnavailable) {
+			return nil, logger.NewSimpleError("MediaUnavailable", "图片不存在或已被使用，请重新上传", logger.WARN)
+		}
+		return nil, logger.NewError(err, "GetUploadedMediaFailed", consts.DatabaseReadFailedString)
+	}
+	for _, m := range uploaded {
+		position := len(media)
+		m.Position = int32(position)
+		if position < len(alts) && len(strings.TrimSpace(alts[position])) > 0 {
+			m.AltText = strings.TrimSpace(alts[position])
+		}
+		media = append(media, m)
+	}
+	return media, nil
+}
+
+func readDerivedFiles(keys []string) []uploadFile {
+	files := make([]uploadFile, 0, len(keys))
+	for _, key := range keys {
+		data, err := ioutil.ReadFile(filepath.Join(viper.GetString("images_path"), key))
+		if err != nil {
+			log.Printf("read derived image failed: %s\n", err)
+			continue
+		}
+		files = append(files, uploadFile{key: key, data: data})
+	}
+	return files
+}
+
+// enqueueImageProcessing 开启image_processing_async时，把衍生图片的生成放入消息队列。
+// 复用已有文件且已经处理过的图片不会重复处理
+func enqueueImageProcessing(media []base.PostMedia) {
+	if !viper.GetBool("image_processing_async") {
+		return
+	}
+	for _, m := range media {
+		if m.Kind == base.MediaKindAudio {
+			continue
+		}
+		if _, ok := parseImageMetadata(m.FileMetadata)["blurhash"]; ok {
+			continue
+		}
+		if err := queue.Enqueue(queue.TaskProcessImage, queue.ProcessImagePayload{MediaID: m.ID}); err != nil {
+			log.Printf("Failed to enqueue image process task: %v", err)
+		}
+	}
+}
+
+// uploadImages 在后台把图片写入配置的存储后端，全部写入结束后向返回的channel写入
+func uploadImages(ctx context.Context, files []uploadFile) chan bool {
+	if len(files) == 0 {
+		return nil
+	}
+	uploadChan := make(chan bool, 1)
+	go func() {
+		s := storage.Default()
+		for _, f := range files {
+			err := s.Put(f.key, bytes.NewReader(f.data))
+			if err != nil {
+				log.Printf("storage upload failed, err=%s\n", err)
+			}
+		}
+
+		select {
+		default:
+			uploadChan <- true
+		case <-ctx.Done():
+			return
+		}
+	}()
+	return uploadChan
+}
+
+func waitForUpload(uploadChan chan bool) {
+	if uploadChan != nil {
+		//wait until upload complete.
+		select {
+		case <-uploadChan:
+		case <-time.After(15 * time.Second):
+			log.Println("image upload timeout")
+		}
+	}
+}
+
+// localImagePath 返回第一张图片在本地的路径，用于发送到Telegram
+func localImagePath(media []base.PostMedia) string {
+	images := imageMedia(media)
+	if len(images) == 0 {
+		return ""
+	}
+	return filepath.Join(viper.GetString("images_path"), utils.GetHashedFilePath(images[0].FilePath))
+}
+
+// imageMedia 过滤出图片，语音不会出现在images中
+func imageMedia(media []base.PostMedia) []base.PostMedia {
+	images := make([]base.PostMedia, 0, len(media))
+	for _, m := range media {
+		if m.Kind != base.MediaKindAudio {
+			images = append(images, m)
+		}
+	}
+	return images
+}
+
+func parseImageMetadata(metaStr string) map[string]interface{} {
+	imageMetadata := map[string]interface{}{}
+	err := json.Unmarshal([]byte(metaStr), &imageMetadata)
+	if err != nil {
+		log.Printf("bad image metadata %s: err=%s\n", metaStr, err)
+	}
+	return imageMetadata
+}
+
+func mediaToJson(media []base.PostMedia) []gin.H {
+	data := make([]gin.H, 0, len(media))
+	for _, m := range imageMedia(media) {
+		data = append(data, gin.H{
+			"url":            utils.GetHashedFilePath(m.FilePath),
+			"image_metadata": parseImageMetadata(m.FileMetadata),
+			"alt":            m.AltText,
+		})
+	}
+	return data
+}
+
+// firstImageJson 返回第一张图片的url和metadata，兼容只支持单张图片的旧版客户端
+func firstImageJson(media []base.PostMedia) (string, map[string]interface{}) {
+	images := imageMedia(media)
+	if len(images) == 0 {
+		return "", map[string]interface{}{}
+	}
+	return utils.GetHashedFilePath(images[0].FilePath), parseImageMetadata(images[0].FileMetadata)
+}
+
+// audioToJson 返回语音的url、时长和波形，没有语音时返回nil
+func audioToJson(media []base.PostMedia) gin.H {
+	for _, m := range media {
+		if m.Kind == base.MediaKindAudio {
+			metadata := parseImageMetadata(m.FileMetadata)
+			return gin.H{
+				"url":      utils.GetHashedFilePath(m.FilePath),
+				"duration": metadata["duration"],
+				"mime":     metadata["mime"],
+				"peaks":    metadata["peaks"],
+			}
+		}
+	}
+	return nil
+}
+
+func getMediaInPosts(tx *gorm.DB, posts []base.Post) (map[int32][]base.PostMedia, error) {
+	pids := make([]int32, 0, len(posts))
+	for _, post := range posts {
+		if post.Type == "image" || post.Type == "audio" {
+			pids = append(pids, post.ID)
+		}
+	}
+	return base.GetMediaByPosts(tx, pids)
+}
+
+func getMediaInComments(tx *gorm.DB, comments []base.Comment) (map[int32][]base.PostMedia, error) {
+	cids := make([]int32, 0, len(comments))
+	for _, comment := range comments {
+		if comment.Type == "image" || comment.Type == "audio" {
+			cids = append(cids, comment.ID)
+		}
+	}
+	return base.GetMediaByComments(tx, cids)
+}
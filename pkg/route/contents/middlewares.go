@@ -28,9 +28,16 @@ var commentLimiter2 *limiter.Limiter
 var detailPostLimiter *limiter.Limiter
 var randomListLimiter *limiter.Limiter
 var doAttentionLimiter *limiter.Limiter
+var likeLimiter *limiter.Limiter
 var searchLimiter *limiter.Limiter
 var searchShortTimeLimiter *limiter.Limiter
 var deleteBanLimiter *limiter.Limiter
+var uploadLimiter *limiter.Limiter
+var uploadChunkLimiter *limiter.Limiter
+var privateMsgLimiter *limiter.Limiter
+var reactionLimiter *limiter.Limiter
+var digestSettingsLimiter *limiter.Limiter
+var authorshipVerifyLimiter *limiter.Limiter
 
 func initLimiters() {
 	randomListLimiter = base.InitLimiter(limiter.Rate{
@@ -69,10 +76,39 @@ func initLimiters() {
 		Period: 24 * time.Hour,
 		Limit:  2000,
 	}, "doAttentionLimiter")
+	likeLimiter = base.InitLimiter(limiter.Rate{
+		Period: 24 * time.Hour,
+		Limit:  2000,
+	}, "likeLimiter")
 	deleteBanLimiter = base.InitLimiter(limiter.Rate{
 		Period: 24 * time.Hour,
 		Limit:  base.GetDeletePostRateLimitIn24h(base.SuperUserRole),
 	}, "deleteBanLimiter")
+	uploadLimiter = base.InitLimiter(limiter.Rate{
+		Period: 24 * time.Hour,
+		Limit:  500,
+	}, "uploadLimiter")
+	// 分片上传时一张图片会请求多次，单独限制，不占用uploadLimiter的次数
+	uploadChunkLimiter = base.InitLimiter(limiter.Rate{
+		Period: 24 * time.Hour,
+		Limit:  5000,
+	}, "uploadChunkLimiter")
+	privateMsgLimiter = base.InitLimiter(limiter.Rate{
+		Period: 24 * time.Hour,
+		Limit:  300,
+	}, "privateMsgLimiter")
+	reactionLimiter = base.InitLimiter(limiter.Rate{
+		Period: 24 * time.Hour,
+		Limit:  2000,
+	}, "reactionLimiter")
+	digestSettingsLimiter = base.InitLimiter(limiter.Rate{
+		Period: 24 * time.Hour,
+		Limit:  20,
+	}, "digestSettingsLimiter")
+	authorshipVerifyLimiter = base.InitLimiter(limiter.Rate{
+		Period: time.Hour,
+		Limit:  300,
+	}, "authorshipVerifyLimiter")
 	EmailLimiter = base.InitLimiter(limiter.Rate{
 		Period: 24 * time.Hour,
 		Limit:  viper.GetInt64("max_email_per_ip_per_day"),
@@ -100,6 +136,22 @@ func limiterMiddleware(limiter *limiter.Limiter, msg string, level logger.LogLev
 	}
 }
 
+// ipLimiterMiddleware 按IP限制不需要登录的接口
+func ipLimiterMiddleware(limiter *limiter.Limiter, msg string, level logger.LogLevel) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		context, err := limiter.Get(c, c.ClientIP())
+		if err != nil {
+			c.AbortWithStatus(500)
+			return
+		}
+		if context.Reached {
+			base.HttpReturnWithCodeMinusOneAndAbort(c, logger.NewSimpleError("limiter reached: "+msg, msg, level))
+			return
+		}
+		c.Next()
+	}
+}
+
 func sysLoadWarningMiddleware(threshold float64, msg string) gin.HandlerFunc {
 	return func(c *gin.Context) {
 		avg, err := load.Avg()
@@ -115,20 +167,26 @@ func sysLoadWarningMiddleware(threshold float64, msg string) gin.HandlerFunc {
 
 func textToFrontendJson(id int32, timestamp int64, text string) gin.H {
 	return gin.H{
-		"pid":            id,
-		"text":           text,
-		"type":           "text",
-		"timestamp":      timestamp,
-		"updated_at":     timestamp,
-		"reply":          0,
-		"likenum":        0,
-		"attention":      false,
-		"permissions":    []string{},
-		"url":            "",
-		"tag":            nil,
-		"deleted":        false,
-		"image_metadata": gin.H{},
-		"vote":           gin.H{},
+		"pid":              id,
+		"text":             text,
+		"type":             "text",
+		"timestamp":        timestamp,
+		"updated_at":       timestamp,
+		"reply":            0,
+		"likenum":          0,
+		"attention":        false,
+		"permissions":      []string{},
+		"url":              "",
+		"tag":              nil,
+		"deleted":          false,
+		"image_metadata":   gin.H{},
+		"images":           []gin.H{},
+		"audio":            nil,
+		"vote":             gin.H{},
+		"quote":            nil,
+		"official":         nil,
+		"board_id":         0,
+		"comment_controls": gin.H{"mode": base.CommentModeOpen, "slow_mode_minutes": 0},
 	}
 }
 
@@ -191,24 +249,94 @@ func checkReportParams(isPost bool) gin.HandlerFunc {
 	}
 }
 
-func checkParameterTextAndImage() gin.HandlerFunc {
+func checkParameterTextAndImage(maxImages int) gin.HandlerFunc {
 	return func(c *gin.Context) {
 		text := c.PostForm("text")
 		typ := c.PostForm("type")
-		img := c.PostForm("data")
+		var imgs []string
+		if img := c.PostForm("data"); len(img) > 0 {
+			imgs = append(imgs, img)
+		}
+		for _, img := range c.PostFormArray("data[]") {
+			if len(img) > 0 {
+				imgs = append(imgs, img)
+			}
+		}
+		alts := c.PostFormArray("alt_texts[]")
+		audio := c.PostForm("audio")
+		var peaks []int
+		if peaksStr := c.PostForm("audio_peaks"); len(peaksStr) > 0 {
+			if err := json.Unmarshal([]byte(peaksStr), &peaks); err != nil || len(peaks) > consts.AudioMaxPeaks {
+				base.HttpReturnWithCodeMinusOneAndAbort(c, logger.NewSimpleError("InvalidAudioPeaks", "语音波形数据不合法", logger.WARN))
+				return
+			}
+			for _, peak := range peaks {
+				if peak < 0 || peak > 100 {
+					base.HttpReturnWithCodeMinusOneAndAbort(c, logger.NewSimpleError("InvalidAudioPeaks", "语音波形数据不合法", logger.WARN))
+					return
+				}
+			}
+		}
+		var mediaIDs []int32
+		seen := make(map[int32]bool)
+		for _, idStr := range c.PostFormArray("media_ids[]") {
+			id, err := strconv.Atoi(idStr)
+			if err != nil || id <= 0 {
+				base.HttpReturnWithCodeMinusOneAndAbort(c, logger.NewSimpleError("InvalidMediaID", "发送失败，图片id不合法", logger.WARN))
+				return
+			}
+			if !seen[int32(id)] {
+				seen[int32(id)] = true
+				mediaIDs = append(mediaIDs, int32(id))
+			}
+		}
 		if utf8.RuneCountInString(text) > consts.PostMaxLength {
 			base.HttpReturnWithCodeMinusOneAndAbort(c, logger.NewSimpleError("TooLongText", "字数过长！字数限制为"+strconv.Itoa(consts.PostMaxLength)+"字。", logger.INFO))
 			return
 		} else if len(text) == 0 && typ == "text" {
 			base.HttpReturnWithCodeMinusOneAndAbort(c, logger.NewSimpleError("NoContent", "请输入内容", logger.INFO))
 			return
-		} else if typ != "text" && typ != "image" {
+		} else if typ != "text" && typ != "image" && typ != "audio" {
 			base.HttpReturnWithCodeMinusOneAndAbort(c, logger.NewSimpleError("UnknownType", "未知类型的树洞", logger.WARN))
 			return
-		} else if int(float64(len(img))/consts.Base64Rate) > consts.ImgMaxLength {
-			base.HttpReturnWithCodeMinusOneAndAbort(c, logger.NewSimpleError("TooLargeImage", "图片大小超出限制！", logger.WARN))
+		} else if typ == "image" && len(imgs)+len(mediaIDs) == 0 {
+			base.HttpReturnWithCodeMinusOneAndAbort(c, logger.NewSimpleError("NoImage", "请上传图片", logger.INFO))
+			return
+		} else if typ == "audio" && len(audio) == 0 {
+			base.HttpReturnWithCodeMinusOneAndAbort(c, logger.NewSimpleError("NoAudio", "请上传语音", logger.INFO))
 			return
+		} else if int(float64(len(audio))/consts.Base64Rate) > consts.AudioMaxLength {
+			base.HttpReturnWithCodeMinusOneAndAbort(c, logger.NewSimpleError("TooLargeAudio", "语音大小超出限制！", logger.WARN))
+			return
+		} else if len(imgs)+len(mediaIDs) > maxImages {
+			base.HttpReturnWithCodeMinusOneAndAbort(c, logger.NewSimpleError("TooManyImages", "图片数量超出限制！最多"+strconv.Itoa(maxImages)+"张图片。", logger.WARN))
+			return
+		}
+		for _, img := range imgs {
+			if int(float64(len(img))/consts.Base64Rate) > consts.ImgMaxLength {
+				base.HttpReturnWithCodeMinusOneAndAbort(c, logger.NewSimpleError("TooLargeImage", "图片大小超出限制！", logger.WARN))
+				return
+			}
+		}
+		for _, alt := range alts {
+			if utf8.RuneCountInString(alt) > consts.ImageAltMaxLength {
+				base.HttpReturnWithCodeMinusOneAndAbort(c, logger.NewSimpleError("TooLongAltText", "图片描述过长！字数限制为"+strconv.Itoa(consts.ImageAltMaxLength)+"字。", logger.INFO))
+				return
+			}
+		}
+		if typ == "text" || typ == "audio" {
+			imgs = nil
+			mediaIDs = nil
+		}
+		if typ != "audio" {
+			audio = ""
+			peaks = nil
 		}
+		c.Set("audio", audio)
+		c.Set("audio_peaks", peaks)
+		c.Set("images", imgs)
+		c.Set("media_ids", mediaIDs)
+		c.Set("alt_texts", alts)
 		c.Next()
 	}
 }
@@ -223,7 +351,7 @@ func searchHotPosts() gin.HandlerFunc {
 			user := c.MustGet("user").(base.User)
 
 			// 1. 从Redis ZSET获取热榜帖子ID
-			postIDs, err := GetHotPostIDsFromCache(page, pageSize)
+			postIDs, err := GetHotPostIDsFromCache(page, pageSize, getBoardIDQuery(c))
 			if err != nil {
 				log.Printf("Failed to get hot posts from cache: %v", err)
 				postIDs = []int32{}
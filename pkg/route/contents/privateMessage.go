@@ -0,0 +1,369 @@
+package contents
+
+import (
+	"errors"
+	"fmt"
+	"github.com/gin-gonic/gin"
+	"github.com/spf13/viper"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+	"treehollow-v3-backend/pkg/base"
+	"treehollow-v3-backend/pkg/bot"
+	"treehollow-v3-backend/pkg/consts"
+	"treehollow-v3-backend/pkg/logger"
+	"treehollow-v3-backend/pkg/queue"
+	"treehollow-v3-backend/pkg/utils"
+	"unicode/utf8"
+)
+
+// conversationToJson 私信会话只返回双方在树洞中的化名，不暴露用户ID
+func conversationToJson(conv *base.PrivateConversation, uid int32) gin.H {
+	isA := conv.UserA == uid
+	return gin.H{
+		"id":               conv.ID,
+		"pid":              conv.PostID,
+		"my_name":          utils.IfThenElse(isA, conv.NameA, conv.NameB),
+		"other_name":       utils.IfThenElse(isA, conv.NameB, conv.NameA),
+		"blocked_by_me":    utils.IfThenElse(isA, conv.BlockedByA, conv.BlockedByB),
+		"blocked_by_other": utils.IfThenElse(isA, conv.BlockedByB, conv.BlockedByA),
+		"timestamp":        conv.UpdatedAt.Unix(),
+	}
+}
+
+func privateMessageToJson(msg *base.PrivateMessage, conv *base.PrivateConversation, uid int32) gin.H {
+	return gin.H{
+		"id":          msg.ID,
+		"from_me":     msg.SenderID == uid,
+		"sender_name": utils.IfThenElse(msg.SenderID == conv.UserA, conv.NameA, conv.NameB),
+		"text":        msg.Text,
+		"timestamp":   msg.CreatedAt.Unix(),
+	}
+}
+
+// allowsPrivateMsg 用户是否开启了私信，没有推送设置的用户默认不接收私信
+func allowsPrivateMsg(tx *gorm.DB, uid int32) (bool, error) {
+	var settings []base.PushSettings
+	err := tx.Where("user_id = ?", uid).Limit(1).Find(&settings).Error
+	if err != nil {
+		return false, err
+	}
+	return len(settings) > 0 && settings[0].AllowPrivateMsg, nil
+}
+
+// getConversationOfUser 读取用户参与的会话，不是会话参与者时视为不存在
+func getConversationOfUser(tx *gorm.DB, id int, uid int32) (base.PrivateConversation, *logger.InternalError) {
+	var conv base.PrivateConversation
+	err := tx.Where("id = ? and (user_a = ? or user_b = ?)", id, uid, uid).First(&conv).Error
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return conv, logger.NewSimpleError("ConversationNotFound", "私信会话不存在", logger.WARN)
+		}
+		return conv, logger.NewError(err, "GetConversationFailed", consts.DatabaseReadFailedString)
+	}
+	return conv, nil
+}
+
+// findOrCreateConversation 按树洞中的化名找到私信对象。发送者必须是洞主或回复过这条树洞，
+// 接收者的身份只在服务端通过PostCommenter对应
+func findOrCreateConversation(tx *gorm.DB, pid int, to string, uid int32) (base.PrivateConversation, *logger.InternalError) {
+	var conv base.PrivateConversation
+	var post base.Post
+	err := tx.First(&post, int32(pid)).Error
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return conv, logger.NewSimpleError("PrivateMsgNoPid", "发送失败，pid不存在", logger.WARN)
+		}
+		return conv, logger.NewError(err, "PrivateMsgGetPostFailed", consts.DatabaseReadFailedString)
+	}
+	myName, ok, err := base.GetPseudonymInPost(tx, &post, uid)
+	if err != nil {
+		return conv, logger.NewError(err, "GetPseudonymFailed", consts.DatabaseReadFailedString)
+	}
+	if !ok {
+		return conv, logger.NewSimpleError("PrivateMsgNotParticipant", "发送失败，只有参与过这条树洞讨论的人可以发送私信", logger.WARN)
+	}
+	otherID, ok, err := base.GetUserByPseudonym(tx, &post, to)
+	if err != nil {
+		return conv, logger.NewError(err, "GetUserByPseudonymFailed", consts.DatabaseReadFailedString)
+	}
+	if !ok {
+		return conv, logger.NewSimpleError("PrivateMsgNoName", "发送失败，这条树洞下没有这位参与者", logger.WARN)
+	}
+	if otherID == uid {
+		return conv, logger.NewSimpleError("PrivateMsgToSelf", "发送失败，不能给自己发送私信", logger.WARN)
+	}
+
+	conv = base.PrivateConversation{UserA: uid, UserB: otherID, PostID: post.ID, NameA: myName, NameB: to}
+	if otherID < uid {
+		conv = base.PrivateConversation{UserA: otherID, UserB: uid, PostID: post.ID, NameA: to, NameB: myName}
+	}
+	err = tx.Clauses(clause.OnConflict{DoNothing: true}).Create(&conv).Error
+	if err != nil {
+		return conv, logger.NewError(err, "CreateConversationFailed", consts.DatabaseWriteFailedString)
+	}
+	err = tx.Where("user_a = ? and user_b = ? and post_id = ?", conv.UserA, conv.UserB, conv.PostID).
+		First(&conv).Error
+	if err != nil {
+		return conv, logger.NewError(err, "GetConversationFailed", consts.DatabaseReadFailedString)
+	}
+	return conv, nil
+}
+
+// sendPrivateMessage 给同一条树洞下的参与者发送私信，to为对方的化名，或用conversation_id回复已有会话。
+// 双方都需要在推送设置中开启私信
+func sendPrivateMessage(c *gin.Context) {
+	user := c.MustGet("user").(base.User)
+	text := strings.TrimSpace(c.PostForm("text"))
+	if len(text) == 0 || utf8.RuneCountInString(text) > consts.PrivateMessageMaxLength {
+		base.HttpReturnWithCodeMinusOne(c, logger.NewSimpleError("InvalidPrivateMsgText",
+			fmt.Sprintf("发送失败，私信需要在1~%d字之间", consts.PrivateMessageMaxLength), logger.WARN))
+		return
+	}
+
+	var sentMsgID int32
+	_ = base.GetDb(false).Transaction(func(tx *gorm.DB) error {
+		var conv base.PrivateConversation
+		var err *logger.InternalError
+		if convIDStr := c.PostForm("conversation_id"); len(convIDStr) > 0 {
+			convID, err2 := strconv.Atoi(convIDStr)
+			if err2 != nil {
+				base.HttpReturnWithCodeMinusOne(c, logger.NewSimpleError("InvalidConversationID", "发送失败，会话不存在", logger.WARN))
+				return err2
+			}
+			conv, err = getConversationOfUser(tx, convID, user.ID)
+		} else {
+			pid, err2 := strconv.Atoi(c.PostForm("pid"))
+			if err2 != nil {
+				base.HttpReturnWithCodeMinusOne(c, logger.NewSimpleError("PrivateMsgInvalidPid", "发送失败，pid不合法", logger.WARN))
+				return err2
+			}
+			conv, err = findOrCreateConversation(tx, pid, strings.TrimSpace(c.PostForm("to")), user.ID)
+		}
+		if err != nil {
+			base.HttpReturnWithCodeMinusOne(c, err)
+			return errors.New(err.InternalMsg)
+		}
+
+		isA := conv.UserA == user.ID
+		otherID := utils.IfThenElse(isA, conv.UserB, conv.UserA).(int32)
+		if utils.IfThenElse(isA, conv.BlockedByA, conv.BlockedByB).(bool) {
+			base.HttpReturnWithCodeMinusOne(c, logger.NewSimpleError("PrivateMsgBlockedByMe", "发送失败，你已屏蔽对方的私信", logger.INFO))
+			return errors.New("blocked by me")
+		}
+		if utils.IfThenElse(isA, conv.BlockedByB, conv.BlockedByA).(bool) {
+			base.HttpReturnWithCodeMinusOne(c, logger.NewSimpleError("PrivateMsgBlockedByOther", "发送失败，对方已屏蔽你的私信", logger.INFO))
+			return errors.New("blocked by other")
+		}
+		for _, uid := range []int32{user.ID, otherID} {
+			allowed, err2 := allowsPrivateMsg(tx, uid)
+			if err2 != nil {
+				base.HttpReturnWithCodeMinusOne(c, logger.NewError(err2, "GetPushSettingsFailed", consts.DatabaseReadFailedString))
+				return err2
+			}
+			if !allowed {
+				base.HttpReturnWithCodeMinusOne(c, logger.NewSimpleError("PrivateMsgNotAllowed",
+					utils.IfThenElse(uid == user.ID, "发送失败，请先在设置中开启私信", "发送失败，对方没有开启私信").(string), logger.INFO))
+				return errors.New("private message not allowed")
+			}
+		}
+
+		msg := base.PrivateMessage{ConversationID: conv.ID, SenderID: user.ID, Text: text}
+		if err2 := tx.Create(&msg).Error; err2 != nil {
+			base.HttpReturnWithCodeMinusOne(c, logger.NewError(err2, "SavePrivateMsgFailed", consts.DatabaseWriteFailedString))
+			return err2
+		}
+		conv.UpdatedAt = time.Now()
+		if err2 := tx.Model(&base.PrivateConversation{}).Where("id = ?", conv.ID).
+			Update("updated_at", conv.UpdatedAt).Error; err2 != nil {
+			base.HttpReturnWithCodeMinusOne(c, logger.NewError(err2, "UpdateConversationFailed", consts.DatabaseWriteFailedString))
+			return err2
+		}
+
+		c.JSON(http.StatusOK, gin.H{
+			"code":         0,
+			"conversation": conversationToJson(&conv, user.ID),
+			"message":      privateMessageToJson(&msg, &conv, user.ID),
+		})
+		sentMsgID = msg.ID
+		return nil
+	})
+
+	if sentMsgID > 0 {
+		if err := queue.Enqueue(queue.TaskPrivateMsgNotify, queue.PrivateMessageNotificationPayload{MessageID: sentMsgID}); err != nil {
+			log.Printf("Failed to enqueue private message notification task: %v", err)
+		}
+	}
+}
+
+// listConversations 列出自己的私信会话，最近有消息的在前
+func listConversations(c *gin.Context) {
+	user := c.MustGet("user").(base.User)
+	page := c.MustGet("page").(int)
+	offset := (page - 1) * consts.PrivateMessagePageSize
+	var convs []base.PrivateConversation
+	err := base.GetDb(false).Where("user_a = ? or user_b = ?", user.ID, user.ID).
+		Order("updated_at desc").Limit(consts.PrivateMessagePageSize).Offset(offset).Find(&convs).Error
+	if err != nil {
+		base.HttpReturnWithCodeMinusOne(c, logger.NewError(err, "ListConversationsFailed", consts.DatabaseReadFailedString))
+		return
+	}
+	data := make([]gin.H, 0, len(convs))
+	for _, conv := range convs {
+		data = append(data, conversationToJson(&conv, user.ID))
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"code": 0,
+		"data": data,
+	})
+}
+
+// listPrivateMessages 获取会话中的私信，新的在前
+func listPrivateMessages(c *gin.Context) {
+	user := c.MustGet("user").(base.User)
+	page := c.MustGet("page").(int)
+	convID, err := strconv.Atoi(c.Query("conversation_id"))
+	if err != nil {
+		base.HttpReturnWithCodeMinusOne(c, logger.NewSimpleError("InvalidConversationID", "获取失败，会话不存在", logger.WARN))
+		return
+	}
+	db := base.GetDb(false)
+	conv, err2 := getConversationOfUser(db, convID, user.ID)
+	if err2 != nil {
+		base.HttpReturnWithCodeMinusOne(c, err2)
+		return
+	}
+	var msgs []base.PrivateMessage
+	err = db.Where("conversation_id = ?", conv.ID).Order("id desc").
+		Limit(consts.PrivateMessagePageSize).Offset((page - 1) * consts.PrivateMessagePageSize).Find(&msgs).Error
+	if err != nil {
+		base.HttpReturnWithCodeMinusOne(c, logger.NewError(err, "ListPrivateMsgsFailed", consts.DatabaseReadFailedString))
+		return
+	}
+	data := make([]gin.H, 0, len(msgs))
+	for _, msg := range msgs {
+		data = append(data, privateMessageToJson(&msg, &conv, user.ID))
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"code":         0,
+		"conversation": conversationToJson(&conv, user.ID),
+		"data":         data,
+	})
+}
+
+// blockConversation 屏蔽会话中对方的私信，unblock为1时解除
+func blockConversation(c *gin.Context) {
+	user := c.MustGet("user").(base.User)
+	convID, err := strconv.Atoi(c.PostForm("conversation_id"))
+	if err != nil {
+		base.HttpReturnWithCodeMinusOne(c, logger.NewSimpleError("InvalidConversationID", "操作失败，会话不存在", logger.WARN))
+		return
+	}
+	db := base.GetDb(false)
+	conv, err2 := getConversationOfUser(db, convID, user.ID)
+	if err2 != nil {
+		base.HttpReturnWithCodeMinusOne(c, err2)
+		return
+	}
+	blocked := c.PostForm("unblock") != "1"
+	column := utils.IfThenElse(conv.UserA == user.ID, "blocked_by_a", "blocked_by_b").(string)
+	if err = db.Model(&base.PrivateConversation{}).Where("id = ?", conv.ID).
+		UpdateColumn(column, blocked).Error; err != nil {
+		base.HttpReturnWithCodeMinusOne(c, logger.NewError(err, "BlockConversationFailed", consts.DatabaseWriteFailedString))
+		return
+	}
+	if conv.UserA == user.ID {
+		conv.BlockedByA = blocked
+	} else {
+		conv.BlockedByB = blocked
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"code":         0,
+		"conversation": conversationToJson(&conv, user.ID),
+	})
+}
+
+// reportPrivateMessage 举报收到的私信，举报后同时屏蔽该会话。Report的CommentID记录私信编号
+func reportPrivateMessage(c *gin.Context) {
+	user := c.MustGet("user").(base.User)
+	msgID, err := strconv.Atoi(c.PostForm("message_id"))
+	if err != nil {
+		base.HttpReturnWithCodeMinusOne(c, logger.NewSimpleError("InvalidPrivateMsgID", "举报失败，私信不存在", logger.WARN))
+		return
+	}
+	reason := strings.TrimSpace(c.PostForm("reason"))
+	if utf8.RuneCountInString(reason) > consts.ReportMaxLength {
+		base.HttpReturnWithCodeMinusOne(c, logger.NewSimpleError("PrivateMsgReportTooLong", "举报失败，理由太长了", logger.WARN))
+		return
+	}
+
+	_ = base.GetDb(false).Transaction(func(tx *gorm.DB) error {
+		var msg base.PrivateMessage
+		err2 := tx.First(&msg, int32(msgID)).Error
+		if err2 != nil {
+			if errors.Is(err2, gorm.ErrRecordNotFound) {
+				base.HttpReturnWithCodeMinusOne(c, logger.NewSimpleError("PrivateMsgNotFound", "举报失败，私信不存在", logger.WARN))
+			} else {
+				base.HttpReturnWithCodeMinusOne(c, logger.NewError(err2, "GetPrivateMsgFailed", consts.DatabaseReadFailedString))
+			}
+			return err2
+		}
+		conv, err3 := getConversationOfUser(tx, int(msg.ConversationID), user.ID)
+		if err3 != nil {
+			base.HttpReturnWithCodeMinusOne(c, err3)
+			return errors.New(err3.InternalMsg)
+		}
+		if msg.SenderID == user.ID {
+			base.HttpReturnWithCodeMinusOne(c, logger.NewSimpleError("ReportOwnPrivateMsg", "举报失败，不能举报自己发送的私信", logger.WARN))
+			return errors.New("report own private message")
+		}
+
+		var reported int64
+		err2 = tx.Model(&base.Report{}).Where("user_id = ? and comment_id = ? and type = ?",
+			user.ID, msg.ID, base.PrivateMsgReport).Count(&reported).Error
+		if err2 != nil {
+			base.HttpReturnWithCodeMinusOne(c, logger.NewError(err2, "CountPrivateMsgReportsFailed", consts.DatabaseReadFailedString))
+			return err2
+		}
+		if reported > 0 {
+			base.HttpReturnWithCodeMinusOne(c, logger.NewSimpleError("AlreadyReported", "已经举报过了，举报失败。", logger.WARN))
+			return errors.New("already reported")
+		}
+
+		err2 = tx.Create(&base.Report{
+			UserID:         user.ID,
+			ReportedUserID: msg.SenderID,
+			PostID:         conv.PostID,
+			CommentID:      msg.ID,
+			Reason:         fmt.Sprintf("私信#%d: %s\n原文: %s", msg.ID, utils.TrimText(reason, 500), utils.TrimText(msg.Text, 200)),
+			Type:           base.PrivateMsgReport,
+			IsComment:      false,
+			Weight:         base.GetReportWeight(&user),
+		}).Error
+		if err2 != nil {
+			base.HttpReturnWithCodeMinusOne(c, logger.NewError(err2, "SavePrivateMsgReportFailed", consts.DatabaseWriteFailedString))
+			return err2
+		}
+		column := utils.IfThenElse(conv.UserA == user.ID, "blocked_by_a", "blocked_by_b").(string)
+		if err2 = tx.Model(&base.PrivateConversation{}).Where("id = ?", conv.ID).
+			UpdateColumn(column, true).Error; err2 != nil {
+			base.HttpReturnWithCodeMinusOne(c, logger.NewError(err2, "BlockConversationFailed", consts.DatabaseWriteFailedString))
+			return err2
+		}
+
+		if viper.GetBool("enable_telegram") {
+			bot.TgMessageChannel <- bot.TgMessage{
+				Text: fmt.Sprintf("New user report for private message #%d in post #%d\nReason: %s\n\nOriginal text:\n%s",
+					msg.ID, conv.PostID, reason, msg.Text),
+			}
+		}
+		c.JSON(http.StatusOK, gin.H{
+			"code": 0,
+		})
+		return nil
+	})
+}
@@ -3,13 +3,18 @@ package contents
 import (
 	"errors"
 	"github.com/gin-gonic/gin"
+	"github.com/spf13/viper"
 	"gorm.io/gorm"
 	"gorm.io/gorm/clause"
 	"net/http"
+	"strconv"
+	"strings"
 	"treehollow-v3-backend/pkg/base"
 	"treehollow-v3-backend/pkg/consts"
 	"treehollow-v3-backend/pkg/logger"
 	"treehollow-v3-backend/pkg/model"
+	"treehollow-v3-backend/pkg/queue"
+	"treehollow-v3-backend/pkg/utils"
 )
 
 func boolToInt(b bool) int {
@@ -28,9 +33,13 @@ func getPush(c *gin.Context) {
 			c.JSON(http.StatusOK, gin.H{
 				"code": 0,
 				"data": gin.H{
-					"push_system_msg": 1,
-					"push_reply_me":   1,
-					"push_favorited":  0,
+					"push_system_msg":   1,
+					"push_reply_me":     1,
+					"push_favorited":    0,
+					"push_referenced":   0,
+					"push_quoted":       1,
+					"push_private_msg":  1,
+					"allow_private_msg": 0,
 				},
 			})
 		} else {
@@ -41,35 +50,58 @@ func getPush(c *gin.Context) {
 	c.JSON(http.StatusOK, gin.H{
 		"code": 0,
 		"data": gin.H{
-			"push_system_msg": boolToInt((pushSettings.Settings & model.SystemMessage) > 0),
-			"push_reply_me":   boolToInt((pushSettings.Settings & model.ReplyMeComment) > 0),
-			"push_favorited":  boolToInt((pushSettings.Settings & model.CommentInFavorited) > 0),
+			"push_system_msg":   boolToInt((pushSettings.Settings & model.SystemMessage) > 0),
+			"push_reply_me":     boolToInt((pushSettings.Settings & model.ReplyMeComment) > 0),
+			"push_favorited":    boolToInt((pushSettings.Settings & model.CommentInFavorited) > 0),
+			"push_referenced":   boolToInt((pushSettings.Settings & model.PostReferenced) > 0),
+			"push_quoted":       boolToInt((pushSettings.Settings & model.PostQuoted) > 0),
+			"push_private_msg":  boolToInt((pushSettings.Settings & model.PrivateMessage) > 0),
+			"allow_private_msg": boolToInt(pushSettings.AllowPrivateMsg),
 		},
 	})
 }
 
+// pushSettingFields 推送设置表单中的字段和对应的通知类型
+var pushSettingFields = []struct {
+	field string
+	typ   model.PushType
+}{
+	{"push_system_msg", model.SystemMessage},
+	{"push_reply_me", model.ReplyMeComment},
+	{"push_favorited", model.CommentInFavorited},
+	{"push_referenced", model.PostReferenced},
+	{"push_quoted", model.PostQuoted},
+	{"push_private_msg", model.PrivateMessage},
+}
+
 func setPush(c *gin.Context) {
-	pushSystemMsg := c.PostForm("push_system_msg")
-	pushReplyMe := c.PostForm("push_reply_me")
-	pushFavorited := c.PostForm("push_favorited")
+	allowPrivateMsg, hasAllowPrivateMsg := c.GetPostForm("allow_private_msg")
 	user := c.MustGet("user").(base.User)
 
-	var pushSettings model.PushType
-	if pushSystemMsg == "1" {
-		pushSettings += model.SystemMessage
-	}
-	if pushReplyMe == "1" {
-		pushSettings += model.ReplyMeComment
+	old := base.PushSettings{Settings: base.DefaultPushTypes}
+	err := base.GetDb(false).First(&old, user.ID).Error
+	if err != nil && !errors.Is(err, gorm.ErrRecordNotFound) {
+		base.HttpReturnWithCodeMinusOne(c, logger.NewError(err, "GetPushSettingsFailed", consts.DatabaseReadFailedString))
+		return
 	}
-	if pushFavorited == "1" {
-		pushSettings += model.CommentInFavorited
+	pushSettings := old.Settings
+	for _, f := range pushSettingFields {
+		value, ok := c.GetPostForm(f.field)
+		pushSettings = base.MergePushSetting(pushSettings, f.typ, value, ok)
 	}
 
-	err := base.GetDb(false).Clauses(clause.OnConflict{
-		UpdateAll: true,
+	// 旧版客户端不会发送allow_private_msg，此时保留原来的私信设置
+	updateColumns := []string{"settings", "known_types"}
+	if hasAllowPrivateMsg {
+		updateColumns = append(updateColumns, "allow_private_msg")
+	}
+	err = base.GetDb(false).Clauses(clause.OnConflict{
+		DoUpdates: clause.AssignmentColumns(updateColumns),
 	}).Create(&base.PushSettings{
-		UserID:   user.ID,
-		Settings: pushSettings,
+		UserID:          user.ID,
+		Settings:        pushSettings,
+		KnownTypes:      base.KnownPushTypes(),
+		AllowPrivateMsg: allowPrivateMsg == "1",
 	}).Error
 	if err != nil {
 		base.HttpReturnWithCodeMinusOne(c, logger.NewError(err, "SavePushSettingsFailed", consts.DatabaseWriteFailedString))
@@ -79,3 +111,115 @@ func setPush(c *gin.Context) {
 		"code": 0,
 	})
 }
+
+// getDigest 获取邮件摘要设置，frequency为0时未开启，1为每天，7为每周。
+// notice需要在用户提交邮箱之前展示，让用户知情后再开启
+func getDigest(c *gin.Context) {
+	user := c.MustGet("user").(base.User)
+	var settings base.DigestSettings
+	err := base.GetDb(false).First(&settings, user.ID).Error
+	if err != nil && !errors.Is(err, gorm.ErrRecordNotFound) {
+		base.HttpReturnWithCodeMinusOne(c, logger.NewError(err, "GetDigestSettingsFailed", consts.DatabaseReadFailedString))
+		return
+	}
+	var nextSendAt interface{}
+	if settings.Frequency != base.NoDigest && settings.NextSendAt != nil {
+		nextSendAt = settings.NextSendAt.Unix()
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"code": 0,
+		"data": gin.H{
+			"frequency":    settings.Frequency,
+			"hour":         settings.Hour,
+			"weekday":      settings.Weekday,
+			"has_email":    len(settings.EmailEncrypted) > 0,
+			"next_send_at": nextSendAt,
+			"notice":       digestEmailNotice,
+		},
+	})
+}
+
+// digestEmailNotice 开启邮件摘要前提示用户，服务器需要保存可以解密的邮箱
+const digestEmailNotice = "为了发送邮件摘要，服务器会加密保存你的邮箱，但服务器可以解密，" +
+	"能同时拿到数据库和服务器配置的人可以据此把你的账号和邮箱对应起来。退订后会删除保存的邮箱。"
+
+// setDigest 修改邮件摘要设置。第一次开启时需要提供账号的邮箱和密码，以确认邮箱属于该账号
+func setDigest(c *gin.Context) {
+	user := c.MustGet("user").(base.User)
+	frequency, err := strconv.Atoi(c.PostForm("frequency"))
+	if err != nil || (base.DigestFrequency(frequency) != base.NoDigest &&
+		base.DigestFrequency(frequency) != base.DailyDigest && base.DigestFrequency(frequency) != base.WeeklyDigest) {
+		base.HttpReturnWithCodeMinusOne(c, logger.NewSimpleError("InvalidDigestFrequency", "设置失败，频率不合法", logger.WARN))
+		return
+	}
+	hour, err := strconv.Atoi(c.DefaultPostForm("hour", "8"))
+	if err != nil || hour < 0 || hour > 23 {
+		base.HttpReturnWithCodeMinusOne(c, logger.NewSimpleError("InvalidDigestHour", "设置失败，发送时间不合法", logger.WARN))
+		return
+	}
+	weekday, err := strconv.Atoi(c.DefaultPostForm("weekday", "1"))
+	if err != nil || weekday < 0 || weekday > 6 {
+		base.HttpReturnWithCodeMinusOne(c, logger.NewSimpleError("InvalidDigestWeekday", "设置失败，星期不合法", logger.WARN))
+		return
+	}
+	emailKey := viper.GetString("digest_email_key")
+	if frequency != 0 && (len(viper.GetString("digest_api_base_url")) == 0 || len(emailKey) == 0) {
+		base.HttpReturnWithCodeMinusOne(c, logger.NewSimpleError("DigestDisabled", "设置失败，服务器未开启邮件摘要", logger.WARN))
+		return
+	}
+
+	var saved *base.DigestSettings
+	_ = base.GetDb(false).Transaction(func(tx *gorm.DB) error {
+		var settings base.DigestSettings
+		err2 := tx.Clauses(clause.Locking{Strength: "UPDATE"}).First(&settings, user.ID).Error
+		if err2 != nil && !errors.Is(err2, gorm.ErrRecordNotFound) {
+			base.HttpReturnWithCodeMinusOne(c, logger.NewError(err2, "GetDigestSettingsFailed", consts.DatabaseReadFailedString))
+			return err2
+		}
+		if email, ok := c.GetPostForm("email"); ok {
+			email = strings.ToLower(email)
+			emailEncrypted, err3 := utils.AESEncrypt(email, c.PostForm("password_hashed"))
+			if err3 != nil || len(email) > 100 || emailEncrypted != user.EmailEncrypted {
+				base.HttpReturnWithCodeMinusOne(c, logger.NewSimpleError("DigestEmailMismatch", "设置失败，邮箱或密码错误", logger.WARN))
+				return errors.New("DigestEmailMismatch")
+			}
+			// 使用单独的密钥，不与用于哈希的salt共用
+			if settings.EmailEncrypted, err3 = utils.AESEncrypt(email, emailKey); err3 != nil {
+				base.HttpReturnWithCodeMinusOne(c, logger.NewError(err3, "AESEncryptFailed", consts.DatabaseEncryptFailedString))
+				return err3
+			}
+		}
+		if frequency != 0 && len(settings.EmailEncrypted) == 0 {
+			base.HttpReturnWithCodeMinusOne(c, logger.NewSimpleError("DigestNoEmail", "设置失败，开启邮件摘要需要验证邮箱", logger.WARN))
+			return errors.New("DigestNoEmail")
+		}
+		if len(settings.UnsubscribeToken) == 0 {
+			settings.UnsubscribeToken = utils.GenToken()
+		}
+		settings.UserID = user.ID
+		settings.Frequency = base.DigestFrequency(frequency)
+		settings.Hour = int8(hour)
+		settings.Weekday = int8(weekday)
+		err2 = tx.Clauses(clause.OnConflict{
+			UpdateAll: true,
+		}).Create(&settings).Error
+		if err2 != nil {
+			base.HttpReturnWithCodeMinusOne(c, logger.NewError(err2, "SaveDigestSettingsFailed", consts.DatabaseWriteFailedString))
+			return err2
+		}
+		saved = &settings
+		return nil
+	})
+	if saved == nil {
+		return
+	}
+
+	// 事务提交后再加入队列，发送时间未变时沿用已有的任务
+	if err = queue.ScheduleDigest(saved); err != nil {
+		base.HttpReturnWithCodeMinusOne(c, logger.NewError(err, "ScheduleDigestFailed", consts.DatabaseWriteFailedString))
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"code": 0,
+	})
+}
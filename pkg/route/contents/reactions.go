@@ -0,0 +1,176 @@
+package contents
+
+import (
+	"errors"
+	"github.com/gin-gonic/gin"
+	"github.com/spf13/viper"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+	"net/http"
+	"strconv"
+	"treehollow-v3-backend/pkg/base"
+	"treehollow-v3-backend/pkg/consts"
+	"treehollow-v3-backend/pkg/logger"
+	"treehollow-v3-backend/pkg/utils"
+)
+
+type reactionCount struct {
+	ID    int32
+	Emoji string
+	Count int64
+}
+
+// getReactions 获取每个对象各表态的数量和当前用户的表态，column为post_id时是树洞本身，为comment_id时是回复。
+// 查询回复时pids为回复所在的树洞，使查询可以使用以post_id开头的idx_reaction_target
+func getReactions(tx *gorm.DB, user *base.User, column string, ids []int32, pids []int32) (map[int32]gin.H, error) {
+	rtn := make(map[int32]gin.H, len(ids))
+	if len(ids) == 0 {
+		return rtn, nil
+	}
+	query := tx.Model(&base.Reaction{}).Where(column+" in ?", ids)
+	if column == "post_id" {
+		query = query.Where("comment_id = 0")
+	} else {
+		query = query.Where("post_id in ?", pids)
+	}
+	var counts []reactionCount
+	err := query.Select(column + " as id, emoji, count(*) as count").Group(column + ", emoji").Scan(&counts).Error
+	if err != nil {
+		return nil, err
+	}
+	var mine []reactionCount
+	query = tx.Model(&base.Reaction{}).Where(column+" in ? and user_id = ?", ids, user.ID)
+	if column == "post_id" {
+		query = query.Where("comment_id = 0")
+	} else {
+		query = query.Where("post_id in ?", pids)
+	}
+	if err = query.Select(column + " as id, emoji").Scan(&mine).Error; err != nil {
+		return nil, err
+	}
+
+	countsMap := make(map[int32]map[string]int64, len(ids))
+	for _, id := range ids {
+		countsMap[id] = make(map[string]int64)
+	}
+	for _, count := range counts {
+		countsMap[count.ID][count.Emoji] = count.Count
+	}
+	mineMap := make(map[int32]string, len(mine))
+	for _, m := range mine {
+		mineMap[m.ID] = m.Emoji
+	}
+	for _, id := range ids {
+		rtn[id] = gin.H{
+			"counts": countsMap[id],
+			"mine":   utils.IfThenElse(len(mineMap[id]) > 0, mineMap[id], nil),
+		}
+	}
+	return rtn, nil
+}
+
+func getReactionsInPosts(tx *gorm.DB, user *base.User, posts []base.Post) (map[int32]gin.H, error) {
+	pids := make([]int32, 0, len(posts))
+	for _, post := range posts {
+		pids = append(pids, post.ID)
+	}
+	return getReactions(tx, user, "post_id", pids, nil)
+}
+
+func getReactionsInComments(tx *gorm.DB, user *base.User, comments []base.Comment) (map[int32]gin.H, error) {
+	cids := make([]int32, 0, len(comments))
+	var pids []int32
+	seen := make(map[int32]bool)
+	for _, comment := range comments {
+		cids = append(cids, comment.ID)
+		if !seen[comment.PostID] {
+			seen[comment.PostID] = true
+			pids = append(pids, comment.PostID)
+		}
+	}
+	return getReactions(tx, user, "comment_id", cids, pids)
+}
+
+// editReaction 对树洞或回复表态，cid为0时是对树洞本身表态，reaction为空时取消表态
+func editReaction(c *gin.Context) {
+	user := c.MustGet("user").(base.User)
+	canViewDelete := base.CanViewDeletedPost(&user)
+	pid, err := strconv.Atoi(c.PostForm("pid"))
+	if err != nil {
+		base.HttpReturnWithCodeMinusOne(c, logger.NewSimpleError("ReactionInvalidPid", "表态失败，pid不合法", logger.WARN))
+		return
+	}
+	cid, err := strconv.Atoi(c.DefaultPostForm("cid", "0"))
+	if err != nil || cid < 0 {
+		base.HttpReturnWithCodeMinusOne(c, logger.NewSimpleError("ReactionInvalidCid", "表态失败，cid不合法", logger.WARN))
+		return
+	}
+	emoji := c.PostForm("reaction")
+	if _, ok := utils.ContainsString(viper.GetStringSlice("reactions"), emoji); len(emoji) > 0 && !ok {
+		base.HttpReturnWithCodeMinusOne(c, logger.NewSimpleError("InvalidReaction", "表态失败，不支持这个表态", logger.WARN))
+		return
+	}
+
+	_ = base.GetDb(false).Transaction(func(tx *gorm.DB) error {
+		var post base.Post
+		err2 := utils.UnscopedTx(tx, canViewDelete).Clauses(clause.Locking{Strength: "UPDATE"}).First(&post, int32(pid)).Error
+		if err2 != nil {
+			if errors.Is(err2, gorm.ErrRecordNotFound) {
+				base.HttpReturnWithCodeMinusOne(c, logger.NewSimpleError("ReactionNoPid", "表态失败，pid不存在", logger.WARN))
+			} else {
+				base.HttpReturnWithCodeMinusOne(c, logger.NewError(err2, "ReactionGetPostFailed", consts.DatabaseReadFailedString))
+			}
+			return err2
+		}
+		if cid > 0 {
+			var count int64
+			err2 = utils.UnscopedTx(tx, canViewDelete).Model(&base.Comment{}).
+				Where("id = ? and post_id = ?", cid, post.ID).Count(&count).Error
+			if err2 != nil {
+				base.HttpReturnWithCodeMinusOne(c, logger.NewError(err2, "ReactionGetCommentFailed", consts.DatabaseReadFailedString))
+				return err2
+			}
+			if count == 0 {
+				base.HttpReturnWithCodeMinusOne(c, logger.NewSimpleError("ReactionNoCid", "表态失败，回复不存在", logger.WARN))
+				return errors.New("comment not found")
+			}
+		}
+
+		var reactions []base.Reaction
+		err2 = tx.Where("user_id = ? and post_id = ? and comment_id = ?", user.ID, post.ID, cid).Find(&reactions).Error
+		if err2 != nil {
+			base.HttpReturnWithCodeMinusOne(c, logger.NewError(err2, "GetReactionFailed", consts.DatabaseReadFailedString))
+			return err2
+		}
+		switch {
+		case len(reactions) == 0 && len(emoji) > 0:
+			err2 = tx.Create(&base.Reaction{UserID: user.ID, PostID: post.ID, CommentID: int32(cid), Emoji: emoji}).Error
+		case len(reactions) > 0 && len(emoji) == 0:
+			// 带上comment_id条件，避免CommentID为0时删除该用户在这条树洞下的所有表态
+			err2 = tx.Where("user_id = ? and post_id = ? and comment_id = ?", user.ID, post.ID, cid).
+				Delete(&reactions[0]).Error
+		case len(reactions) > 0 && reactions[0].Emoji != emoji:
+			err2 = tx.Model(&base.Reaction{}).Where("user_id = ? and post_id = ? and comment_id = ?", user.ID, post.ID, cid).
+				Update("emoji", emoji).Error
+		}
+		if err2 != nil {
+			base.HttpReturnWithCodeMinusOne(c, logger.NewError(err2, "SaveReactionFailed", consts.DatabaseWriteFailedString))
+			return err2
+		}
+
+		column, targetID := "post_id", post.ID
+		if cid > 0 {
+			column, targetID = "comment_id", int32(cid)
+		}
+		reactionsMap, err2 := getReactions(tx, &user, column, []int32{targetID}, []int32{post.ID})
+		if err2 != nil {
+			base.HttpReturnWithCodeMinusOne(c, logger.NewError(err2, "GetReactionsFailed", consts.DatabaseReadFailedString))
+			return err2
+		}
+		c.JSON(http.StatusOK, gin.H{
+			"code":      0,
+			"reactions": reactionsMap[targetID],
+		})
+		return nil
+	})
+}
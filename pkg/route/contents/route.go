@@ -7,10 +7,12 @@ import (
 	"os"
 	"path/filepath"
 	"strings"
+	"treehollow-v3-backend/pkg/base"
 	"treehollow-v3-backend/pkg/bot"
 	"treehollow-v3-backend/pkg/consts"
 	"treehollow-v3-backend/pkg/logger"
 	"treehollow-v3-backend/pkg/logger/ginLogger"
+	"treehollow-v3-backend/pkg/media"
 	"treehollow-v3-backend/pkg/queue"
 	"treehollow-v3-backend/pkg/route/auth"
 	"treehollow-v3-backend/pkg/utils"
@@ -25,6 +27,10 @@ func InitService(){
 	bot.InitBot()
 	initLimiters()
 	queue.StartWorkers()
+	initUploadGCCron()
+	media.InitGCCron()
+	base.InitReadStateFlushCron()
+	queue.InitDigestCron()
 	shutdownCountDown = 2
 	c := cron.New()
 	_, _ = c.AddFunc("0 0 * * *", func() {
@@ -56,6 +62,19 @@ func ServicesApiListenHttp() {
 	r.GET("/v3/config/get_push",
 		auth.DisallowUnregisteredUsers(),
 		getPush)
+	r.POST("/v3/config/set_settings",
+		auth.DisallowUnregisteredUsers(),
+		setSettings)
+	r.GET("/v3/config/get_settings",
+		auth.DisallowUnregisteredUsers(),
+		getSettings)
+	r.POST("/v3/config/set_digest",
+		auth.DisallowUnregisteredUsers(),
+		limiterMiddleware(digestSettingsLimiter, "你今天修改邮件摘要设置太多次了，明天再来吧", logger.WARN),
+		setDigest)
+	r.GET("/v3/config/get_digest",
+		auth.DisallowUnregisteredUsers(),
+		getDigest)
 	r.GET("/v3/contents/system_msg",
 		auth.DisallowUnregisteredUsers(),
 		systemMsg)
@@ -79,6 +98,8 @@ func ServicesApiListenHttp() {
 		adminStatisticsCommand(),
 		adminSysMsgsCommand(),
 		adminShutdownCommand(),
+		adminMediaGCCommand(),
+		adminImageBlockCommand(), adminBoardCommand(),
 		sysLoadWarningMiddleware(viper.GetFloat64("sys_load_threshold"), "目前树洞服务器负载较高，搜索功能已被暂时停用"),
 		searchPost)
 	r.GET("/v3/contents/post/attentions",
@@ -89,18 +110,45 @@ func ServicesApiListenHttp() {
 		auth.DisallowUnregisteredUsers(),
 		checkParameterPage(consts.MaxPage),
 		myMsgs)
+	r.GET("/v3/contents/my_msgs/grouped",
+		auth.DisallowUnregisteredUsers(),
+		checkParameterPage(consts.MaxPage),
+		groupedMsgs)
+	r.GET("/v3/contents/my_msgs/unread_count",
+		auth.DisallowUnregisteredUsers(),
+		unreadMsgsCount)
 	r.GET("/v3/contents/search/attentions",
 		auth.DisallowUnregisteredUsers(),
 		checkParameterPage(consts.SearchMaxPage),
 		limiterMiddleware(searchShortTimeLimiter, "请不要短时间内连续搜索树洞", logger.INFO),
 		limiterMiddleware(searchLimiter, "你今天搜索太多树洞了，明天再来吧", logger.WARN),
 		searchAttentionPost)
+	r.POST("/v3/upload/image",
+		auth.DisallowUnregisteredUsers(),
+		limiterMiddleware(uploadLimiter, "你今天上传太多图片了，明天再来吧", logger.WARN),
+		disallowBannedPostUsers(),
+		uploadImage)
+	r.POST("/v3/upload/create",
+		auth.DisallowUnregisteredUsers(),
+		limiterMiddleware(uploadLimiter, "你今天上传太多图片了，明天再来吧", logger.WARN),
+		disallowBannedPostUsers(),
+		createUpload)
+	r.POST("/v3/upload/chunk",
+		auth.DisallowUnregisteredUsers(),
+		limiterMiddleware(uploadChunkLimiter, "你今天上传太多图片了，明天再来吧", logger.WARN),
+		disallowBannedPostUsers(),
+		uploadChunk)
+	r.GET("/v3/upload/status",
+		auth.DisallowUnregisteredUsers(),
+		limiterMiddleware(uploadChunkLimiter, "你今天上传太多图片了，明天再来吧", logger.WARN),
+		disallowBannedPostUsers(),
+		uploadStatus)
 	r.POST("/v3/send/post",
 		auth.DisallowUnregisteredUsers(),
 		limiterMiddleware(postLimiter, "请不要短时间内连续发送树洞", logger.INFO),
 		limiterMiddleware(postLimiter2, "你24小时内已经发送太多树洞了", logger.WARN),
 		disallowBannedPostUsers(),
-		checkParameterTextAndImage(),
+		checkParameterTextAndImage(consts.PostMaxImages),
 		checkParameterVoteOptions,
 		sendPost)
 	r.POST("/v3/send/vote",
@@ -112,8 +160,83 @@ func ServicesApiListenHttp() {
 		limiterMiddleware(commentLimiter, "请不要短时间内连续发送树洞回复", logger.INFO),
 		limiterMiddleware(commentLimiter2, "你24小时内已经发送太多树洞回复了", logger.WARN),
 		disallowBannedPostUsers(),
-		checkParameterTextAndImage(),
+		checkParameterTextAndImage(consts.CommentMaxImages),
 		sendComment)
+	r.GET("/v3/contents/boards",
+		listBoards)
+	r.POST("/v3/series/add",
+		auth.DisallowUnregisteredUsers(),
+		disallowBannedPostUsers(),
+		addToSeries)
+	r.POST("/v3/series/remove",
+		auth.DisallowUnregisteredUsers(),
+		removeFromSeries)
+	r.GET("/v3/series/list",
+		auth.DisallowUnregisteredUsers(),
+		listSeries)
+	r.POST("/v3/authorship/issue",
+		auth.DisallowUnregisteredUsers(),
+		issueAuthorshipToken)
+	r.GET("/v3/authorship/verify",
+		ipLimiterMiddleware(authorshipVerifyLimiter, "验证次数过多，请稍后再试", logger.WARN),
+		verifyAuthorshipToken)
+	r.POST("/v3/edit/comment_controls",
+		auth.DisallowUnregisteredUsers(),
+		setCommentControls)
+	r.POST("/v3/edit/block_commenter",
+		auth.DisallowUnregisteredUsers(),
+		blockCommenter)
+	r.POST("/v3/pm/send",
+		auth.DisallowUnregisteredUsers(),
+		limiterMiddleware(privateMsgLimiter, "你今天发送太多私信了，明天再来吧", logger.WARN),
+		disallowBannedPostUsers(),
+		sendPrivateMessage)
+	r.GET("/v3/pm/conversations",
+		auth.DisallowUnregisteredUsers(),
+		checkParameterPage(consts.MaxPage),
+		listConversations)
+	r.GET("/v3/pm/messages",
+		auth.DisallowUnregisteredUsers(),
+		checkParameterPage(consts.MaxPage),
+		listPrivateMessages)
+	r.POST("/v3/pm/block",
+		auth.DisallowUnregisteredUsers(),
+		blockConversation)
+	r.POST("/v3/pm/report",
+		auth.DisallowUnregisteredUsers(),
+		reportPrivateMessage)
+	r.POST("/v3/edit/reaction",
+		auth.DisallowUnregisteredUsers(),
+		limiterMiddleware(reactionLimiter, "你今天表态太多了，明天再来吧", logger.WARN),
+		editReaction)
+	r.POST("/v3/edit/like",
+		auth.DisallowUnregisteredUsers(),
+		limiterMiddleware(likeLimiter, "你今天点赞太多树洞了，明天再来吧", logger.WARN),
+		editLike)
+	r.GET("/v3/attention/collections",
+		auth.DisallowUnregisteredUsers(),
+		listCollections)
+	r.POST("/v3/attention/collection/create",
+		auth.DisallowUnregisteredUsers(),
+		createCollection)
+	r.POST("/v3/attention/collection/rename",
+		auth.DisallowUnregisteredUsers(),
+		renameCollection)
+	r.POST("/v3/attention/collection/delete",
+		auth.DisallowUnregisteredUsers(),
+		deleteCollection)
+	r.GET("/v3/attention/collection/export",
+		auth.DisallowUnregisteredUsers(),
+		exportCollection)
+	r.POST("/v3/attention/edit",
+		auth.DisallowUnregisteredUsers(),
+		editAttentionEntry)
+	r.POST("/v3/edit/msgs/read",
+		auth.DisallowUnregisteredUsers(),
+		markMsgsRead)
+	r.POST("/v3/edit/msgs/read_all",
+		auth.DisallowUnregisteredUsers(),
+		markAllMsgsRead)
 	r.POST("/v3/edit/attention",
 		auth.DisallowUnregisteredUsers(),
 		limiterMiddleware(doAttentionLimiter, "你今天关注太多树洞了，明天再来吧", logger.WARN),
@@ -153,6 +276,19 @@ func AddContentsControllers(r *gin.Engine) (*gin.Engine) {
 	r.GET("/v3/config/get_push",
 		auth.DisallowUnregisteredUsers(),
 		getPush)
+	r.POST("/v3/config/set_settings",
+		auth.DisallowUnregisteredUsers(),
+		setSettings)
+	r.GET("/v3/config/get_settings",
+		auth.DisallowUnregisteredUsers(),
+		getSettings)
+	r.POST("/v3/config/set_digest",
+		auth.DisallowUnregisteredUsers(),
+		limiterMiddleware(digestSettingsLimiter, "你今天修改邮件摘要设置太多次了，明天再来吧", logger.WARN),
+		setDigest)
+	r.GET("/v3/config/get_digest",
+		auth.DisallowUnregisteredUsers(),
+		getDigest)
 	r.GET("/v3/contents/system_msg",
 		auth.DisallowUnregisteredUsers(),
 		systemMsg)
@@ -176,6 +312,8 @@ func AddContentsControllers(r *gin.Engine) (*gin.Engine) {
 		adminStatisticsCommand(),
 		adminSysMsgsCommand(),
 		adminShutdownCommand(),
+		adminMediaGCCommand(),
+		adminImageBlockCommand(), adminBoardCommand(),
 		sysLoadWarningMiddleware(viper.GetFloat64("sys_load_threshold"), "目前树洞服务器负载较高，搜索功能已被暂时停用"),
 		searchPost)
 	r.GET("/v3/contents/post/attentions",
@@ -186,18 +324,45 @@ func AddContentsControllers(r *gin.Engine) (*gin.Engine) {
 		auth.DisallowUnregisteredUsers(),
 		checkParameterPage(consts.MaxPage),
 		myMsgs)
+	r.GET("/v3/contents/my_msgs/grouped",
+		auth.DisallowUnregisteredUsers(),
+		checkParameterPage(consts.MaxPage),
+		groupedMsgs)
+	r.GET("/v3/contents/my_msgs/unread_count",
+		auth.DisallowUnregisteredUsers(),
+		unreadMsgsCount)
 	r.GET("/v3/contents/search/attentions",
 		auth.DisallowUnregisteredUsers(),
 		checkParameterPage(consts.SearchMaxPage),
 		limiterMiddleware(searchShortTimeLimiter, "请不要短时间内连续搜索树洞", logger.INFO),
 		limiterMiddleware(searchLimiter, "你今天搜索太多树洞了，明天再来吧", logger.WARN),
 		searchAttentionPost)
+	r.POST("/v3/upload/image",
+		auth.DisallowUnregisteredUsers(),
+		limiterMiddleware(uploadLimiter, "你今天上传太多图片了，明天再来吧", logger.WARN),
+		disallowBannedPostUsers(),
+		uploadImage)
+	r.POST("/v3/upload/create",
+		auth.DisallowUnregisteredUsers(),
+		limiterMiddleware(uploadLimiter, "你今天上传太多图片了，明天再来吧", logger.WARN),
+		disallowBannedPostUsers(),
+		createUpload)
+	r.POST("/v3/upload/chunk",
+		auth.DisallowUnregisteredUsers(),
+		limiterMiddleware(uploadChunkLimiter, "你今天上传太多图片了，明天再来吧", logger.WARN),
+		disallowBannedPostUsers(),
+		uploadChunk)
+	r.GET("/v3/upload/status",
+		auth.DisallowUnregisteredUsers(),
+		limiterMiddleware(uploadChunkLimiter, "你今天上传太多图片了，明天再来吧", logger.WARN),
+		disallowBannedPostUsers(),
+		uploadStatus)
 	r.POST("/v3/send/post",
 		auth.DisallowUnregisteredUsers(),
 		limiterMiddleware(postLimiter, "请不要短时间内连续发送树洞", logger.INFO),
 		limiterMiddleware(postLimiter2, "你24小时内已经发送太多树洞了", logger.WARN),
 		disallowBannedPostUsers(),
-		checkParameterTextAndImage(),
+		checkParameterTextAndImage(consts.PostMaxImages),
 		checkParameterVoteOptions,
 		sendPost)
 	r.POST("/v3/send/vote",
@@ -209,8 +374,83 @@ func AddContentsControllers(r *gin.Engine) (*gin.Engine) {
 		// limiterMiddleware(commentLimiter, "请不要短时间内连续发送树洞回复", logger.INFO),
 		// limiterMiddleware(commentLimiter2, "你24小时内已经发送太多树洞回复了", logger.WARN),
 		disallowBannedPostUsers(),
-		checkParameterTextAndImage(),
+		checkParameterTextAndImage(consts.CommentMaxImages),
 		sendComment)
+	r.GET("/v3/contents/boards",
+		listBoards)
+	r.POST("/v3/series/add",
+		auth.DisallowUnregisteredUsers(),
+		disallowBannedPostUsers(),
+		addToSeries)
+	r.POST("/v3/series/remove",
+		auth.DisallowUnregisteredUsers(),
+		removeFromSeries)
+	r.GET("/v3/series/list",
+		auth.DisallowUnregisteredUsers(),
+		listSeries)
+	r.POST("/v3/authorship/issue",
+		auth.DisallowUnregisteredUsers(),
+		issueAuthorshipToken)
+	r.GET("/v3/authorship/verify",
+		ipLimiterMiddleware(authorshipVerifyLimiter, "验证次数过多，请稍后再试", logger.WARN),
+		verifyAuthorshipToken)
+	r.POST("/v3/edit/comment_controls",
+		auth.DisallowUnregisteredUsers(),
+		setCommentControls)
+	r.POST("/v3/edit/block_commenter",
+		auth.DisallowUnregisteredUsers(),
+		blockCommenter)
+	r.POST("/v3/pm/send",
+		auth.DisallowUnregisteredUsers(),
+		limiterMiddleware(privateMsgLimiter, "你今天发送太多私信了，明天再来吧", logger.WARN),
+		disallowBannedPostUsers(),
+		sendPrivateMessage)
+	r.GET("/v3/pm/conversations",
+		auth.DisallowUnregisteredUsers(),
+		checkParameterPage(consts.MaxPage),
+		listConversations)
+	r.GET("/v3/pm/messages",
+		auth.DisallowUnregisteredUsers(),
+		checkParameterPage(consts.MaxPage),
+		listPrivateMessages)
+	r.POST("/v3/pm/block",
+		auth.DisallowUnregisteredUsers(),
+		blockConversation)
+	r.POST("/v3/pm/report",
+		auth.DisallowUnregisteredUsers(),
+		reportPrivateMessage)
+	r.POST("/v3/edit/reaction",
+		auth.DisallowUnregisteredUsers(),
+		limiterMiddleware(reactionLimiter, "你今天表态太多了，明天再来吧", logger.WARN),
+		editReaction)
+	r.POST("/v3/edit/like",
+		auth.DisallowUnregisteredUsers(),
+		limiterMiddleware(likeLimiter, "你今天点赞太多树洞了，明天再来吧", logger.WARN),
+		editLike)
+	r.GET("/v3/attention/collections",
+		auth.DisallowUnregisteredUsers(),
+		listCollections)
+	r.POST("/v3/attention/collection/create",
+		auth.DisallowUnregisteredUsers(),
+		createCollection)
+	r.POST("/v3/attention/collection/rename",
+		auth.DisallowUnregisteredUsers(),
+		renameCollection)
+	r.POST("/v3/attention/collection/delete",
+		auth.DisallowUnregisteredUsers(),
+		deleteCollection)
+	r.GET("/v3/attention/collection/export",
+		auth.DisallowUnregisteredUsers(),
+		exportCollection)
+	r.POST("/v3/attention/edit",
+		auth.DisallowUnregisteredUsers(),
+		editAttentionEntry)
+	r.POST("/v3/edit/msgs/read",
+		auth.DisallowUnregisteredUsers(),
+		markMsgsRead)
+	r.POST("/v3/edit/msgs/read_all",
+		auth.DisallowUnregisteredUsers(),
+		markAllMsgsRead)
 	r.POST("/v3/edit/attention",
 		auth.DisallowUnregisteredUsers(),
 		limiterMiddleware(doAttentionLimiter, "你今天关注太多树洞了，明天再来吧", logger.WARN),
@@ -22,13 +22,9 @@ import (
 	"unicode/utf8"
 )
 
-func commentToJson(comment *base.Comment, user *base.User) gin.H {
+func commentToJson(comment *base.Comment, user *base.User, boardID int32, media []base.PostMedia, reactions gin.H) gin.H {
 	offset := utils.CalcExtra(user.ForgetPwNonce, strconv.Itoa(int(comment.ID)))
-	imageMetadata := map[string]int{}
-	err2 := json.Unmarshal([]byte(comment.FileMetadata), &imageMetadata)
-	if err2 != nil {
-		log.Printf("bad image metadata in cid=%d: err=%s\n", comment.ID, err2)
-	}
+	url, imageMetadata := firstImageJson(media)
 	return gin.H{
 		"cid":            comment.ID,
 		"pid":            comment.PostID,
@@ -36,26 +32,42 @@ func commentToJson(comment *base.Comment, user *base.User) gin.H {
 		"type":           comment.Type,
 		"timestamp":      comment.CreatedAt.Unix() - offset,
 		"reply_to":       comment.ReplyTo,
-		"url":            utils.GetHashedFilePath(comment.FilePath),
+		"url":            url,
 		"tag":            utils.IfThenElse(len(comment.Tag) != 0, comment.Tag, nil),
-		"permissions":    base.GetPermissionsByComment(user, comment),
+		"permissions":    base.GetPermissionsByComment(user, comment, boardID),
 		"deleted":        comment.DeletedAt.Valid,
 		"name":           comment.Name,
-		"is_dz":          comment.Name == consts.DzName,
+		"is_dz":          comment.Name == consts.DzName && len(comment.OfficialName) == 0,
+		"official":       utils.IfThenElse(len(comment.OfficialName) == 0, nil, gin.H{"name": comment.OfficialName}),
+		"mentions":       comment.MentionSpans(),
+		"reactions":      reactions,
 		"image_metadata": imageMetadata,
+		"images":         mediaToJson(media),
+		"audio":          audioToJson(media),
 	}
 }
 
-func commentsToJson(comments []base.Comment, user *base.User) []gin.H {
+// commentsToJson comments都属于同一条树洞，boardID为该树洞所在的版块
+func commentsToJson(comments []base.Comment, user *base.User, boardID int32, media map[int32][]base.PostMedia,
+	reactions map[int32]gin.H) []gin.H {
 	data := make([]gin.H, 0, len(comments))
 	for _, comment := range comments {
 		if !comment.DeletedAt.Valid || base.CanViewDeletedPost(user) {
-			data = append(data, commentToJson(&comment, user))
+			data = append(data, commentToJson(&comment, user, boardID, media[comment.ID], reactions[comment.ID]))
 		}
 	}
 	return data
 }
 
+// boardIDsOfPosts 树洞id到所在版块的映射，用于计算回复的权限
+func boardIDsOfPosts(posts []base.Post) map[int32]int32 {
+	boardIDs := make(map[int32]int32, len(posts))
+	for _, post := range posts {
+		boardIDs[post.ID] = post.BoardID
+	}
+	return boardIDs
+}
+
 func detailPost(c *gin.Context) {
 	pid, err := strconv.Atoi(c.Query("pid"))
 	if err != nil {
@@ -80,19 +92,71 @@ func detailPost(c *gin.Context) {
 	offset := utils.CalcExtra(user.ForgetPwNonce, strconv.Itoa(int(post.ID)))
 	var attention int64
 	_ = base.GetDb(false).Model(&base.Attention{}).Where(&base.Attention{PostID: post.ID, UserID: user.ID}).Count(&attention).Error
+	var liked int64
+	_ = base.GetDb(false).Model(&base.Like{}).Where(&base.Like{PostID: post.ID, UserID: user.ID}).Count(&liked).Error
 
 	votes, err4 := getVotesInPosts(base.GetDb(false), &user, []base.Post{post})
 	if err4 != nil {
 		base.HttpReturnWithCodeMinusOne(c, logger.NewError(err4, "GetVotesInPostsFailed", consts.DatabaseReadFailedString))
 		return
 	}
+	postMedia, err5 := getMediaInPosts(base.GetDb(false), []base.Post{post})
+	if err5 != nil {
+		base.HttpReturnWithCodeMinusOne(c, logger.NewError(err5, "GetMediaInPostsFailed", consts.DatabaseReadFailedString))
+		return
+	}
+
+	quotes, err8 := getQuotesInPosts(base.GetDb(false), &user, []base.Post{post})
+	if err8 != nil {
+		base.HttpReturnWithCodeMinusOne(c, logger.NewError(err8, "GetQuotesInPostsFailed", consts.DatabaseReadFailedString))
+		return
+	}
+	references, err7 := base.GetReferencedBy(post.ID, canViewDelete)
+	if err7 != nil {
+		base.HttpReturnWithCodeMinusOne(c, logger.NewError(err7, "GetReferencedByFailed", consts.DatabaseReadFailedString))
+		return
+	}
+	reactions, err10 := getReactionsInPosts(base.GetDb(false), &user, []base.Post{post})
+	if err10 != nil {
+		base.HttpReturnWithCodeMinusOne(c, logger.NewError(err10, "GetReactionsInPostsFailed", consts.DatabaseReadFailedString))
+		return
+	}
+	series, err9 := getSeriesOfPostJson(base.GetDb(false), post.ID, canViewDelete)
+	if err9 != nil {
+		base.HttpReturnWithCodeMinusOne(c, logger.NewError(err9, "GetSeriesOfPostFailed", consts.DatabaseReadFailedString))
+		return
+	}
+	// 只有洞主能看到自己禁止回复的化名
+	var blockedCommenters []string
+	if post.UserID == user.ID {
+		blockedCommenters, err9 = base.GetBlockedCommenterNames(base.GetDb(false), post.ID)
+		if err9 != nil {
+			base.HttpReturnWithCodeMinusOne(c, logger.NewError(err9, "GetBlockedCommentersFailed", consts.DatabaseReadFailedString))
+			return
+		}
+	}
 
 	if (c.Query("include_comment") == "0") ||
 		(c.Query("old_updated_at") == strconv.Itoa(int(post.UpdatedAt.Unix()-offset))) {
+		// 不返回回复时同样记录已读，视为看过最新的回复
+		if attention == 1 {
+			var lastCommentID int32
+			err11 := base.GetDb(true).Model(&base.Comment{}).Where("post_id = ?", post.ID).
+				Select("COALESCE(MAX(id), 0)").Scan(&lastCommentID).Error
+			if err11 == nil && lastCommentID > 0 {
+				err11 = base.MarkPostRead(user.ID, post.ID, lastCommentID)
+			}
+			if err11 != nil {
+				log.Printf("mark post read failed: %s\n", err11)
+			}
+		}
 		c.JSON(http.StatusOK, gin.H{
-			"code": 1,
-			"data": nil,
-			"post": postToJson(&post, &user, attention == 1, votes[post.ID]),
+			"code":               1,
+			"data":               nil,
+			"post":               postToJson(&post, &user, attention == 1, liked == 1, votes[post.ID], postMedia[post.ID], quotes[post.ID], reactions[post.ID]),
+			"referenced_by":      referencesToJson(references),
+			"series":             series,
+			"blocked_commenters": blockedCommenters,
 		})
 		return
 	}
@@ -110,12 +174,32 @@ func detailPost(c *gin.Context) {
 		return
 	}
 
-	data := commentsToJson(comments, &user)
+	commentMedia, err6 := getMediaInComments(base.GetDb(false), comments)
+	if err6 != nil {
+		base.HttpReturnWithCodeMinusOne(c, logger.NewError(err6, "GetMediaInCommentsFailed", consts.DatabaseReadFailedString))
+		return
+	}
+
+	commentReactions, err10 := getReactionsInComments(base.GetDb(false), &user, comments)
+	if err10 != nil {
+		base.HttpReturnWithCodeMinusOne(c, logger.NewError(err10, "GetReactionsInCommentsFailed", consts.DatabaseReadFailedString))
+		return
+	}
+	// 记录关注的树洞中看过的回复，用于计算未读回复数
+	if attention == 1 && len(comments) > 0 {
+		if err11 := base.MarkPostRead(user.ID, post.ID, comments[len(comments)-1].ID); err11 != nil {
+			log.Printf("mark post read failed: %s\n", err11)
+		}
+	}
+	data := commentsToJson(comments, &user, post.BoardID, commentMedia, commentReactions)
 	post.ReplyNum = int32(totalComments) // 更新为总评论数
 	c.JSON(http.StatusOK, gin.H{
 		"code": 0,
 		"data": utils.IfThenElse(data != nil, data, []string{}),
-		"post": postToJson(&post, &user, attention == 1, votes[post.ID]),
+		"post": postToJson(&post, &user, attention == 1, liked == 1, votes[post.ID], postMedia[post.ID], quotes[post.ID], reactions[post.ID]),
+		"referenced_by": referencesToJson(references),
+		"series": series,
+		"blocked_commenters": blockedCommenters,
 		"comment_pagination": gin.H{
 			"total":      totalComments,
 			"page":       commentPage,
@@ -126,13 +210,23 @@ func detailPost(c *gin.Context) {
 	return
 }
 
-func postToJson(post *base.Post, user *base.User, attention bool, voted string) gin.H {
-	offset := utils.CalcExtra(user.ForgetPwNonce, strconv.Itoa(int(post.ID)))
-	imageMetadata := map[string]int{}
-	err2 := json.Unmarshal([]byte(post.FileMetadata), &imageMetadata)
-	if err2 != nil {
-		log.Printf("bad image metadata in pid=%d: err=%s\n", post.ID, err2)
+// referencesToJson pid和cid为引用来源，ref_cid为被引用的回复，为0时引用的是树洞本身
+func referencesToJson(refs []base.PostReference) []gin.H {
+	data := make([]gin.H, 0, len(refs))
+	for _, ref := range refs {
+		data = append(data, gin.H{
+			"pid":     ref.SrcPostID,
+			"cid":     ref.SrcCommentID,
+			"ref_cid": ref.CommentID,
+		})
 	}
+	return data
+}
+
+func postToJson(post *base.Post, user *base.User, attention bool, liked bool, voted string, media []base.PostMedia,
+	quote gin.H, reactions gin.H) gin.H {
+	offset := utils.CalcExtra(user.ForgetPwNonce, strconv.Itoa(int(post.ID)))
+	url, imageMetadata := firstImageJson(media)
 	tag := post.Tag
 	if post.ReportNum >= 3 && !post.DeletedAt.Valid && tag == "" {
 		tag = "举报较多"
@@ -164,21 +258,34 @@ func postToJson(post *base.Post, user *base.User, attention bool, voted string)
 		"updated_at":     post.UpdatedAt.Unix() - offset,
 		"reply":          post.ReplyNum,
 		"likenum":        post.LikeNum,
+		"liked":          liked,
+		"follownum":      post.FollowNum,
 		"attention":      attention,
 		"permissions":    base.GetPermissionsByPost(user, post),
 		"deleted":        post.DeletedAt.Valid,
-		"url":            utils.GetHashedFilePath(post.FilePath),
+		"url":            url,
 		"tag":            utils.IfThenElse(len(tag) == 0, nil, tag),
 		"image_metadata": imageMetadata,
+		"images":         mediaToJson(media),
+		"audio":          audioToJson(media),
 		"vote":           vote,
+		"quote":          quote,
+		"official":       utils.IfThenElse(len(post.OfficialName) == 0, nil, gin.H{"name": post.OfficialName}),
+		"board_id":       post.BoardID,
+		"comment_controls": commentControlsToJson(post),
+		"reactions":      reactions,
 	}
 }
 
-func postsToJson(posts []base.Post, user *base.User, attentionPids []int32, voted map[int32]string) []gin.H {
+func postsToJson(posts []base.Post, user *base.User, attentionPids []int32, likedPids []int32, voted map[int32]string,
+	media map[int32][]base.PostMedia, quotes map[int32]gin.H, reactions map[int32]gin.H) []gin.H {
 	data := make([]gin.H, 0, len(posts))
 	attentionPidsSet := utils.Int32SliceToSet(attentionPids)
+	likedPidsSet := utils.Int32SliceToSet(likedPids)
 	for _, post := range posts {
-		data = append(data, postToJson(&post, user, utils.Int32IsInSet(post.ID, attentionPidsSet), voted[post.ID]))
+		data = append(data, postToJson(&post, user, utils.Int32IsInSet(post.ID, attentionPidsSet),
+			utils.Int32IsInSet(post.ID, likedPidsSet), voted[post.ID],
+			media[post.ID], quotes[post.ID], reactions[post.ID]))
 	}
 	return data
 }
@@ -218,16 +325,101 @@ func getVotesInPosts(tx *gorm.DB, user *base.User, posts []base.Post) (map[int32
 	return rtn, nil
 }
 
+// getQuotesInPosts 获取引用转发的树洞或回复的内容，被引用的内容删除后显示为"原内容已删除"
+func getQuotesInPosts(tx *gorm.DB, user *base.User, posts []base.Post) (map[int32]gin.H, error) {
+	var quotePids, quoteCids []int32
+	for _, post := range posts {
+		if post.QuoteCommentID > 0 {
+			quoteCids = append(quoteCids, post.QuoteCommentID)
+		} else if post.QuotePostID > 0 {
+			quotePids = append(quotePids, post.QuotePostID)
+		}
+	}
+	rtn := make(map[int32]gin.H)
+	if len(quotePids)+len(quoteCids) == 0 {
+		return rtn, nil
+	}
+
+	quotedPosts := make(map[int32]base.Post)
+	if len(quotePids) > 0 {
+		var qPosts []base.Post
+		if err := tx.Unscoped().Where("id in ?", quotePids).Find(&qPosts).Error; err != nil {
+			return nil, err
+		}
+		for _, p := range qPosts {
+			quotedPosts[p.ID] = p
+		}
+	}
+	quotedComments := make(map[int32]base.Comment)
+	if len(quoteCids) > 0 {
+		var qComments []base.Comment
+		if err := tx.Unscoped().Where("id in ?", quoteCids).Find(&qComments).Error; err != nil {
+			return nil, err
+		}
+		for _, c := range qComments {
+			quotedComments[c.ID] = c
+		}
+	}
+
+	for _, post := range posts {
+		if post.QuotePostID == 0 {
+			continue
+		}
+		quote := gin.H{
+			"pid":     post.QuotePostID,
+			"cid":     post.QuoteCommentID,
+			"text":    "原内容已删除",
+			"type":    "text",
+			"name":    nil,
+			"deleted": true,
+		}
+		if post.QuoteCommentID > 0 {
+			if comment, ok := quotedComments[post.QuoteCommentID]; ok && !comment.DeletedAt.Valid {
+				offset := utils.CalcExtra(user.ForgetPwNonce, strconv.Itoa(int(comment.ID)))
+				quote["text"] = comment.Text
+				quote["type"] = comment.Type
+				quote["name"] = comment.Name
+				quote["deleted"] = false
+				quote["timestamp"] = comment.CreatedAt.Unix() - offset
+			}
+		} else if quoted, ok := quotedPosts[post.QuotePostID]; ok && !quoted.DeletedAt.Valid {
+			offset := utils.CalcExtra(user.ForgetPwNonce, strconv.Itoa(int(quoted.ID)))
+			quote["text"] = quoted.Text
+			quote["type"] = quoted.Type
+			quote["deleted"] = false
+			quote["timestamp"] = quoted.CreatedAt.Unix() - offset
+		}
+		rtn[post.ID] = quote
+	}
+	return rtn, nil
+}
+
 func appendPostDetail(tx *gorm.DB, posts []base.Post, user *base.User) ([]gin.H, *logger.InternalError) {
 	attentionPids, err3 := getAttentionPidsInPosts(tx, user, posts)
 	if err3 != nil {
 		return nil, logger.NewError(err3, "getAttentionPidsInPosts failed", consts.DatabaseReadFailedString)
 	}
+	likedPids, err8 := getLikedPidsInPosts(tx, user, posts)
+	if err8 != nil {
+		return nil, logger.NewError(err8, "getLikedPidsInPosts failed", consts.DatabaseReadFailedString)
+	}
 	votes, err4 := getVotesInPosts(tx, user, posts)
 	if err4 != nil {
 		return nil, logger.NewError(err4, "getVotesInPosts failed", consts.DatabaseReadFailedString)
 	}
-	jsPosts := postsToJson(posts, user, attentionPids, votes)
+	media, err5 := getMediaInPosts(tx, posts)
+	if err5 != nil {
+		return nil, logger.NewError(err5, "getMediaInPosts failed", consts.DatabaseReadFailedString)
+	}
+	quotes, err6 := getQuotesInPosts(tx, user, posts)
+	if err6 != nil {
+		return nil, logger.NewError(err6, "getQuotesInPosts failed", consts.DatabaseReadFailedString)
+	}
+	reactions, err7 := getReactionsInPosts(tx, user, posts)
+	if err7 != nil {
+		return nil, logger.NewError(err7, "getReactionsInPosts failed", consts.DatabaseReadFailedString)
+	}
+	jsPosts := postsToJson(posts, user, attentionPids, likedPids, votes, media, quotes, reactions)
 	return jsPosts, nil
 }
 
@@ -238,14 +430,29 @@ func getCommentsByPosts(posts []base.Post, user *base.User) (map[int32][]gin.H,
 		return nil, err4
 	}
 	//TODO: (low priority) update reply_num
+	previewMap := make(map[int32][]base.Comment)
+	var previews []base.Comment
 	for pid, tmp := range commentsMap {
 		if len(tmp) > 3 {
 			tmp = tmp[:3]
 		}
 		if len(tmp) > 0 {
-			comments[pid] = commentsToJson(tmp, user)
+			previewMap[pid] = tmp
+			previews = append(previews, tmp...)
 		}
 	}
+	media, err5 := getMediaInComments(base.GetDb(false), previews)
+	if err5 != nil {
+		return nil, logger.NewError(err5, "GetMediaInCommentsFailed", consts.DatabaseReadFailedString)
+	}
+	reactions, err6 := getReactionsInComments(base.GetDb(false), user, previews)
+	if err6 != nil {
+		return nil, logger.NewError(err6, "GetReactionsInCommentsFailed", consts.DatabaseReadFailedString)
+	}
+	boardIDs := boardIDsOfPosts(posts)
+	for pid, tmp := range previewMap {
+		comments[pid] = commentsToJson(tmp, user, boardIDs[pid], media, reactions)
+	}
 	return comments, nil
 }
 
@@ -253,7 +460,8 @@ func listPost(c *gin.Context) {
 	user := c.MustGet("user").(base.User)
 	canViewDelete := base.CanViewDeletedPost(&user)
 	page := c.MustGet("page").(int)
-	posts, err2 := base.ListPosts(base.GetDb(false), page, &user)
+	boardID := getBoardIDQuery(c)
+	posts, err2 := base.ListPosts(base.GetDb(false), page, &user, boardID)
 	if err2 != nil {
 		base.HttpReturnWithCodeMinusOne(c, logger.NewError(err2, "ListPostsFailed", consts.DatabaseReadFailedString))
 		return
@@ -264,7 +472,7 @@ func listPost(c *gin.Context) {
 	var configInfo gin.H
 	if page == 1 {
 		configInfo = config.GetFrontendConfigInfo()
-		if len(pinnedPids) > 0 {
+		if len(pinnedPids) > 0 && boardID == 0 {
 			var pinnedPosts []base.Post
 			err3 := base.GetDb(canViewDelete).Where(pinnedPids).Order("id desc").Find(&pinnedPosts).Error
 			if err3 != nil {
@@ -387,7 +595,7 @@ func searchPost(c *gin.Context) {
 	}
 
 	posts, err2 := base.SearchPosts(page, keywords, nil, user,
-		model.SearchOrderFromString(c.Query("order")), includeComment, beforeTimestamp, afterTimestamp)
+		model.SearchOrderFromString(c.Query("order")), includeComment, beforeTimestamp, afterTimestamp, getBoardIDQuery(c))
 	if err2 != nil {
 		base.HttpReturnWithCodeMinusOne(c, logger.NewError(err2, "SearchPostsFailed", consts.DatabaseReadFailedString))
 		return
@@ -407,6 +615,8 @@ func searchPost(c *gin.Context) {
 		return
 	}
 	//TODO: (low priority) update reply_num
+	matchedMap := make(map[int32][]base.Comment)
+	var matched []base.Comment
 	for pid, tmp := range commentsMap {
 		var commentsContainsKeywords []base.Comment
 		for _, comment := range tmp {
@@ -422,9 +632,24 @@ func searchPost(c *gin.Context) {
 			//}
 		}
 		if len(commentsContainsKeywords) > 0 {
-			comments[pid] = commentsToJson(commentsContainsKeywords, &user)
+			matchedMap[pid] = commentsContainsKeywords
+			matched = append(matched, commentsContainsKeywords...)
 		}
 	}
+	media, err6 := getMediaInComments(base.GetDb(false), matched)
+	if err6 != nil {
+		base.HttpReturnWithCodeMinusOne(c, logger.NewError(err6, "GetMediaInCommentsFailed", consts.DatabaseReadFailedString))
+		return
+	}
+	reactions, err7 := getReactionsInComments(base.GetDb(false), &user, matched)
+	if err7 != nil {
+		base.HttpReturnWithCodeMinusOne(c, logger.NewError(err7, "GetReactionsInCommentsFailed", consts.DatabaseReadFailedString))
+		return
+	}
+	boardIDs := boardIDsOfPosts(posts)
+	for pid, tmp := range matchedMap {
+		comments[pid] = commentsToJson(tmp, &user, boardIDs[pid], media, reactions)
+	}
 
 	c.JSON(http.StatusOK, gin.H{
 		"code": 0,
@@ -447,9 +672,14 @@ func searchAttentionPost(c *gin.Context) {
 		return
 	}
 
+	collectionID, err9 := getCollectionID(base.GetDb(false), c.Query("collection_id"), &user)
+	if err9 != nil {
+		base.HttpReturnWithCodeMinusOne(c, err9)
+		return
+	}
+
 	var attentionPids []int32
-	err3 := base.GetDb(canViewDelete).Model(&base.Attention{}).
-		Where("user_id = ?", user.ID).
+	err3 := attentionsInCollection(base.GetDb(canViewDelete), user.ID, collectionID).
 		Pluck("post_id", &attentionPids).Error
 	if err3 != nil {
 		base.HttpReturnWithCodeMinusOne(c, logger.NewError(err3, "GetAttentionPidsFailed", consts.DatabaseReadFailedString))
@@ -457,7 +687,7 @@ func searchAttentionPost(c *gin.Context) {
 	}
 
 	posts, err2 := base.SearchPosts(page, keywords, attentionPids, user,
-		model.SearchOrderFromString(c.Query("order")), true, -1, -1)
+		model.SearchOrderFromString(c.Query("order")), true, -1, -1, 0)
 	if err2 != nil {
 		base.HttpReturnWithCodeMinusOne(c, logger.NewError(err2, "SearchPostsFailed", consts.DatabaseReadFailedString))
 		return
@@ -467,12 +697,42 @@ func searchAttentionPost(c *gin.Context) {
 		base.HttpReturnWithCodeMinusOne(c, logger.NewError(err4, "GetVotesInPostsFailed", consts.DatabaseReadFailedString))
 		return
 	}
-	jsPosts := postsToJson(posts, &user, attentionPids, votes)
+	media, err5 := getMediaInPosts(base.GetDb(false), posts)
+	if err5 != nil {
+		base.HttpReturnWithCodeMinusOne(c, logger.NewError(err5, "GetMediaInPostsFailed", consts.DatabaseReadFailedString))
+		return
+	}
+	quotes, err6 := getQuotesInPosts(base.GetDb(false), &user, posts)
+	if err6 != nil {
+		base.HttpReturnWithCodeMinusOne(c, logger.NewError(err6, "GetQuotesInPostsFailed", consts.DatabaseReadFailedString))
+		return
+	}
+	reactions, err7 := getReactionsInPosts(base.GetDb(false), &user, posts)
+	if err7 != nil {
+		base.HttpReturnWithCodeMinusOne(c, logger.NewError(err7, "GetReactionsInPostsFailed", consts.DatabaseReadFailedString))
+		return
+	}
+	likedPids, err8 := getLikedPidsInPosts(base.GetDb(false), &user, posts)
+	if err8 != nil {
+		base.HttpReturnWithCodeMinusOne(c, logger.NewError(err8, "GetLikedPidsInPostsFailed", consts.DatabaseReadFailedString))
+		return
+	}
+	pids := make([]int32, 0, len(posts))
+	for _, post := range posts {
+		pids = append(pids, post.ID)
+	}
+	notes, err10 := getAttentionNotes(base.GetDb(false), user.ID, pids)
+	if err10 != nil {
+		base.HttpReturnWithCodeMinusOne(c, logger.NewError(err10, "GetAttentionNotesFailed", consts.DatabaseReadFailedString))
+		return
+	}
+	jsPosts := postsToJson(posts, &user, attentionPids, likedPids, votes, media, quotes, reactions)
 
 	c.JSON(http.StatusOK, gin.H{
 		"code":  0,
 		"data":  utils.IfThenElse(jsPosts != nil, jsPosts, []string{}),
 		"count": utils.IfThenElse(jsPosts != nil, len(jsPosts), 0),
+		"notes": notes,
 	})
 	return
 }
@@ -485,9 +745,23 @@ func attentionPosts(c *gin.Context) {
 	offset := (page - 1) * consts.PageSize
 	limit := consts.PageSize
 
+	collectionID, err10 := getCollectionID(base.GetDb(false), c.Query("collection_id"), &user)
+	if err10 != nil {
+		base.HttpReturnWithCodeMinusOne(c, err10)
+		return
+	}
+
+	// 先把redis中的已读记录写入数据库，未读回复数和筛选都只查询数据库
+	if err12 := base.FlushReadState(user.ID); err12 != nil {
+		log.Printf("flush read state failed: %s\n", err12)
+	}
+	query := attentionsInCollection(base.GetDb(canViewDelete), user.ID, collectionID)
+	if c.Query("unread_only") == "1" {
+		query = query.Where("exists (select 1 from comments where " + unreadCommentsExpr + ")")
+	}
+
 	var attentionPids []int32
-	err3 := base.GetDb(canViewDelete).Model(&base.Attention{}).
-		Where("user_id = ?", user.ID).Order("post_id desc").Limit(limit).Offset(offset).
+	err3 := query.Order("post_id desc").Limit(limit).Offset(offset).
 		Pluck("post_id", &attentionPids).Error
 	if err3 != nil {
 		base.HttpReturnWithCodeMinusOne(c, logger.NewError(err3, "GetAttentionPidsFailed", consts.DatabaseReadFailedString))
@@ -505,6 +779,21 @@ func attentionPosts(c *gin.Context) {
 		base.HttpReturnWithCodeMinusOne(c, logger.NewError(err4, "GetAttentionPostsVoteFailed", consts.DatabaseReadFailedString))
 		return
 	}
+	media, err6 := getMediaInPosts(base.GetDb(false), posts)
+	if err6 != nil {
+		base.HttpReturnWithCodeMinusOne(c, logger.NewError(err6, "GetAttentionPostsMediaFailed", consts.DatabaseReadFailedString))
+		return
+	}
+	quotes, err7 := getQuotesInPosts(base.GetDb(false), &user, posts)
+	if err7 != nil {
+		base.HttpReturnWithCodeMinusOne(c, logger.NewError(err7, "GetAttentionPostsQuotesFailed", consts.DatabaseReadFailedString))
+		return
+	}
+	reactions, err8 := getReactionsInPosts(base.GetDb(false), &user, posts)
+	if err8 != nil {
+		base.HttpReturnWithCodeMinusOne(c, logger.NewError(err8, "GetAttentionPostsReactionsFailed", consts.DatabaseReadFailedString))
+		return
+	}
 
 	comments, err5 := getCommentsByPosts(posts, &user)
 	if err5 != nil {
@@ -512,13 +801,30 @@ func attentionPosts(c *gin.Context) {
 		return
 	}
 
-	data := postsToJson(posts, &user, attentionPids, votes)
+	likedPids, err9 := getLikedPidsInPosts(base.GetDb(false), &user, posts)
+	if err9 != nil {
+		base.HttpReturnWithCodeMinusOne(c, logger.NewError(err9, "GetAttentionPostsLikesFailed", consts.DatabaseReadFailedString))
+		return
+	}
+	notes, err11 := getAttentionNotes(base.GetDb(false), user.ID, attentionPids)
+	if err11 != nil {
+		base.HttpReturnWithCodeMinusOne(c, logger.NewError(err11, "GetAttentionNotesFailed", consts.DatabaseReadFailedString))
+		return
+	}
+	unread, err12 := getUnreadCommentCounts(base.GetDb(false), user.ID, attentionPids)
+	if err12 != nil {
+		base.HttpReturnWithCodeMinusOne(c, logger.NewError(err12, "GetUnreadCommentCountsFailed", consts.DatabaseReadFailedString))
+		return
+	}
+	data := postsToJson(posts, &user, attentionPids, likedPids, votes, media, quotes, reactions)
 	c.JSON(http.StatusOK, gin.H{
 		"code": 0,
 		"data": utils.IfThenElse(data != nil, data, []string{}),
 		//"timestamp": utils.GetTimeStamp(),
 		"count":    utils.IfThenElse(data != nil, len(data), 0),
 		"comments": comments,
+		"notes":    notes,
+		"unread":   unread,
 	})
 	return
 
@@ -556,13 +862,14 @@ func myMsgs(c *gin.Context) {
 	user := c.MustGet("user").(base.User)
 	page := c.MustGet("page").(int)
 	pushOnly := c.Query("push_only") == "1"
+	unreadOnly := c.Query("unread_only") == "1"
 
 	sinceId, err := strconv.Atoi(c.Query("since_id"))
 	if err != nil {
 		sinceId = -1
 	}
 
-	msgs, err2 := base.ListMsgs(page, int32(sinceId), user.ID, pushOnly)
+	msgs, err2 := base.ListMsgs(page, int32(sinceId), user.ID, pushOnly, unreadOnly)
 	if err2 != nil {
 		base.HttpReturnWithCodeMinusOne(c, logger.NewError(err2, "ListMsgsFailed", consts.DatabaseReadFailedString))
 		return
@@ -574,9 +881,10 @@ func myMsgs(c *gin.Context) {
 			"title":     msg.Title,
 			"body":      utils.TrimText(msg.Message, 100),
 			"type":      msg.Type,
+			"read":      msg.IsRead,
 			"timestamp": msg.UpdatedAt.Unix(),
 		}
-		if (msg.Type & (model.ReplyMeComment | model.CommentInFavorited)) > 0 {
+		if (msg.Type & (model.ReplyMeComment | model.CommentInFavorited | model.PostReferenced | model.PostQuoted | model.PrivateMessage)) > 0 {
 			p["pid"] = msg.PostID
 			p["cid"] = msg.CommentID
 		}
@@ -1,7 +1,6 @@
 package contents
 
 import (
-	"bytes"
 	"context"
 	"errors"
 	"fmt"
@@ -11,17 +10,14 @@ import (
 	"gorm.io/gorm/clause"
 	"log"
 	"net/http"
-	"path/filepath"
 	"regexp"
 	"strconv"
 	"strings"
-	"time"
 	"treehollow-v3-backend/pkg/base"
 	"treehollow-v3-backend/pkg/bot"
 	"treehollow-v3-backend/pkg/consts"
 	"treehollow-v3-backend/pkg/logger"
 	"treehollow-v3-backend/pkg/queue"
-	"treehollow-v3-backend/pkg/s3"
 	"treehollow-v3-backend/pkg/utils"
 )
 
@@ -56,7 +52,8 @@ func containRiskWords(text string) (string, bool) {
 func sendPost(c *gin.Context) {
 	text := c.PostForm("text")
 	typ := c.PostForm("type")
-	img := c.PostForm("data")
+	imgs := c.MustGet("images").([]string)
+	alts := c.MustGet("alt_texts").([]string)
 	user := c.MustGet("user").(base.User)
 
 	strVoteData := c.MustGet("vote_data").(string)
@@ -66,70 +63,103 @@ func sendPost(c *gin.Context) {
 		tag = generateTag(text)
 	}
 
-	var pid int32
-	var err error
-	var imgPath string
-	var suffix2 string
-	var uploadChan chan bool
-	uploadChan = nil
-	ctx, cancel := context.WithCancel(context.Background())
-	defer cancel()
-	if typ == "image" {
-		imgPath = utils.GenToken()
-		sDec, suffix, metaStr, err2 := utils.SaveImage(img, imgPath)
-		suffix2 = suffix
-		if err2 != nil {
-			base.HttpReturnWithCodeMinusOne(c, err2)
-			return
-		}
+	quotePid, quoteCid, err2 := getQuoteParameter(c)
+	if err2 != nil {
+		base.HttpReturnWithCodeMinusOne(c, err2)
+		return
+	}
+	officialName, err2 := getOfficialParameter(c, &user)
+	if err2 != nil {
+		base.HttpReturnWithCodeMinusOne(c, err2)
+		return
+	}
+	boardID, err2 := getBoardParameter(c, &user, typ)
+	if err2 != nil {
+		base.HttpReturnWithCodeMinusOne(c, err2)
+		return
+	}
+	nameTheme := c.PostForm("name_theme")
+	if _, ok := utils.GetNameTheme(nameTheme); !ok {
+		base.HttpReturnWithCodeMinusOne(c, logger.NewSimpleError("InvalidNameTheme", "发送失败，化名主题不存在", logger.WARN))
+		return
+	}
 
-		pid, err = base.SavePost(user.ID, text, tag, typ, imgPath+suffix, metaStr, strVoteData)
-		if err == nil && len(viper.GetString("DCSecretKey")) > 0 {
-			uploadChan = make(chan bool, 1)
-			go func() {
-				err4 := s3.Upload(imgPath[:2]+"/"+imgPath+suffix, bytes.NewReader(sDec))
-				if err4 != nil {
-					log.Printf("S3 upload failed, err=%s\n", err4)
-				}
+	media, files, err2 := saveImages(imgs, alts)
+	if err2 != nil {
+		base.HttpReturnWithCodeMinusOne(c, err2)
+		return
+	}
+	media, err2 = appendUploadedMedia(media, user.ID, c.MustGet("media_ids").([]int32), alts)
+	if err2 != nil {
+		base.HttpReturnWithCodeMinusOne(c, err2)
+		return
+	}
+	media, files, err2 = appendAudio(media, files, c.MustGet("audio").(string), c.MustGet("audio_peaks").([]int))
+	if err2 != nil {
+		base.HttpReturnWithCodeMinusOne(c, err2)
+		return
+	}
+	block, blockedMedia, err := matchImageBlock(media)
+	if err != nil {
+		base.HttpReturnWithCodeMinusOne(c, logger.NewError(err, "GetImageBlocksFailed", consts.DatabaseReadFailedString))
+		return
+	}
+	if block != nil && !block.Hold {
+		reportBlockedImage(block, blockedMedia, "new post", user.ID)
+		base.HttpReturnWithCodeMinusOne(c, logger.NewSimpleError("ImageBlocked", "图片违反社区规范，无法发送", logger.WARN))
+		return
+	}
 
-				select {
-				default:
-					uploadChan <- true
-				case <-ctx.Done():
-					return
-				}
-			}()
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	var hold func(tx *gorm.DB, pid int32) error
+	if block != nil {
+		// 在保存树洞的事务中直接删除，避免树洞在等待审核前公开可见
+		hold = func(tx *gorm.DB, pid int32) error {
+			return holdByImageBlock(tx, block, user.ID, pid, 0)
 		}
-	} else {
-		pid, err = base.SavePost(user.ID, text, tag, typ, "", "{}", strVoteData)
 	}
-
-	if err != nil {
+	pid, err := base.SavePost(user.ID, text, tag, typ, strVoteData, media, quotePid, quoteCid, nameTheme, officialName, boardID, hold)
+	if errors.Is(err, base.ErrMediaUnavailable) {
+		base.HttpReturnWithCodeMinusOne(c, logger.NewSimpleError("MediaUnavailable", "图片不存在或已被使用，请重新上传", logger.WARN))
+		return
+	} else if err != nil {
 		base.HttpReturnWithCodeMinusOne(c, logger.NewError(err, "SendPostSaveFailed", consts.DatabaseWriteFailedString))
 		return
 	} else {
-		if uploadChan != nil {
-			//wait until upload complete.
-			select {
-			case <-uploadChan:
-
-			case <-time.After(15 * time.Second):
-				log.Println("image upload timeout")
-			}
+		waitForUpload(uploadImages(ctx, files))
+		enqueueImageProcessing(media)
+
+		if block != nil {
+			reportBlockedImage(block, blockedMedia, fmt.Sprintf("post #%d", pid), user.ID)
+			c.JSON(http.StatusOK, gin.H{
+				"code":    0,
+				"post_id": pid,
+				"msg":     "图片需要管理员审核，审核通过后才会显示",
+			})
+			return
 		}
 
 		c.JSON(http.StatusOK, gin.H{
 			"code":    0,
 			"post_id": pid,
 		})
+		enqueueReferenceNotification(text, user.ID, pid, 0)
+		if quotePid > 0 {
+			payload := queue.QuoteNotificationPayload{
+				PostID:         pid,
+				QuotePostID:    quotePid,
+				QuoteCommentID: quoteCid,
+				UserID:         user.ID,
+			}
+			if err := queue.Enqueue(queue.TaskQuoteNotify, payload); err != nil {
+				log.Printf("Failed to enqueue quote notification task: %v", err)
+			}
+		}
 
 		if word, b := containRiskWords(text); viper.GetBool("enable_telegram") && b {
-			fullImgPath := ""
-			if typ == "image" {
-				fullImgPath = filepath.Join(viper.GetString("images_path"), imgPath[:2], imgPath+suffix2)
-			}
 			bot.TgMessageChannel <- bot.TgMessage{
-				Text: fmt.Sprintf("New post contains risk word:'%s'\n#%d\n %s", word, pid, text), ImagePath: fullImgPath,
+				Text: fmt.Sprintf("New post contains risk word:'%s'\n#%d\n %s", word, pid, text), ImagePath: localImagePath(media),
 			}
 		}
 
@@ -137,10 +167,59 @@ func sendPost(c *gin.Context) {
 	}
 }
 
+// getQuoteParameter 读取引用转发的quote_pid和quote_cid，被引用的树洞或回复必须存在且未被删除
+func getQuoteParameter(c *gin.Context) (int32, int32, *logger.InternalError) {
+	quotePidStr, quoteCidStr := c.PostForm("quote_pid"), c.PostForm("quote_cid")
+	if len(quotePidStr) == 0 {
+		if len(quoteCidStr) > 0 {
+			return 0, 0, logger.NewSimpleError("InvalidQuotePid", "发送失败，引用的树洞号不合法", logger.WARN)
+		}
+		return 0, 0, nil
+	}
+	quotePid, err := strconv.Atoi(quotePidStr)
+	if err != nil || quotePid <= 0 {
+		return 0, 0, logger.NewSimpleError("InvalidQuotePid", "发送失败，引用的树洞号不合法", logger.WARN)
+	}
+	quoteCid := 0
+	if len(quoteCidStr) > 0 {
+		if quoteCid, err = strconv.Atoi(quoteCidStr); err != nil || quoteCid < 0 {
+			return 0, 0, logger.NewSimpleError("InvalidQuoteCid", "发送失败，引用的回复号不合法", logger.WARN)
+		}
+	}
+
+	var count int64
+	if quoteCid > 0 {
+		err = base.GetDb(false).Model(&base.Comment{}).Where("id = ? and post_id = ?", quoteCid, quotePid).
+			Count(&count).Error
+	} else {
+		err = base.GetDb(false).Model(&base.Post{}).Where("id = ?", quotePid).Count(&count).Error
+	}
+	if err != nil {
+		return 0, 0, logger.NewError(err, "GetQuotedPostFailed", consts.DatabaseReadFailedString)
+	}
+	if count == 0 {
+		return 0, 0, logger.NewSimpleError("QuoteNotFound", "找不到引用的树洞或回复", logger.WARN)
+	}
+	return int32(quotePid), int32(quoteCid), nil
+}
+
+// getOfficialParameter official为1时以官方身份发布，只有管理员和白名单中的机构账号可以使用
+func getOfficialParameter(c *gin.Context, user *base.User) (string, *logger.InternalError) {
+	if c.PostForm("official") != "1" {
+		return "", nil
+	}
+	name, ok := base.GetOfficialName(user)
+	if !ok || len(name) == 0 {
+		return "", logger.NewSimpleError("NoOfficialIdentity", "发送失败，你不能使用官方身份", logger.WARN)
+	}
+	return name, nil
+}
+
 func sendComment(c *gin.Context) {
 	text := c.PostForm("text")
 	typ := c.PostForm("type")
-	img := c.PostForm("data")
+	imgs := c.MustGet("images").([]string)
+	alts := c.MustGet("alt_texts").([]string)
 	pid, err := strconv.Atoi(c.PostForm("pid"))
 	if err != nil {
 		base.HttpReturnWithCodeMinusOne(c, logger.NewError(err, "SendCommentInvalidPid", "发送失败，pid不合法"))
@@ -153,14 +232,20 @@ func sendComment(c *gin.Context) {
 
 	user := c.MustGet("user").(base.User)
 	canViewDelete := base.CanViewDeletedPost(&user)
-	var imgPath string
-	var uploadChan chan bool
+	officialName, err5 := getOfficialParameter(c, &user)
+	if err5 != nil {
+		base.HttpReturnWithCodeMinusOne(c, err5)
+		return
+	}
 	var post base.Post
-	var suffix2 string
-	uploadChan = nil
+	var media []base.PostMedia
+	var files []uploadFile
 
 	var commentID int32
 	var name string
+	var mentions []utils.Mention
+	var block *base.ImageBlock
+	var blockedMedia *base.PostMedia
 
 	err7 := base.GetDb(false).Transaction(func(tx *gorm.DB) error {
 		err = utils.UnscopedTx(tx, canViewDelete).Clauses(clause.Locking{Strength: "UPDATE"}).First(&post, int32(pid)).Error
@@ -187,53 +272,82 @@ func sendComment(c *gin.Context) {
 			}
 		}
 
-		names0, names1 := consts.Names0, consts.Names1
-
-		name, err = base.GenCommenterName(tx, post.UserID, user.ID, post.ID, names0, names1)
-		if err != nil {
-			base.HttpReturnWithCodeMinusOne(c, logger.NewError(err, "GenCommenterNameFailed", consts.DatabaseReadFailedString))
-			return err
+		if err4 := checkCommentControls(tx, &post, &user); err4 != nil {
+			base.HttpReturnWithCodeMinusOne(c, err4)
+			return errors.New(err4.InternalMsg)
 		}
 
-		ctx, cancel := context.WithCancel(context.Background())
-		defer cancel()
-		if typ == "image" {
-			imgPath = utils.GenToken()
-			sDec, suffix, metaStr, err3 := utils.SaveImage(img, imgPath)
-			suffix2 = suffix
-			if err3 != nil {
-				base.HttpReturnWithCodeMinusOne(c, err3)
-				return err3.Err
+		if len(officialName) > 0 {
+			name = officialName
+		} else {
+			name, err = base.GenCommenterName(tx, post.UserID, user.ID, post.ID)
+			if err != nil {
+				base.HttpReturnWithCodeMinusOne(c, logger.NewError(err, "GenCommenterNameFailed", consts.DatabaseReadFailedString))
+				return err
 			}
+		}
 
-			commentID, err = base.SaveComment(tx, user.ID, text, "", typ, imgPath+suffix, int32(pid), int32(replyToCommentID), name, metaStr)
-			if err == nil && len(viper.GetString("DCSecretKey")) > 0 {
-				uploadChan = make(chan bool, 1)
-				go func() {
-					err4 := s3.Upload(imgPath[:2]+"/"+imgPath+suffix, bytes.NewReader(sDec))
-					if err4 != nil {
-						log.Printf("S3 upload failed, err=%s\n", err4)
-					}
-
-					select {
-					default:
-						uploadChan <- true
-					case <-ctx.Done():
-						return
-					}
-				}()
+		if strings.ContainsAny(text, "@＠") {
+			names, err6 := base.GetCommenterNames(tx, post.ID)
+			if err6 != nil {
+				base.HttpReturnWithCodeMinusOne(c, logger.NewError(err6, "GetCommenterNamesFailed", consts.DatabaseReadFailedString))
+				return err6
 			}
-		} else {
-			commentID, err = base.SaveComment(tx, user.ID, text, "", "text", "", int32(pid), int32(replyToCommentID), name, "{}")
+			mentions = utils.ParseMentions(text, names, consts.MaxMentionsPerComment, consts.MaxMentionSpansPerComment)
+		}
+
+		var err3 *logger.InternalError
+		media, files, err3 = saveImages(imgs, alts)
+		if err3 == nil {
+			media, err3 = appendUploadedMedia(media, user.ID, c.MustGet("media_ids").([]int32), alts)
+		}
+		if err3 == nil {
+			media, files, err3 = appendAudio(media, files, c.MustGet("audio").(string), c.MustGet("audio_peaks").([]int))
 		}
+		if err3 != nil {
+			base.HttpReturnWithCodeMinusOne(c, err3)
+			return errors.New(err3.InternalMsg)
+		}
+		block, blockedMedia, err = matchImageBlock(media)
 		if err != nil {
+			base.HttpReturnWithCodeMinusOne(c, logger.NewError(err, "GetImageBlocksFailed", consts.DatabaseReadFailedString))
+			return err
+		}
+		if block != nil && !block.Hold {
+			reportBlockedImage(block, blockedMedia, fmt.Sprintf("new comment in #%d", pid), user.ID)
+			base.HttpReturnWithCodeMinusOne(c, logger.NewSimpleError("ImageBlocked", "图片违反社区规范，无法发送", logger.WARN))
+			return errors.New("图片违反社区规范，无法发送")
+		}
+
+		commentID, err = base.SaveComment(tx, user.ID, text, "", typ, int32(pid), int32(replyToCommentID), name, officialName, mentions, media)
+		if errors.Is(err, base.ErrMediaUnavailable) {
+			base.HttpReturnWithCodeMinusOne(c, logger.NewSimpleError("MediaUnavailable", "图片不存在或已被使用，请重新上传", logger.WARN))
+			return err
+		} else if err != nil {
 			base.HttpReturnWithCodeMinusOne(c, logger.NewError(err, "SaveCommentFailed", consts.DatabaseWriteFailedString))
 			return err
 		}
+		if block != nil {
+			if err = holdByImageBlock(tx, block, user.ID, int32(pid), commentID); err != nil {
+				base.HttpReturnWithCodeMinusOne(c, logger.NewError(err, "HoldCommentFailed", consts.DatabaseWriteFailedString))
+				return err
+			}
+		}
 		return nil
 	})
 
-	if err7 == nil {
+	if err7 == nil && block != nil {
+		reportBlockedImage(block, blockedMedia, fmt.Sprintf("comment #%d-%d", pid, commentID), user.ID)
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+		waitForUpload(uploadImages(ctx, files))
+		enqueueImageProcessing(media)
+		c.JSON(http.StatusOK, gin.H{
+			"code":       0,
+			"comment_id": commentID,
+			"msg":        "图片需要管理员审核，审核通过后才会显示",
+		})
+	} else if err7 == nil {
 		// 将 handlePushNotification 放入消息队列
 		pushPayload := queue.PushNotificationPayload{
 			PostID:           post.ID,
@@ -242,10 +356,12 @@ func sendComment(c *gin.Context) {
 			CommentText:      text,
 			CommenterName:    name,
 			ReplyToCommentID: replyToCommentID,
+			MentionedNames:   mentionedNames(mentions),
 		}
 		if err := queue.Enqueue(queue.TaskPushNotification, pushPayload); err != nil {
 			log.Printf("Failed to enqueue push notification task: %v", err)
 		}
+		enqueueReferenceNotification(text, user.ID, post.ID, commentID)
 
 		if user.ID == post.UserID {
 			//TODO: (low priority) save this in config
@@ -256,14 +372,10 @@ func sendComment(c *gin.Context) {
 			}
 		}
 
-		if uploadChan != nil {
-			//wait until upload complete.
-			select {
-			case <-uploadChan:
-			case <-time.After(15 * time.Second):
-				log.Println("image upload timeout")
-			}
-		}
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+		waitForUpload(uploadImages(ctx, files))
+		enqueueImageProcessing(media)
 
 		c.JSON(http.StatusOK, gin.H{
 			"code":       0,
@@ -271,17 +383,38 @@ func sendComment(c *gin.Context) {
 		})
 
 		if word, b := containRiskWords(text); viper.GetBool("enable_telegram") && b {
-			fullImgPath := ""
-			if typ == "image" {
-				fullImgPath = filepath.Join(viper.GetString("images_path"), imgPath[:2], imgPath+suffix2)
-			}
 			bot.TgMessageChannel <- bot.TgMessage{
-				Text: fmt.Sprintf("New comment contains risk word:'%s'\n#%d-%d\n %s", word, pid, commentID, text), ImagePath: fullImgPath,
+				Text: fmt.Sprintf("New comment contains risk word:'%s'\n#%d-%d\n %s", word, pid, commentID, text), ImagePath: localImagePath(media),
 			}
 		}
 	}
 }
 
+// mentionedNames 回复中@的不同化名
+func mentionedNames(mentions []utils.Mention) []string {
+	var names []string
+	for _, mention := range mentions {
+		if _, ok := utils.ContainsString(names, mention.Name); !ok {
+			names = append(names, mention.Name)
+		}
+	}
+	return names
+}
+
+// enqueueReferenceNotification 文本中引用了其他树洞时，通知被引用树洞的关注者
+func enqueueReferenceNotification(text string, uid int32, pid int32, cid int32) {
+	if len(utils.ParseReferences(text, consts.MaxReferencesPerText)) == 0 {
+		return
+	}
+	payload := queue.ReferenceNotificationPayload{
+		SrcPostID:    pid,
+		SrcCommentID: cid,
+		UserID:       uid,
+	}
+	if err := queue.Enqueue(queue.TaskReferenceNotify, payload); err != nil {
+		log.Printf("Failed to enqueue reference notification task: %v", err)
+	}
+}
 
 func getReportType(typ string) base.ReportType {
 	switch typ {
@@ -297,6 +430,8 @@ func getReportType(typ string) base.ReportType {
 		return base.AdminUndelete
 	case "delete_ban":
 		return base.AdminDeleteAndBan
+	case "board_delete":
+		return base.BoardModDelete
 	case "unban":
 		return base.AdminUnban
 	default:
@@ -342,7 +477,7 @@ func handleReport(isComment bool) gin.HandlerFunc {
 
 			var userPermissions []string
 			if isComment {
-				userPermissions = base.GetPermissionsByComment(&user, &comment)
+				userPermissions = base.GetPermissionsByComment(&user, &comment, base.GetBoardIDOfPost(tx, comment.PostID))
 			} else {
 				userPermissions = base.GetPermissionsByPost(&user, &post)
 			}
@@ -394,8 +529,8 @@ func handleReport(isComment bool) gin.HandlerFunc {
 
 				if viper.GetBool("enable_telegram") {
 					fullImgPath := ""
-					if comment.Type == "image" {
-						fullImgPath = filepath.Join(viper.GetString("images_path"), comment.FilePath[:2], comment.FilePath)
+					if media, err := getMediaInComments(tx, []base.Comment{comment}); err == nil {
+						fullImgPath = localImagePath(media[comment.ID])
 					}
 					bot.TgMessageChannel <- bot.TgMessage{
 						Text: fmt.Sprintf("New user report for comment #%d-%d\nReason: %s\n\nOriginal text:\n%s", comment.PostID, comment.ID, reason, comment.Text), ImagePath: fullImgPath,
@@ -415,8 +550,8 @@ func handleReport(isComment bool) gin.HandlerFunc {
 
 				if viper.GetBool("enable_telegram") {
 					fullImgPath := ""
-					if post.Type == "image" {
-						fullImgPath = filepath.Join(viper.GetString("images_path"), post.FilePath[:2], post.FilePath)
+					if media, err := getMediaInPosts(tx, []base.Post{post}); err == nil {
+						fullImgPath = localImagePath(media[post.ID])
 					}
 					bot.TgMessageChannel <- bot.TgMessage{
 						Text: fmt.Sprintf("New user report for post #%d\nReason: %s\n\nOriginal text:\n%s", post.ID, reason, post.Text), ImagePath: fullImgPath,
@@ -459,16 +594,27 @@ func handleReport(isComment bool) gin.HandlerFunc {
 					err = base.DeleteByReport(tx, report)
 				case base.AdminTag:
 					err = base.SetTagByReport(tx, report)
-				case base.AdminDeleteAndBan:
+				case base.AdminDeleteAndBan, base.BoardModDelete:
 					uidStr := strconv.Itoa(int(user.ID))
 					ctx, _ := deleteBanLimiter.Peek(c, uidStr)
 					limit := base.GetDeletePostRateLimitIn24h(user.Role)
+					if report.Type == base.BoardModDelete {
+						boardID := post.BoardID
+						if isComment {
+							boardID = base.GetBoardIDOfPost(tx, comment.PostID)
+						}
+						limit = base.GetDeleteLimitIn24h(&user, boardID)
+					}
 					if ctx.Limit-ctx.Remaining >= limit {
 						base.HttpReturnWithCodeMinusOne(c, logger.NewSimpleError("DeletePostLimitReached",
 							"您的24h内的删帖数量已经达到系统限制", logger.WARN))
 						return errors.New("您的24h内的删帖数量已经达到系统限制")
 					}
-					err = base.DeleteAndBan(tx, report, utils.TrimText(getPostOrCommentText(&post, &comment, isComment), 20))
+					if report.Type == base.BoardModDelete {
+						err = base.DeleteByReport(tx, report)
+					} else {
+						err = base.DeleteAndBan(tx, report, utils.TrimText(getPostOrCommentText(&post, &comment, isComment), 20))
+					}
 					if err == nil {
 						_, _ = deleteBanLimiter.Get(c, uidStr)
 					}
@@ -554,12 +700,22 @@ func editAttention(c *gin.Context) {
 		}
 
 		if isAttention == 0 {
-			_ = tx.Create(&base.Attention{UserID: user.ID, PostID: post.ID}).Error
-			post.LikeNum += 1
+			// 关注时已有的回复视为已读
+			var lastCommentID int32
+			_ = tx.Unscoped().Model(&base.Comment{}).Where("post_id = ?", post.ID).
+				Select("COALESCE(MAX(id), 0)").Scan(&lastCommentID).Error
+			_ = tx.Create(&base.Attention{UserID: user.ID, PostID: post.ID, LastReadCommentID: &lastCommentID}).Error
+			post.FollowNum += 1
 		}
 		if isAttention == 1 {
 			_ = tx.Delete(&base.Attention{UserID: user.ID, PostID: post.ID}).Error
-			post.LikeNum -= 1
+			post.FollowNum -= 1
+		}
+		var liked int64
+		err2 = tx.Model(&base.Like{}).Where(&base.Like{PostID: post.ID, UserID: user.ID}).Count(&liked).Error
+		if err2 != nil {
+			base.HttpReturnWithCodeMinusOne(c, logger.NewError(err2, "GetIsLikedFailed", consts.DatabaseReadFailedString))
+			return err2
 		}
 
 		votes, err4 := getVotesInPosts(tx, &user, []base.Post{post})
@@ -567,10 +723,25 @@ func editAttention(c *gin.Context) {
 			base.HttpReturnWithCodeMinusOne(c, logger.NewError(err, "GetVotesInPostsFailed", consts.DatabaseReadFailedString))
 			return err4
 		}
+		media, err5 := getMediaInPosts(tx, []base.Post{post})
+		if err5 != nil {
+			base.HttpReturnWithCodeMinusOne(c, logger.NewError(err5, "GetMediaInPostsFailed", consts.DatabaseReadFailedString))
+			return err5
+		}
+		quotes, err6 := getQuotesInPosts(tx, &user, []base.Post{post})
+		if err6 != nil {
+			base.HttpReturnWithCodeMinusOne(c, logger.NewError(err6, "GetQuotesInPostsFailed", consts.DatabaseReadFailedString))
+			return err6
+		}
+		reactions, err7 := getReactionsInPosts(tx, &user, []base.Post{post})
+		if err7 != nil {
+			base.HttpReturnWithCodeMinusOne(c, logger.NewError(err7, "GetReactionsInPostsFailed", consts.DatabaseReadFailedString))
+			return err7
+		}
 
 		c.JSON(http.StatusOK, gin.H{
 			"code": 0,
-			"data": postToJson(&post, &user, isAttention == 0, votes[post.ID]),
+			"data": postToJson(&post, &user, isAttention == 0, liked == 1, votes[post.ID], media[post.ID], quotes[post.ID], reactions[post.ID]),
 		})
 
 		return nil
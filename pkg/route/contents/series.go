@@ -0,0 +1,240 @@
+package contents
+
+import (
+	"errors"
+	"fmt"
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+	"net/http"
+	"strconv"
+	"strings"
+	"treehollow-v3-backend/pkg/base"
+	"treehollow-v3-backend/pkg/consts"
+	"treehollow-v3-backend/pkg/logger"
+	"unicode/utf8"
+)
+
+// seriesToJson 只返回系列名和前后树洞，不暴露洞主身份
+func seriesToJson(series *base.PostSeries, prev int32, next int32) gin.H {
+	return gin.H{
+		"id":          series.ID,
+		"name":        series.Name,
+		"same_author": true,
+		"prev":        prev,
+		"next":        next,
+	}
+}
+
+// getSeriesOfPostJson 获取树洞所在系列的信息，不在系列中时返回nil
+func getSeriesOfPostJson(tx *gorm.DB, pid int32, canViewDelete bool) (gin.H, error) {
+	series, err := base.GetSeriesOfPost(tx, pid)
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	prev, next, err := base.GetSeriesNeighbours(tx, series.ID, pid, canViewDelete)
+	if err != nil {
+		return nil, err
+	}
+	return seriesToJson(&series, prev, next), nil
+}
+
+// addToSeries 把自己发布的树洞加入自己的系列，series_id为空时用name新建系列
+func addToSeries(c *gin.Context) {
+	user := c.MustGet("user").(base.User)
+	pid, err := strconv.Atoi(c.PostForm("pid"))
+	if err != nil {
+		base.HttpReturnWithCodeMinusOne(c, logger.NewSimpleError("AddToSeriesInvalidPid", "添加失败，pid不合法", logger.WARN))
+		return
+	}
+	seriesIDStr := c.PostForm("series_id")
+	name := strings.TrimSpace(c.PostForm("name"))
+	if len(seriesIDStr) == 0 {
+		if len(name) == 0 || utf8.RuneCountInString(name) > consts.SeriesNameMaxLength {
+			base.HttpReturnWithCodeMinusOne(c, logger.NewSimpleError("InvalidSeriesName",
+				fmt.Sprintf("添加失败，系列名需要在1~%d字之间", consts.SeriesNameMaxLength), logger.WARN))
+			return
+		}
+	}
+
+	_ = base.GetDb(false).Transaction(func(tx *gorm.DB) error {
+		var post base.Post
+		err2 := tx.First(&post, int32(pid)).Error
+		if err2 != nil {
+			if errors.Is(err2, gorm.ErrRecordNotFound) {
+				base.HttpReturnWithCodeMinusOne(c, logger.NewSimpleError("AddToSeriesNoPid", "添加失败，pid不存在", logger.WARN))
+			} else {
+				base.HttpReturnWithCodeMinusOne(c, logger.NewError(err2, "AddToSeriesGetPostFailed", consts.DatabaseReadFailedString))
+			}
+			return err2
+		}
+		if post.UserID != user.ID {
+			base.HttpReturnWithCodeMinusOne(c, logger.NewSimpleError("AddToSeriesNotOwner", "添加失败，只能添加自己发布的树洞", logger.WARN))
+			return errors.New("not owner")
+		}
+
+		var count int64
+		err2 = tx.Model(&base.SeriesPost{}).Where("post_id = ?", post.ID).Count(&count).Error
+		if err2 != nil {
+			base.HttpReturnWithCodeMinusOne(c, logger.NewError(err2, "AddToSeriesCountFailed", consts.DatabaseReadFailedString))
+			return err2
+		}
+		if count > 0 {
+			base.HttpReturnWithCodeMinusOne(c, logger.NewSimpleError("AlreadyInSeries", "添加失败，这条树洞已经在系列中了", logger.WARN))
+			return errors.New("already in series")
+		}
+
+		var series base.PostSeries
+		if len(seriesIDStr) > 0 {
+			seriesID, err3 := strconv.Atoi(seriesIDStr)
+			if err3 != nil {
+				base.HttpReturnWithCodeMinusOne(c, logger.NewSimpleError("InvalidSeriesID", "添加失败，系列不存在", logger.WARN))
+				return err3
+			}
+			err2 = tx.Clauses(clause.Locking{Strength: "UPDATE"}).
+				Where("id = ? and user_id = ?", seriesID, user.ID).First(&series).Error
+			if err2 != nil {
+				if errors.Is(err2, gorm.ErrRecordNotFound) {
+					base.HttpReturnWithCodeMinusOne(c, logger.NewSimpleError("SeriesNotFound", "添加失败，系列不存在", logger.WARN))
+				} else {
+					base.HttpReturnWithCodeMinusOne(c, logger.NewError(err2, "GetSeriesFailed", consts.DatabaseReadFailedString))
+				}
+				return err2
+			}
+		} else {
+			err2 = tx.Model(&base.PostSeries{}).Where("user_id = ?", user.ID).Count(&count).Error
+			if err2 != nil {
+				base.HttpReturnWithCodeMinusOne(c, logger.NewError(err2, "CountSeriesFailed", consts.DatabaseReadFailedString))
+				return err2
+			}
+			if count >= consts.MaxSeriesPerUser {
+				base.HttpReturnWithCodeMinusOne(c, logger.NewSimpleError("TooManySeries", "添加失败，你创建的系列太多了", logger.WARN))
+				return errors.New("too many series")
+			}
+			series = base.PostSeries{UserID: user.ID, Name: name}
+			if err2 = tx.Create(&series).Error; err2 != nil {
+				base.HttpReturnWithCodeMinusOne(c, logger.NewError(err2, "CreateSeriesFailed", consts.DatabaseWriteFailedString))
+				return err2
+			}
+		}
+
+		err2 = tx.Create(&base.SeriesPost{PostID: post.ID, SeriesID: series.ID}).Error
+		if err2 != nil {
+			base.HttpReturnWithCodeMinusOne(c, logger.NewError(err2, "AddToSeriesFailed", consts.DatabaseWriteFailedString))
+			return err2
+		}
+
+		prev, next, err2 := base.GetSeriesNeighbours(tx, series.ID, post.ID, false)
+		if err2 != nil {
+			base.HttpReturnWithCodeMinusOne(c, logger.NewError(err2, "GetSeriesNeighboursFailed", consts.DatabaseReadFailedString))
+			return err2
+		}
+		c.JSON(http.StatusOK, gin.H{
+			"code":   0,
+			"series": seriesToJson(&series, prev, next),
+		})
+		return nil
+	})
+}
+
+// removeFromSeries 洞主把树洞移出系列，管理员也可以解除链接，管理员操作会记录在日志中
+func removeFromSeries(c *gin.Context) {
+	user := c.MustGet("user").(base.User)
+	pid, err := strconv.Atoi(c.PostForm("pid"))
+	if err != nil {
+		base.HttpReturnWithCodeMinusOne(c, logger.NewSimpleError("RemoveFromSeriesInvalidPid", "移除失败，pid不合法", logger.WARN))
+		return
+	}
+	reason := c.PostForm("reason")
+
+	_ = base.GetDb(false).Transaction(func(tx *gorm.DB) error {
+		series, err2 := base.GetSeriesOfPost(tx, int32(pid))
+		if err2 != nil {
+			if errors.Is(err2, gorm.ErrRecordNotFound) {
+				base.HttpReturnWithCodeMinusOne(c, logger.NewSimpleError("NotInSeries", "移除失败，这条树洞不在系列中", logger.WARN))
+			} else {
+				base.HttpReturnWithCodeMinusOne(c, logger.NewError(err2, "GetSeriesOfPostFailed", consts.DatabaseReadFailedString))
+			}
+			return err2
+		}
+
+		isOwner := series.UserID == user.ID
+		if !isOwner && !base.CanBreakSeriesLink(&user) {
+			base.HttpReturnWithCodeMinusOne(c, logger.NewSimpleError("RemoveFromSeriesNoPermission", "移除失败，没有操作权限", logger.WARN))
+			return errors.New("no permission")
+		}
+
+		err2 = tx.Where("post_id = ?", pid).Delete(&base.SeriesPost{}).Error
+		if err2 != nil {
+			base.HttpReturnWithCodeMinusOne(c, logger.NewError(err2, "RemoveFromSeriesFailed", consts.DatabaseWriteFailedString))
+			return err2
+		}
+
+		if !isOwner {
+			err2 = tx.Create(&base.Report{
+				UserID:         user.ID,
+				ReportedUserID: series.UserID,
+				PostID:         int32(pid),
+				CommentID:      0,
+				Reason:         fmt.Sprintf("从系列#%d「%s」中移除 %s", series.ID, series.Name, reason),
+				Type:           base.AdminSeriesUnlink,
+				IsComment:      false,
+				Weight:         0,
+			}).Error
+			if err2 != nil {
+				base.HttpReturnWithCodeMinusOne(c, logger.NewError(err2, "SaveSeriesUnlinkReportFailed", consts.DatabaseWriteFailedString))
+				return err2
+			}
+		}
+
+		c.JSON(http.StatusOK, gin.H{
+			"code": 0,
+		})
+		return nil
+	})
+}
+
+// listSeries 列出自己创建的系列及其中的树洞
+func listSeries(c *gin.Context) {
+	user := c.MustGet("user").(base.User)
+	var series []base.PostSeries
+	err := base.GetDb(false).Where("user_id = ?", user.ID).Order("id desc").Find(&series).Error
+	if err != nil {
+		base.HttpReturnWithCodeMinusOne(c, logger.NewError(err, "ListSeriesFailed", consts.DatabaseReadFailedString))
+		return
+	}
+
+	seriesIDs := make([]int32, 0, len(series))
+	for _, s := range series {
+		seriesIDs = append(seriesIDs, s.ID)
+	}
+	var seriesPosts []base.SeriesPost
+	if len(seriesIDs) > 0 {
+		err = base.GetDb(false).Where("series_id in (?)", seriesIDs).Order("post_id asc").Find(&seriesPosts).Error
+		if err != nil {
+			base.HttpReturnWithCodeMinusOne(c, logger.NewError(err, "ListSeriesPostsFailed", consts.DatabaseReadFailedString))
+			return
+		}
+	}
+	pids := make(map[int32][]int32)
+	for _, sp := range seriesPosts {
+		pids[sp.SeriesID] = append(pids[sp.SeriesID], sp.PostID)
+	}
+
+	data := make([]gin.H, 0, len(series))
+	for _, s := range series {
+		data = append(data, gin.H{
+			"id":        s.ID,
+			"name":      s.Name,
+			"pids":      append([]int32{}, pids[s.ID]...),
+			"timestamp": s.CreatedAt.Unix(),
+		})
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"code": 0,
+		"data": data,
+	})
+}
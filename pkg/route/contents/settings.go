@@ -0,0 +1,45 @@
+package contents
+
+import (
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm/clause"
+	"net/http"
+	"treehollow-v3-backend/pkg/base"
+	"treehollow-v3-backend/pkg/consts"
+	"treehollow-v3-backend/pkg/logger"
+)
+
+// getSettings 获取与推送无关的用户偏好设置
+func getSettings(c *gin.Context) {
+	user := c.MustGet("user").(base.User)
+	settings, err := base.GetUserSettings(base.GetDb(false), user.ID)
+	if err != nil {
+		base.HttpReturnWithCodeMinusOne(c, logger.NewError(err, "GetUserSettingsFailed", consts.DatabaseReadFailedString))
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"code": 0,
+		"data": gin.H{
+			"auto_follow": boolToInt(!settings.DisableAutoFollow),
+		},
+	})
+}
+
+func setSettings(c *gin.Context) {
+	user := c.MustGet("user").(base.User)
+	autoFollow := c.DefaultPostForm("auto_follow", "1")
+
+	err := base.GetDb(false).Clauses(clause.OnConflict{
+		UpdateAll: true,
+	}).Create(&base.UserSettings{
+		UserID:            user.ID,
+		DisableAutoFollow: autoFollow == "0",
+	}).Error
+	if err != nil {
+		base.HttpReturnWithCodeMinusOne(c, logger.NewError(err, "SaveUserSettingsFailed", consts.DatabaseWriteFailedString))
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"code": 0,
+	})
+}
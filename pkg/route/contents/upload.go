@@ -0,0 +1,332 @@
+package contents
+
+import (
+	"context"
+	"errors"
+	"github.com/gin-gonic/gin"
+	"github.com/robfig/cron/v3"
+	"github.com/spf13/viper"
+	"gorm.io/gorm"
+	"io"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"time"
+	"treehollow-v3-backend/pkg/base"
+	"treehollow-v3-backend/pkg/consts"
+	"treehollow-v3-backend/pkg/logger"
+	"treehollow-v3-backend/pkg/storage"
+	"treehollow-v3-backend/pkg/utils"
+	"unicode/utf8"
+)
+
+func uploadTmpPath(uploadID string) string {
+	return filepath.Join(viper.GetString("upload_tmp_path"), uploadID)
+}
+
+func checkAltText(c *gin.Context, alt string) bool {
+	if utf8.RuneCountInString(alt) > consts.ImageAltMaxLength {
+		base.HttpReturnWithCodeMinusOne(c, logger.NewSimpleError("TooLongAltText", "图片描述过长！字数限制为"+strconv.Itoa(consts.ImageAltMaxLength)+"字。", logger.INFO))
+		return false
+	}
+	return true
+}
+
+// finishUpload 保存上传完成的图片，生成一条尚未关联树洞的PostMedia并返回给客户端
+func finishUpload(c *gin.Context, user *base.User, data []byte, alt string) {
+	media, files, err := saveImage(data, 0, alt)
+	if err != nil {
+		base.HttpReturnWithCodeMinusOne(c, err)
+		return
+	}
+	saved := []base.PostMedia{media}
+	err2 := base.GetDb(false).Transaction(func(tx *gorm.DB) error {
+		return base.SaveMedia(tx, user.ID, 0, 0, saved)
+	})
+	media = saved[0]
+	if err2 != nil {
+		base.HttpReturnWithCodeMinusOne(c, logger.NewError(err2, "SaveUploadedMediaFailed", consts.DatabaseWriteFailedString))
+		return
+	}
+	// 发送树洞前图片已经上传完成，这里不需要等待
+	uploadImages(context.Background(), files)
+	enqueueImageProcessing([]base.PostMedia{media})
+
+	c.JSON(http.StatusOK, gin.H{
+		"code":     0,
+		"media_id": media.ID,
+		"image":    mediaToJson([]base.PostMedia{media})[0],
+	})
+}
+
+// uploadImage 通过multipart/form-data的file字段上传一张图片
+func uploadImage(c *gin.Context) {
+	user := c.MustGet("user").(base.User)
+	c.Request.Body = http.MaxBytesReader(c.Writer, c.Request.Body, consts.ImgMaxLength+consts.UploadFormOverhead)
+	reader, err := c.Request.MultipartReader()
+	if err != nil {
+		base.HttpReturnWithCodeMinusOne(c, logger.NewSimpleError("InvalidUploadForm", "上传失败，请使用multipart/form-data格式", logger.WARN))
+		return
+	}
+
+	var data []byte
+	alt := ""
+	for {
+		part, err2 := reader.NextPart()
+		if err2 == io.EOF {
+			break
+		}
+		if err2 != nil {
+			base.HttpReturnWithCodeMinusOne(c, logger.NewSimpleError("TooLargeImage", "图片大小超出限制！", logger.WARN))
+			return
+		}
+		switch part.FormName() {
+		case "file":
+			data, err2 = ioutil.ReadAll(io.LimitReader(part, consts.ImgMaxLength+1))
+			if err2 != nil {
+				base.HttpReturnWithCodeMinusOne(c, logger.NewSimpleError("TooLargeImage", "图片大小超出限制！", logger.WARN))
+				return
+			}
+			if len(data) > consts.ImgMaxLength {
+				base.HttpReturnWithCodeMinusOne(c, logger.NewSimpleError("TooLargeImage", "图片大小超出限制！", logger.WARN))
+				return
+			}
+		case "alt":
+			altBytes, _ := ioutil.ReadAll(io.LimitReader(part, consts.ImageAltMaxLength*4+1))
+			alt = string(altBytes)
+		}
+		_ = part.Close()
+	}
+	if len(data) == 0 {
+		base.HttpReturnWithCodeMinusOne(c, logger.NewSimpleError("NoImage", "请上传图片", logger.INFO))
+		return
+	}
+	if !checkAltText(c, alt) {
+		return
+	}
+	finishUpload(c, &user, data, alt)
+}
+
+// createUpload 创建分片上传会话，size为图片的总字节数
+func createUpload(c *gin.Context) {
+	user := c.MustGet("user").(base.User)
+	size, err := strconv.ParseInt(c.PostForm("size"), 10, 64)
+	if err != nil || size <= 0 {
+		base.HttpReturnWithCodeMinusOne(c, logger.NewSimpleError("InvalidUploadSize", "上传失败，size不合法", logger.WARN))
+		return
+	}
+	if size > consts.ImgMaxLength {
+		base.HttpReturnWithCodeMinusOne(c, logger.NewSimpleError("TooLargeImage", "图片大小超出限制！", logger.WARN))
+		return
+	}
+	alt := c.PostForm("alt")
+	if !checkAltText(c, alt) {
+		return
+	}
+
+	upload := base.MediaUpload{
+		ID:      utils.GenToken(),
+		UserID:  user.ID,
+		Size:    size,
+		AltText: alt,
+	}
+	_ = os.MkdirAll(viper.GetString("upload_tmp_path"), os.ModePerm)
+	if err2 := ioutil.WriteFile(uploadTmpPath(upload.ID), nil, 0644); err2 != nil {
+		base.HttpReturnWithCodeMinusOne(c, logger.NewError(err2, "CreateUploadFileFailed", "上传失败，请稍后重试"))
+		return
+	}
+	if err2 := base.GetDb(false).Create(&upload).Error; err2 != nil {
+		base.HttpReturnWithCodeMinusOne(c, logger.NewError(err2, "CreateUploadFailed", consts.DatabaseWriteFailedString))
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"code":      0,
+		"upload_id": upload.ID,
+		"received":  0,
+		"size":      upload.Size,
+	})
+}
+
+func getUpload(tx *gorm.DB, c *gin.Context, user *base.User, uploadID string) (*base.MediaUpload, error) {
+	var upload base.MediaUpload
+	err := tx.Where("id = ? and user_id = ?", uploadID, user.ID).First(&upload).Error
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			base.HttpReturnWithCodeMinusOne(c, logger.NewSimpleError("UploadNotFound", "上传已过期，请重新上传", logger.WARN))
+		} else {
+			base.HttpReturnWithCodeMinusOne(c, logger.NewError(err, "GetUploadFailed", consts.DatabaseReadFailedString))
+		}
+		return nil, err
+	}
+	return &upload, nil
+}
+
+// uploadStatus 返回分片上传已接收的字节数，客户端断线后从这里继续上传
+func uploadStatus(c *gin.Context) {
+	user := c.MustGet("user").(base.User)
+	upload, err := getUpload(base.GetDb(false), c, &user, c.Query("upload_id"))
+	if err != nil {
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"code":      0,
+		"upload_id": upload.ID,
+		"received":  upload.Received,
+		"size":      upload.Size,
+	})
+}
+
+// uploadLockTTL 分片上传锁的过期时间，请求异常退出没有释放锁时，过期后客户端可以继续上传
+const uploadLockTTL = 10 * time.Minute
+
+func uploadLockKey(uploadID string) string {
+	return "webhole:upload_lock:" + uploadID
+}
+
+// uploadChunk 以请求体的原始数据上传一个分片，offset必须等于已接收的字节数。
+// 最后一个分片上传完成后返回media_id
+func uploadChunk(c *gin.Context) {
+	user := c.MustGet("user").(base.User)
+	offset, err := strconv.ParseInt(c.Query("offset"), 10, 64)
+	if err != nil {
+		base.HttpReturnWithCodeMinusOne(c, logger.NewSimpleError("InvalidUploadOffset", "上传失败，offset不合法", logger.WARN))
+		return
+	}
+
+	// 同一个上传同时只允许一个请求写入。用redis加锁，写文件期间不占用数据库连接
+	uploadID := c.Query("upload_id")
+	lockKey := uploadLockKey(uploadID)
+	locked, err := base.GetRedisClient().SetNX(context.Background(), lockKey, 1, uploadLockTTL).Result()
+	if err != nil {
+		base.HttpReturnWithCodeMinusOne(c, logger.NewError(err, "LockUploadFailed", consts.DatabaseWriteFailedString))
+		return
+	}
+	if !locked {
+		base.HttpReturnWithErr(c, -2, logger.NewSimpleError("UploadInProgress", "该图片正在上传中，请稍后查询进度后继续上传", logger.INFO))
+		return
+	}
+	defer base.GetRedisClient().Del(context.Background(), lockKey)
+
+	upload, err := getUpload(base.GetDb(false), c, &user, uploadID)
+	if err != nil {
+		return
+	}
+	if offset != upload.Received {
+		base.HttpReturnWithErr(c, -2, logger.NewSimpleError("UploadOffsetMismatch", "上传位置不正确，请从"+
+			strconv.FormatInt(upload.Received, 10)+"字节处继续上传", logger.INFO))
+		return
+	}
+
+	f, err := os.OpenFile(uploadTmpPath(upload.ID), os.O_WRONLY, 0644)
+	if err != nil {
+		base.HttpReturnWithCodeMinusOne(c, logger.NewSimpleError("UploadNotFound", "上传已过期，请重新上传", logger.WARN))
+		return
+	}
+	// 最多只读取剩余的字节数，多出的部分视为超出大小限制
+	body := http.MaxBytesReader(c.Writer, c.Request.Body, upload.Size-upload.Received)
+	n, err3 := io.Copy(io.NewOffsetWriter(f, upload.Received), body)
+	_ = f.Close()
+
+	result := base.GetDb(false).Model(&base.MediaUpload{}).Where("id = ? and received = ?", upload.ID, upload.Received).
+		Update("received", upload.Received+n)
+	if result.Error != nil {
+		base.HttpReturnWithCodeMinusOne(c, logger.NewError(result.Error, "UpdateUploadFailed", consts.DatabaseWriteFailedString))
+		return
+	}
+	if result.RowsAffected == 0 {
+		base.HttpReturnWithCodeMinusOne(c, logger.NewSimpleError("UploadNotFound", "上传已过期，请重新上传", logger.WARN))
+		return
+	}
+	upload.Received += n
+	if err3 != nil {
+		var maxBytesErr *http.MaxBytesError
+		if errors.As(err3, &maxBytesErr) {
+			base.HttpReturnWithCodeMinusOne(c, logger.NewSimpleError("TooLargeImage", "图片大小超出限制！", logger.WARN))
+		} else {
+			base.HttpReturnWithErr(c, -2, logger.NewSimpleError("UploadInterrupted", "上传中断，请从"+
+				strconv.FormatInt(upload.Received, 10)+"字节处继续上传", logger.INFO))
+		}
+		// 已写入的部分仍然保留，客户端可以继续上传
+		return
+	}
+	if upload.Received < upload.Size {
+		c.JSON(http.StatusOK, gin.H{
+			"code":      0,
+			"upload_id": upload.ID,
+			"received":  upload.Received,
+			"size":      upload.Size,
+		})
+		return
+	}
+
+	data, err := ioutil.ReadFile(uploadTmpPath(upload.ID))
+	if err != nil {
+		base.HttpReturnWithCodeMinusOne(c, logger.NewError(err, "ReadUploadFileFailed", "上传失败，请重新上传"))
+		return
+	}
+	removeUpload(upload)
+	finishUpload(c, &user, data, upload.AltText)
+}
+
+func removeUpload(upload *base.MediaUpload) {
+	_ = os.Remove(uploadTmpPath(upload.ID))
+	_ = base.GetDb(false).Delete(upload).Error
+}
+
+// gcExpiredUploads 删除超时仍未完成的分片上传，以及上传后一直没有被发送的图片
+func gcExpiredUploads() {
+	expireAt := time.Now().Add(-time.Duration(viper.GetInt("upload_expire_minutes")) * time.Minute)
+
+	var uploads []base.MediaUpload
+	err := base.GetDb(false).Where("updated_at < ?", expireAt).Find(&uploads).Error
+	if err != nil {
+		log.Printf("gc uploads failed: %s\n", err)
+		return
+	}
+	for i := range uploads {
+		removeUpload(&uploads[i])
+	}
+
+	var media []base.PostMedia
+	err = base.GetDb(false).Where("post_id = 0 and created_at < ?", expireAt).Find(&media).Error
+	if err != nil {
+		log.Printf("gc uploaded media failed: %s\n", err)
+		return
+	}
+	for _, m := range media {
+		// 先删除数据库记录，防止删除文件时图片恰好被发送；内容相同的图片仍被引用时保留文件
+		var freed []base.PostMedia
+		err = base.GetDb(false).Transaction(func(tx *gorm.DB) error {
+			result := tx.Where("id = ? and post_id = 0", m.ID).Delete(&base.PostMedia{})
+			if result.Error != nil || result.RowsAffected == 0 {
+				return result.Error
+			}
+			var err2 error
+			freed, err2 = base.ReleaseMediaBlobs(tx, []base.PostMedia{m})
+			return err2
+		})
+		if err != nil || len(freed) == 0 {
+			continue
+		}
+		for _, file := range m.Files() {
+			_ = os.Remove(filepath.Join(viper.GetString("images_path"), file))
+			if err2 := storage.Default().Delete(file); err2 != nil {
+				log.Printf("storage delete %s failed: %s\n", file, err2)
+			}
+		}
+	}
+	if len(uploads) > 0 || len(media) > 0 {
+		log.Printf("gc uploads: %d expired uploads, %d unused images removed\n", len(uploads), len(media))
+	}
+}
+
+func initUploadGCCron() {
+	c := cron.New()
+	_, _ = c.AddFunc("*/10 * * * *", func() {
+		gcExpiredUploads()
+	})
+	c.Start()
+}
@@ -93,6 +93,12 @@ func deleteAccount(c *gin.Context) {
 			return result.Error
 		}
 
+		// 邮件摘要中保存了可以解密的邮箱，注销时一并删除
+		if err3 = tx.Where("user_id = ?", user.ID).Delete(&base.DigestSettings{}).Error; err3 != nil {
+			base.HttpReturnWithCodeMinusOneAndAbort(c, logger.NewError(err3, "DeleteDigestSettingsFailed", consts.DatabaseWriteFailedString))
+			return err3
+		}
+
 		result = tx.Where("email_hash = ?", emailHash).
 			Delete(&base.Email{})
 
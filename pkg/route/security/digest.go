@@ -0,0 +1,60 @@
+package security
+
+import (
+	"github.com/gin-gonic/gin"
+	"github.com/spf13/viper"
+	"html/template"
+	"log"
+	"net/http"
+	"treehollow-v3-backend/pkg/base"
+)
+
+// unsubscribeConfirmTemplate 退订确认页，提交后POST到同一地址
+var unsubscribeConfirmTemplate = template.Must(template.New("unsubscribe").Parse(`<!DOCTYPE html>
+<html>
+<head><meta charset="utf-8"><meta name="viewport" content="width=device-width, initial-scale=1"><title>退订邮件摘要</title></head>
+<body>
+<p>确定要退订{{.Name}}的邮件摘要吗？退订后会删除为发送摘要保存的邮箱。</p>
+<form method="post">
+<input type="hidden" name="token" value="{{.Token}}">
+<button type="submit">确认退订</button>
+</form>
+</body>
+</html>
+`))
+
+// unsubscribeDigestPage 邮件中的退订链接打开的确认页。邮件扫描和链接预取会访问GET链接，因此GET不修改任何数据
+func unsubscribeDigestPage(c *gin.Context) {
+	token := c.Query("token")
+	if len(token) == 0 {
+		c.String(http.StatusBadRequest, "退订链接无效")
+		return
+	}
+	c.Status(http.StatusOK)
+	c.Header("Content-Type", "text/html; charset=utf-8")
+	err := unsubscribeConfirmTemplate.Execute(c.Writer, gin.H{"Name": viper.GetString("name"), "Token": token})
+	if err != nil {
+		log.Printf("render unsubscribe page failed: %s\n", err)
+	}
+}
+
+// unsubscribeDigest 确认页和邮件客户端的一键退订(RFC 8058)都POST到这里，用token代替登录，
+// 因此放在不检查登录的security接口中。退订后删除保存的邮箱
+func unsubscribeDigest(c *gin.Context) {
+	token := c.Query("token")
+	if len(token) == 0 {
+		token = c.PostForm("token")
+	}
+	if len(token) == 0 {
+		c.String(http.StatusBadRequest, "退订链接无效")
+		return
+	}
+	err := base.GetDb(false).Model(&base.DigestSettings{}).Where("unsubscribe_token = ?", token).
+		UpdateColumns(map[string]interface{}{"frequency": base.NoDigest, "email_encrypted": ""}).Error
+	if err != nil {
+		log.Printf("unsubscribe digest failed: %s\n", err)
+		c.String(http.StatusInternalServerError, "退订失败，请稍后重试")
+		return
+	}
+	c.String(http.StatusOK, "您已退订"+viper.GetString("name")+"的邮件摘要")
+}
@@ -61,6 +61,8 @@ func ApiListenHttp() {
 	r.POST("/v3/security/devices/terminate", terminateDevice)
 	r.POST("/v3/security/logout", logout)
 	r.POST("/v3/security/update_ios_token", updateIOSToken)
+	r.GET("/v3/security/digest/unsubscribe", unsubscribeDigestPage)
+	r.POST("/v3/security/digest/unsubscribe", unsubscribeDigest)
 
 	listenAddr := viper.GetString("security_api_listen_address")
 	if strings.Contains(listenAddr, ":") {
@@ -117,5 +119,7 @@ func AddSecurityControllers(r *gin.Engine) (*gin.Engine) {
 	r.POST("/v3/security/devices/terminate", terminateDevice)
 	r.POST("/v3/security/logout", logout)
 	r.POST("/v3/security/update_ios_token", updateIOSToken)
+	r.GET("/v3/security/digest/unsubscribe", unsubscribeDigestPage)
+	r.POST("/v3/security/digest/unsubscribe", unsubscribeDigest)
 	return r
 }
\ No newline at end of file
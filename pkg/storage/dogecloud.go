@@ -0,0 +1,111 @@
+package storage
+
+import (
+	"errors"
+	"io"
+	"sync"
+	"time"
+	dcs3 "treehollow-v3-backend/pkg/s3"
+)
+
+// DogeCloudStorage 通过DogeCloud的临时密钥访问其S3兼容存储，临时密钥过期前会被复用
+type DogeCloudStorage struct {
+	endpoint string
+	bucket   string
+	prefix   string
+
+	mu        sync.Mutex
+	client    *S3Storage
+	expiredAt time.Time
+}
+
+func NewDogeCloudStorage(endpoint string, bucket string, prefix string) *DogeCloudStorage {
+	return &DogeCloudStorage{endpoint: endpoint, bucket: bucket, prefix: prefix}
+}
+
+func (s *DogeCloudStorage) getClient() (*S3Storage, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.client != nil && time.Now().Add(time.Minute).Before(s.expiredAt) {
+		return s.client, nil
+	}
+
+	r, err := dcs3.DogeCloudAPI("/auth/tmp_token.json", map[string]interface{}{
+		"channel": "OSS_FULL",
+		"scopes":  "*",
+	}, true)
+	if err != nil {
+		return nil, err
+	}
+	data, ok := r["data"].(map[string]interface{})
+	if !ok {
+		return nil, errors.New("invalid DogeCloud response")
+	}
+	creds, ok := data["Credentials"].(map[string]interface{})
+	if !ok {
+		return nil, errors.New("invalid DogeCloud credentials")
+	}
+	accessKey, _ := creds["accessKeyId"].(string)
+	secretKey, _ := creds["secretAccessKey"].(string)
+	sessionToken, _ := creds["sessionToken"].(string)
+
+	client, err := NewS3Storage(S3Config{
+		Endpoint:     s.endpoint,
+		Region:       "automatic",
+		Bucket:       s.bucket,
+		AccessKey:    accessKey,
+		SecretKey:    secretKey,
+		SessionToken: sessionToken,
+		Prefix:       s.prefix,
+	})
+	if err != nil {
+		return nil, err
+	}
+	s.client = client
+	// 临时密钥默认有效期为2小时
+	s.expiredAt = time.Now().Add(2 * time.Hour)
+	if expiredAt, ok := data["ExpiredAt"].(float64); ok {
+		s.expiredAt = time.Unix(int64(expiredAt), 0)
+	}
+	return s.client, nil
+}
+
+func (s *DogeCloudStorage) Put(key string, r io.ReadSeeker) error {
+	client, err := s.getClient()
+	if err != nil {
+		return err
+	}
+	return client.Put(key, r)
+}
+
+func (s *DogeCloudStorage) Get(key string) (io.ReadCloser, error) {
+	client, err := s.getClient()
+	if err != nil {
+		return nil, err
+	}
+	return client.Get(key)
+}
+
+func (s *DogeCloudStorage) Delete(key string) error {
+	client, err := s.getClient()
+	if err != nil {
+		return err
+	}
+	return client.Delete(key)
+}
+
+func (s *DogeCloudStorage) SignedURL(key string, expire time.Duration) (string, error) {
+	client, err := s.getClient()
+	if err != nil {
+		return "", err
+	}
+	return client.SignedURL(key, expire)
+}
+
+func (s *DogeCloudStorage) List(fn func(key string, modTime time.Time) error) error {
+	client, err := s.getClient()
+	if err != nil {
+		return err
+	}
+	return client.List(fn)
+}
@@ -0,0 +1,114 @@
+package storage
+
+import (
+	"errors"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/credentials"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"io"
+	"strings"
+	"time"
+)
+
+type S3Config struct {
+	Endpoint       string
+	Region         string
+	Bucket         string
+	AccessKey      string
+	SecretKey      string
+	SessionToken   string
+	ForcePathStyle bool
+	// 所有文件都放在Prefix下，存储桶与其他用途共用时，清理图片只会遍历Prefix下的文件
+	Prefix string
+}
+
+// S3Storage 使用静态密钥访问兼容S3协议的对象存储，如MinIO
+type S3Storage struct {
+	client *s3.S3
+	bucket string
+	prefix string
+}
+
+func NewS3Storage(config S3Config) (*S3Storage, error) {
+	if len(config.Bucket) == 0 {
+		return nil, errors.New("s3 bucket is empty")
+	}
+	region := config.Region
+	if len(region) == 0 {
+		region = "us-east-1"
+	}
+	awsConfig := &aws.Config{
+		Credentials:      credentials.NewStaticCredentials(config.AccessKey, config.SecretKey, config.SessionToken),
+		Region:           aws.String(region),
+		S3ForcePathStyle: aws.Bool(config.ForcePathStyle),
+	}
+	if len(config.Endpoint) > 0 {
+		awsConfig.Endpoint = aws.String(config.Endpoint)
+	}
+	newSession, err := session.NewSession(awsConfig)
+	if err != nil {
+		return nil, err
+	}
+	return &S3Storage{client: s3.New(newSession), bucket: config.Bucket, prefix: config.Prefix}, nil
+}
+
+func (s *S3Storage) Put(key string, r io.ReadSeeker) error {
+	_, err := s.client.PutObject(&s3.PutObjectInput{
+		Bucket: aws.String(s.bucket),
+		Key:    aws.String(s.prefix + key),
+		Body:   r,
+	})
+	return err
+}
+
+func (s *S3Storage) Get(key string) (io.ReadCloser, error) {
+	out, err := s.client.GetObject(&s3.GetObjectInput{
+		Bucket: aws.String(s.bucket),
+		Key:    aws.String(s.prefix + key),
+	})
+	if err != nil {
+		return nil, err
+	}
+	return out.Body, nil
+}
+
+func (s *S3Storage) Delete(key string) error {
+	_, err := s.client.DeleteObject(&s3.DeleteObjectInput{
+		Bucket: aws.String(s.bucket),
+		Key:    aws.String(s.prefix + key),
+	})
+	return err
+}
+
+func (s *S3Storage) SignedURL(key string, expire time.Duration) (string, error) {
+	req, _ := s.client.GetObjectRequest(&s3.GetObjectInput{
+		Bucket: aws.String(s.bucket),
+		Key:    aws.String(s.prefix + key),
+	})
+	return req.Presign(expire)
+}
+
+// List 只遍历prefix下的文件，未配置prefix时拒绝遍历，避免误删存储桶中的其他文件
+func (s *S3Storage) List(fn func(key string, modTime time.Time) error) error {
+	if len(s.prefix) == 0 {
+		return ErrNoMediaPrefix
+	}
+	var fnErr error
+	err := s.client.ListObjectsV2Pages(&s3.ListObjectsV2Input{
+		Bucket: aws.String(s.bucket),
+		Prefix: aws.String(s.prefix),
+	}, func(page *s3.ListObjectsV2Output, lastPage bool) bool {
+		for _, obj := range page.Contents {
+			key := strings.TrimPrefix(aws.StringValue(obj.Key), s.prefix)
+			if fnErr = fn(key, aws.TimeValue(obj.LastModified)); fnErr != nil {
+				return false
+			}
+		}
+		return true
+	})
+	if fnErr != nil {
+		return fnErr
+	}
+	return err
+}
@@ -0,0 +1,97 @@
+package storage
+
+import (
+	"errors"
+	"github.com/spf13/viper"
+	"io"
+	"log"
+	"strings"
+	"sync"
+	"time"
+	"treehollow-v3-backend/pkg/config"
+)
+
+// Storage 图片等文件的存储后端，key为文件的哈希路径，如"ab/abcdef.jpeg"
+type Storage interface {
+	Put(key string, r io.ReadSeeker) error
+	Get(key string) (io.ReadCloser, error)
+	Delete(key string) error
+	SignedURL(key string, expire time.Duration) (string, error)
+}
+
+// Lister 可以遍历所有文件的存储后端，用于清理无用的图片
+type Lister interface {
+	List(fn func(key string, modTime time.Time) error) error
+}
+
+// ErrNoMediaPrefix 对象存储未配置storage_media_prefix，不能确定哪些文件是图片，因此不允许遍历
+var ErrNoMediaPrefix = errors.New("storage_media_prefix is not set, refusing to list the whole bucket")
+
+// mediaPrefix 对象存储中图片所在的目录，非空时以"/"结尾
+func mediaPrefix() string {
+	prefix := strings.Trim(viper.GetString("storage_media_prefix"), "/")
+	if len(prefix) == 0 {
+		return ""
+	}
+	return prefix + "/"
+}
+
+var (
+	mu      sync.RWMutex
+	current Storage
+)
+
+func init() {
+	config.OnRefresh(Refresh)
+}
+
+// Default 返回当前配置的存储后端
+func Default() Storage {
+	mu.RLock()
+	rtn := current
+	mu.RUnlock()
+	if rtn == nil {
+		Refresh()
+		mu.RLock()
+		rtn = current
+		mu.RUnlock()
+	}
+	return rtn
+}
+
+// Refresh 根据配置文件中的storage_backend重新创建存储后端
+func Refresh() {
+	var s Storage
+	var err error
+	backend := viper.GetString("storage_backend")
+	// 兼容旧配置：未指定后端但配置了DogeCloud密钥时使用DogeCloud
+	if len(backend) == 0 && len(viper.GetString("DCSecretKey")) > 0 {
+		backend = "dogecloud"
+	}
+	switch backend {
+	case "s3":
+		s, err = NewS3Storage(S3Config{
+			Endpoint:       viper.GetString("s3_endpoint"),
+			Region:         viper.GetString("s3_region"),
+			Bucket:         viper.GetString("s3_bucket"),
+			AccessKey:      viper.GetString("s3_access_key"),
+			SecretKey:      viper.GetString("s3_secret_key"),
+			ForcePathStyle: viper.GetBool("s3_force_path_style"),
+			Prefix:         mediaPrefix(),
+		})
+	case "dogecloud":
+		s = NewDogeCloudStorage(viper.GetString("DCS3Endpoint"), viper.GetString("DCS3Bucket"), mediaPrefix())
+	default:
+		backend = "local"
+		s = NewLocalStorage(viper.GetString("images_path"), viper.GetString("img_base_url"))
+	}
+	if err != nil {
+		log.Printf("storage backend %s init failed, falling back to local storage: %s\n", backend, err)
+		backend = "local"
+		s = NewLocalStorage(viper.GetString("images_path"), viper.GetString("img_base_url"))
+	}
+	mu.Lock()
+	current = s
+	mu.Unlock()
+	log.Printf("storage backend: %s\n", backend)
+}
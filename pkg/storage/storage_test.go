@@ -0,0 +1,104 @@
+package storage
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeS3 只实现了path-style的PutObject、GetObject和DeleteObject，用来代替MinIO
+type fakeS3 struct {
+	mu      sync.Mutex
+	objects map[string][]byte
+}
+
+func (f *fakeS3) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if !strings.HasPrefix(r.Header.Get("Authorization"), "AWS4-HMAC-SHA256 Credential=minio/") {
+		w.WriteHeader(http.StatusForbidden)
+		return
+	}
+	switch r.Method {
+	case http.MethodPut:
+		body, _ := ioutil.ReadAll(r.Body)
+		f.objects[r.URL.Path] = body
+	case http.MethodGet:
+		body, ok := f.objects[r.URL.Path]
+		if !ok {
+			w.WriteHeader(http.StatusNotFound)
+			_, _ = w.Write([]byte(`<?xml version="1.0" encoding="UTF-8"?><Error><Code>NoSuchKey</Code></Error>`))
+			return
+		}
+		_, _ = w.Write(body)
+	case http.MethodDelete:
+		delete(f.objects, r.URL.Path)
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		w.WriteHeader(http.StatusMethodNotAllowed)
+	}
+}
+
+func testStorage(t *testing.T, s Storage) {
+	if err := s.Put("ab/abcd.jpeg", strings.NewReader("hello")); err != nil {
+		t.Fatal(err)
+	}
+	r, err := s.Get("ab/abcd.jpeg")
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, _ := ioutil.ReadAll(r)
+	_ = r.Close()
+	if string(data) != "hello" {
+		t.Errorf("got %q", data)
+	}
+	if err = s.Delete("ab/abcd.jpeg"); err != nil {
+		t.Fatal(err)
+	}
+	if r, err = s.Get("ab/abcd.jpeg"); err == nil {
+		_ = r.Close()
+		t.Error("object should be deleted")
+	}
+}
+
+func TestLocalStorage(t *testing.T) {
+	s := NewLocalStorage(t.TempDir(), "https://img.example.com/")
+	testStorage(t, s)
+	url, _ := s.SignedURL("ab/abcd.jpeg", time.Minute)
+	if url != "https://img.example.com/ab/abcd.jpeg" {
+		t.Errorf("url = %s", url)
+	}
+	if err := s.Delete("ab/not-exist.jpeg"); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestS3Storage(t *testing.T) {
+	fake := &fakeS3{objects: make(map[string][]byte)}
+	server := httptest.NewServer(fake)
+	defer server.Close()
+
+	s, err := NewS3Storage(S3Config{
+		Endpoint:       server.URL,
+		Bucket:         "images",
+		AccessKey:      "minio",
+		SecretKey:      "minio123",
+		ForcePathStyle: true,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	testStorage(t, s)
+
+	url, err := s.SignedURL("ab/abcd.jpeg", time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(url, server.URL+"/images/ab/abcd.jpeg?") || !strings.Contains(url, "X-Amz-Signature=") {
+		t.Errorf("url = %s", url)
+	}
+}
@@ -0,0 +1,112 @@
+package utils
+
+import (
+	"github.com/spf13/viper"
+	"log"
+	"sort"
+	"strconv"
+	"sync"
+	"treehollow-v3-backend/pkg/consts"
+)
+
+// NameTheme 回复者化名主题。第n位回复者依次得到Names1中的名字、一个Names0修饰词加名字、
+// 两个修饰词加名字……，名字永远不会用完
+type NameTheme struct {
+	ID          string   `mapstructure:"-"`
+	DisplayName string   `mapstructure:"display_name"`
+	Separator   string   `mapstructure:"separator"`
+	Names0      []string `mapstructure:"names0"`
+	Names1      []string `mapstructure:"names1"`
+}
+
+const DefaultNameTheme = ""
+
+type nameThemesRW struct {
+	mu     sync.RWMutex
+	themes []NameTheme
+}
+
+var nameThemes nameThemesRW
+
+func defaultNameTheme() NameTheme {
+	return NameTheme{
+		ID:          DefaultNameTheme,
+		DisplayName: "默认",
+		Separator:   " ",
+		Names0:      consts.Names0,
+		Names1:      consts.Names1,
+	}
+}
+
+// RefreshNameThemes 从配置文件的pseudonym_themes读取化名主题，id过长或names0、names1为空的主题会被忽略
+func RefreshNameThemes() {
+	var configured map[string]NameTheme
+	if err := viper.UnmarshalKey("pseudonym_themes", &configured); err != nil {
+		log.Printf("pseudonym_themes load failed: %s\n", err)
+	}
+	themes := []NameTheme{defaultNameTheme()}
+	ids := make([]string, 0, len(configured))
+	for id := range configured {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+	for _, id := range ids {
+		theme := configured[id]
+		if id == DefaultNameTheme || len(id) > 20 || len(theme.Names0) == 0 || len(theme.Names1) == 0 {
+			log.Printf("pseudonym theme %s ignored: id must be 1-20 characters, names0 and names1 must not be empty\n", id)
+			continue
+		}
+		theme.ID = id
+		if len(theme.DisplayName) == 0 {
+			theme.DisplayName = id
+		}
+		themes = append(themes, theme)
+	}
+
+	nameThemes.mu.Lock()
+	nameThemes.themes = themes
+	nameThemes.mu.Unlock()
+}
+
+// GetNameThemes 获取所有化名主题，第一个为默认主题
+func GetNameThemes() []NameTheme {
+	nameThemes.mu.RLock()
+	defer nameThemes.mu.RUnlock()
+	if len(nameThemes.themes) == 0 {
+		return []NameTheme{defaultNameTheme()}
+	}
+	return nameThemes.themes
+}
+
+// GetNameTheme 按id获取化名主题，主题不存在(比如已经从配置中删除)时返回默认主题
+func GetNameTheme(id string) (NameTheme, bool) {
+	for _, theme := range GetNameThemes() {
+		if theme.ID == id {
+			return theme, true
+		}
+	}
+	return defaultNameTheme(), false
+}
+
+// CommenterName 第id位回复者的化名，id为0时是洞主
+func (theme *NameTheme) CommenterName(id int) string {
+	return GetCommenterName(id, theme.Names0, theme.Names1, theme.Separator)
+}
+
+// GetCommenterName 把id-1拆成names1的下标和一个双射进制的names0修饰词序列，
+// 前26*27个名字与"修饰词 名字"的两段式命名一致
+func GetCommenterName(id int, names0 []string, names1 []string, separator string) string {
+	if id == 0 {
+		return consts.DzName
+	}
+	if len(names0) == 0 || len(names1) == 0 {
+		return strconv.Itoa(id)
+	}
+	k := id - 1
+	name := names1[k%len(names1)]
+	for q := k / len(names1); q > 0; q /= len(names0) {
+		q--
+		name = names0[q%len(names0)] + separator + name
+	}
+	return name
+}
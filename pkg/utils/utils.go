@@ -2,6 +2,7 @@ package utils
 
 import (
 	"bytes"
+	"crypto/hmac"
 	"crypto/rand"
 	"crypto/sha256"
 	"encoding/base32"
@@ -28,7 +29,9 @@ import (
 	"strings"
 	"time"
 	"treehollow-v3-backend/pkg/consts"
+	"treehollow-v3-backend/pkg/imaging"
 	"treehollow-v3-backend/pkg/logger"
+	"unicode/utf8"
 )
 
 var AllowedSubnets []*net.IPNet
@@ -96,19 +99,6 @@ func ContainsInt(s []int, e int) (int, bool) {
 	return i, false
 }
 
-func GetCommenterName(id int, names0 []string, names1 []string) string {
-	switch {
-	case id == 0:
-		return consts.DzName
-	case id <= 26:
-		return names1[id-1]
-	case id <= 26*27:
-		return names0[(id-1)/26-1] + " " + names1[(id-1)%26]
-	default:
-		return consts.ExtraNamePrefix + strconv.Itoa(id-26*27)
-	}
-}
-
 //func remove(s []int, i int) []int {
 //	s[len(s)-1], s[i] = s[i], s[len(s)-1]
 //	return s[:len(s)-1]
@@ -143,12 +133,17 @@ func GetHashedFilePath(filePath string) string {
 	return filePath
 }
 
-func SaveImage(base64img string, imgPath string) ([]byte, string, string, *logger.InternalError) {
-	var suffix string
-	sDec, err2 := base64.StdEncoding.DecodeString(base64img)
-	if err2 != nil {
-		return nil, "", "{}", logger.NewSimpleError("InvalidImgBase64", "图片数据不合法", logger.WARN)
+func DecodeImageBase64(base64img string) ([]byte, *logger.InternalError) {
+	sDec, err := base64.StdEncoding.DecodeString(base64img)
+	if err != nil {
+		return nil, logger.NewSimpleError("InvalidImgBase64", "图片数据不合法", logger.WARN)
 	}
+	return sDec, nil
+}
+
+// PrepareImage 校验图片并去除元数据，返回处理后的数据、后缀名和metadata
+func PrepareImage(sDec []byte) ([]byte, string, string, *logger.InternalError) {
+	var suffix string
 	fileType := http.DetectContentType(sDec)
 	if fileType != "image/jpeg" && fileType != "image/jpg" && fileType != "image/png" && fileType != "image/gif" {
 		return nil, "", "{}", logger.NewSimpleError("InvalidImgType", "图片数据不合法", logger.WARN)
@@ -161,6 +156,15 @@ func SaveImage(base64img string, imgPath string) ([]byte, string, string, *logge
 	if im.Width > consts.ImageMaxWidth || im.Height > consts.ImageMaxHeight {
 		return nil, "", "{}", logger.NewSimpleError("TooLargeImg", "图片过大", logger.WARN)
 	}
+	sDec, err = imaging.StripMetadata(sDec)
+	if err != nil {
+		return nil, "", "{}", logger.NewError(err, "ImageStripMetadataFailed", "图片解析失败")
+	}
+	// 去除EXIF时可能按方向旋转了图片，重新读取宽高
+	im, _, err = image.DecodeConfig(bytes.NewReader(sDec))
+	if err != nil {
+		return nil, "", "{}", logger.NewError(err, "ImageDecodeFailed", "图片解析失败")
+	}
 	metadataBytes, err := json.Marshal(map[string]int{"w": im.Width, "h": im.Height})
 	if err != nil {
 		return nil, "", "{}", logger.NewError(err, "ImageSizeDecodeFailed", "图片大小解析失败")
@@ -173,14 +177,147 @@ func SaveImage(base64img string, imgPath string) ([]byte, string, string, *logge
 	} else {
 		suffix = ".jpeg"
 	}
+	return sDec, suffix, string(metadataBytes), nil
+}
 
-	hashedPath := filepath.Join(viper.GetString("images_path"), imgPath[:2])
-	_ = os.MkdirAll(hashedPath, os.ModePerm)
-	err3 := ioutil.WriteFile(filepath.Join(hashedPath, imgPath+suffix), sDec, 0644)
-	if err3 != nil {
-		return nil, suffix, string(metadataBytes), logger.NewError(err3, "ErrorSavingImage", "图片存储失败")
+// ContentHash 返回去除元数据后的图片内容的sha256
+func ContentHash(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+// PerceptualHash 计算图片的aHash和dHash，用于匹配稍作修改后重新上传的图片
+func PerceptualHash(data []byte) (int64, int64, *logger.InternalError) {
+	img, _, err := image.Decode(bytes.NewReader(data))
+	if err != nil {
+		return 0, 0, logger.NewError(err, "ImageDecodeFailed", "图片解析失败")
 	}
-	return sDec, suffix, string(metadataBytes), nil
+	return int64(imaging.AverageHash(img)), int64(imaging.DifferenceHash(img)), nil
+}
+
+// NewMediaFilePath 生成随机文件名。内容哈希只作为MediaBlob中去重的依据，不用作文件名，
+// 否则持有同一张图片的人可以算出地址，探测这张图片是否被发过
+func NewMediaFilePath(suffix string) string {
+	return GenToken() + suffix
+}
+
+// WriteImage 把图片写入images_path下的哈希路径
+func WriteImage(filePath string, data []byte) *logger.InternalError {
+	hashedPath := filepath.Join(viper.GetString("images_path"), GetHashedFilePath(filePath))
+	_ = os.MkdirAll(filepath.Dir(hashedPath), os.ModePerm)
+	if err := ioutil.WriteFile(hashedPath, data, 0644); err != nil {
+		return logger.NewError(err, "ErrorSavingImage", "图片存储失败")
+	}
+	return nil
+}
+
+// ProcessImage 为已保存的图片生成缩略图、blurhash和可选的WebP版本，
+// 返回合并后的image_metadata以及衍生文件的哈希路径
+func ProcessImage(filePath string, metaStr string) (string, []string, *logger.InternalError) {
+	hashedPath := GetHashedFilePath(filePath)
+	dir := filepath.Join(viper.GetString("images_path"), filepath.Dir(hashedPath))
+	cwebp := ""
+	if viper.GetBool("enable_webp") {
+		cwebp = viper.GetString("cwebp_path")
+	}
+	derived, err := imaging.Process(dir, filePath, viper.GetIntSlice("image_thumbnail_widths"), cwebp)
+	if err != nil {
+		return metaStr, nil, logger.NewError(err, "ImageProcessFailed", "图片处理失败")
+	}
+
+	metadata := map[string]interface{}{}
+	_ = json.Unmarshal([]byte(metaStr), &metadata)
+	metadata["blurhash"] = derived.BlurHash
+	thumbnails := make([]map[string]interface{}, 0, len(derived.Thumbnails))
+	for _, t := range derived.Thumbnails {
+		thumbnails = append(thumbnails, map[string]interface{}{
+			"w": t.Width, "h": t.Height, "url": GetHashedFilePath(t.File),
+		})
+	}
+	metadata["thumbnails"] = thumbnails
+	if len(derived.WebP) > 0 {
+		metadata["webp"] = GetHashedFilePath(derived.WebP)
+	}
+	metadataBytes, err := json.Marshal(metadata)
+	if err != nil {
+		return metaStr, nil, logger.NewError(err, "ImageMetadataMarshalFailed", "图片处理失败")
+	}
+
+	files := derived.Files()
+	for i := range files {
+		files[i] = GetHashedFilePath(files[i])
+	}
+	return string(metadataBytes), files, nil
+}
+
+// Reference 文本中以#pid或#pid-cid形式引用的树洞或回复，CommentID为0时引用的是树洞本身
+type Reference struct {
+	PostID    int32
+	CommentID int32
+}
+
+var referenceRegex = regexp.MustCompile(`[#＃](\d{1,9})(?:-(\d{1,9}))?`)
+
+// ParseReferences 解析文本中的#pid和#pid-cid引用，去重后按出现顺序返回至多max个
+func ParseReferences(text string, max int) []Reference {
+	var refs []Reference
+	seen := make(map[Reference]bool)
+	for _, match := range referenceRegex.FindAllStringSubmatch(text, -1) {
+		pid, err := strconv.Atoi(match[1])
+		if err != nil || pid <= 0 {
+			continue
+		}
+		cid := 0
+		if len(match[2]) > 0 {
+			if cid, err = strconv.Atoi(match[2]); err != nil {
+				continue
+			}
+		}
+		ref := Reference{PostID: int32(pid), CommentID: int32(cid)}
+		if !seen[ref] {
+			seen[ref] = true
+			refs = append(refs, ref)
+			if len(refs) >= max {
+				break
+			}
+		}
+	}
+	return refs
+}
+
+// Mention 文本中@化名的位置，Start和End是以Unicode字符计的下标，范围包含@，不包含End
+type Mention struct {
+	Name  string `json:"name"`
+	Start int    `json:"start"`
+	End   int    `json:"end"`
+}
+
+// ParseMentions 解析文本中对names中化名的@，化名可能包含空格，有多个化名匹配时取最长的。
+// 至多返回maxNames个不同化名的出现位置，总数不超过maxSpans
+func ParseMentions(text string, names []string, maxNames int, maxSpans int) []Mention {
+	var mentions []Mention
+	seen := make(map[string]bool)
+	runes := []rune(text)
+	for i := 0; i < len(runes) && len(mentions) < maxSpans; i++ {
+		if runes[i] != '@' && runes[i] != '＠' {
+			continue
+		}
+		rest := string(runes[i+1:])
+		matched := ""
+		for _, name := range names {
+			if len(name) > len(matched) && strings.HasPrefix(rest, name) {
+				matched = name
+			}
+		}
+		if len(matched) == 0 || (!seen[matched] && len(seen) >= maxNames) {
+			continue
+		}
+		seen[matched] = true
+		end := i + 1 + utf8.RuneCountInString(matched)
+		mentions = append(mentions, Mention{Name: matched, Start: i, End: end})
+		i = end - 1
+	}
+	return mentions
 }
 
 func CalcExtra(str1 string, str2 string) int64 {
@@ -190,6 +327,22 @@ func CalcExtra(str1 string, str2 string) int64 {
 	return rtn
 }
 
+// AuthorshipToken 以服务端密钥和用户id为HMAC密钥，对树洞号做签名，用于在不暴露账号的情况下证明自己是洞主。
+// 密钥只保存在服务端配置中，不能用邮件中的找回密码信息伪造
+func AuthorshipToken(key string, uid int32, pid int32) string {
+	mac := hmac.New(sha256.New, []byte(key+":"+strconv.Itoa(int(uid))))
+	mac.Write([]byte("authorship:" + strconv.Itoa(int(pid))))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// VerifyAuthorshipToken 检查token是否是为uid发布的pid签发的，key为空时任何人都能伪造签名，一律视为无效
+func VerifyAuthorshipToken(key string, uid int32, pid int32, token string) bool {
+	if len(key) == 0 {
+		return false
+	}
+	return hmac.Equal([]byte(AuthorshipToken(key, uid, pid)), []byte(strings.ToLower(token)))
+}
+
 type void struct{}
 
 var member void
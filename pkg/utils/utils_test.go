@@ -1,6 +1,7 @@
 package utils
 
 import (
+	"strings"
 	"testing"
 )
 
@@ -23,3 +24,85 @@ func TestCheckMail(t *testing.T) {
 		}
 	}
 }
+
+func TestContentHash(t *testing.T) {
+	hash := ContentHash([]byte("treehollow"))
+	if hash != ContentHash([]byte("treehollow")) || hash == ContentHash([]byte("treehollow2")) {
+		t.Error("content hash should only depend on content")
+	}
+	filePath := NewMediaFilePath(".jpeg")
+	if len(filePath) > 60 || filePath == NewMediaFilePath(".jpeg") || strings.Contains(filePath, hash[:8]) {
+		t.Errorf("bad media file path %s", filePath)
+	}
+}
+
+func TestParseReferences(t *testing.T) {
+	refs := ParseReferences("见#123和＃45-6，还有#123 #0 #7-", 10)
+	expected := []Reference{{123, 0}, {45, 6}, {7, 0}}
+	if len(refs) != len(expected) {
+		t.Fatalf("refs = %v", refs)
+	}
+	for i := range refs {
+		if refs[i] != expected[i] {
+			t.Errorf("refs[%d] = %v, expected %v", i, refs[i], expected[i])
+		}
+	}
+	if refs = ParseReferences("#1 #2 #3", 2); len(refs) != 2 {
+		t.Errorf("refs should be limited, got %v", refs)
+	}
+}
+
+func TestParseMentions(t *testing.T) {
+	names := []string{"洞主", "Alice", "Angry Alice", "Bob"}
+	mentions := ParseMentions("@Angry Alice 和＠Bob，还有@Carol @Alice@Bob", names, 2, 10)
+	expected := []Mention{{"Angry Alice", 0, 12}, {"Bob", 14, 18}, {"Bob", 34, 38}}
+	if len(mentions) != len(expected) {
+		t.Fatalf("mentions = %v", mentions)
+	}
+	for i := range mentions {
+		if mentions[i] != expected[i] {
+			t.Errorf("mentions[%d] = %v, expected %v", i, mentions[i], expected[i])
+		}
+	}
+	if mentions = ParseMentions("@洞主", names, 10, 10); len(mentions) != 1 || mentions[0].End != 3 {
+		t.Errorf("mention of dz = %v", mentions)
+	}
+	if mentions = ParseMentions(strings.Repeat("@Bob ", 100), names, 10, 20); len(mentions) != 20 {
+		t.Errorf("mention spans should be capped, got %d", len(mentions))
+	}
+}
+
+func TestAuthorshipToken(t *testing.T) {
+	token := AuthorshipToken("key", 1, 1234)
+	if !VerifyAuthorshipToken("key", 1, 1234, token) {
+		t.Error("token should verify against the same key, uid and pid")
+	}
+	if VerifyAuthorshipToken("key", 1, 1235, token) || VerifyAuthorshipToken("key", 2, 1234, token) ||
+		VerifyAuthorshipToken("key2", 1, 1234, token) {
+		t.Error("token should not verify against another pid, uid or key")
+	}
+	if VerifyAuthorshipToken("", 1, 1234, AuthorshipToken("", 1, 1234)) {
+		t.Error("token signed with an empty key should not verify")
+	}
+	if VerifyAuthorshipToken("key", 1, 1234, "") {
+		t.Error("empty token should not verify")
+	}
+}
+
+func TestGetCommenterName(t *testing.T) {
+	names0, names1 := []string{"A", "B"}, []string{"x", "y", "z"}
+	expected := []string{"洞主", "x", "y", "z", "A x", "A y", "A z", "B x", "B y", "B z", "A A x"}
+	for id, name := range expected {
+		if got := GetCommenterName(id, names0, names1, " "); got != name {
+			t.Errorf("commenter %d is expected to be %s, got %s", id, name, got)
+		}
+	}
+	seen := make(map[string]bool)
+	for id := 1; id <= 1000; id++ {
+		name := GetCommenterName(id, names0, names1, "")
+		if seen[name] {
+			t.Errorf("commenter name %s is duplicated", name)
+		}
+		seen[name] = true
+	}
+}